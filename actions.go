@@ -0,0 +1,214 @@
+package dusl
+
+import (
+  "fmt"
+)
+
+// An Action computes the value of a trace from the values of its sub-traces, see
+// Actions.
+type Action func(ctx *ActionContext) interface{}
+
+// An ActionContext is passed to an Action. The Trace field refers to the trace of
+// which the value is computed, the Values field contains the values of its sub-traces
+// in the order of Trace.Subs. Actions report errors by means of Error and Errorf
+// rather than by failing, such that all errors are gathered in a single run.
+type ActionContext struct {
+  Trace *Trace
+  Values []interface{}
+  errs *[]error
+}
+
+// Value returns the value of the sub-trace of the placeholder with the given name, see
+// Trace.Sub.
+func (this *ActionContext) Value(name string) interface{} {
+  if this.Trace.tmpl != nil {
+    if index, ok := this.Trace.tmpl.subNames[name]; ok {
+      return this.Values[index]
+    }
+  }
+  panic(this.Trace.missingName("sub-trace", name))
+}
+
+// Cat returns the captured category node of the placeholder with the given name, see
+// Trace.Cat.
+func (this *ActionContext) Cat(name string) *Syntax {
+  return this.Trace.Cat(name)
+}
+
+// Error reports an error located at the syntax node of the trace.
+func (this *ActionContext) Error(msg string) {
+  *this.errs = append(*this.errs, AmbitError(this.Trace.Syn.Ambit, msg))
+}
+
+// Errorf reports a formatted error located at the syntax node of the trace.
+func (this *ActionContext) Errorf(format string, args ...interface{}) {
+  this.Error(fmt.Sprintf(format, args...))
+}
+
+// Actions is a set of semantic actions for the rules of a grammar that builds values,
+// such as the nodes of an abstract syntax tree, directly from traces. The actions are
+// applied bottom-up: the action of a rule alternative is invoked with the values of
+// the sub-traces already computed. A trace without an action gets a default value:
+// the elements of a list placeholder (see Trace.List) as an []interface{}, the value
+// of the element of an optional placeholder (see Trace.Opt) or nil if it is absent,
+// the value of the sole sub-trace of a rule alternative with exactly one sub-trace,
+// such as "X is> (X)", and nil otherwise.
+type Actions interface {
+  // OnRule sets the action of the named rule alternative of the given label (see
+  // Trace.Alt). The name must not be empty, use OnIndex for unnamed alternatives.
+  OnRule(lbl string, alt string, action Action) Actions
+  // OnIndex sets the action of the rule alternative of the given label with the given
+  // index (see Trace.Idx).
+  OnIndex(lbl string, idx int, action Action) Actions
+  // OnLabel sets the action of all rule alternatives of the given label that have no
+  // action of their own.
+  OnLabel(lbl string, action Action) Actions
+  // Apply returns the value of the given trace. The errors of the trace are returned
+  // without applying any actions, as are errors in the registration of the actions.
+  // Otherwise the errors reported by the actions are returned, if any.
+  Apply(trace *Trace) (interface{}, error)
+  // Trace is short for Apply(tracer.Trace(ambit, lbl)).
+  Trace(ambit *Ambit, lbl string) (interface{}, error)
+  // TraceUndent is short for Apply(tracer.TraceUndent(source, lbl)).
+  TraceUndent(source *Source, lbl string) (interface{}, error)
+}
+
+type actions struct {
+  tracer *tracer
+  rules map[string][]Action
+  labels map[string]Action
+  err error
+}
+
+// Actions returns a new, empty set of semantic actions for the grammar of this
+// tracer.
+func (this *tracer) Actions() Actions {
+  return &actions{ tracer: this, rules: make(map[string][]Action), labels: make(map[string]Action) }
+}
+
+func (this *actions) OnRule(lbl string, alt string, action Action) Actions {
+  if alt == "" {
+    return this.fail(fmt.Errorf("action for unnamed rule alternative of label, use OnIndex: '%s'", lbl))
+  }
+  for index, template := range this.tracer.templates[lbl] {
+    if template.alt == alt {
+      return this.OnIndex(lbl, index, action)
+    }
+  }
+  if len(this.tracer.templates[lbl]) == 0 {
+    return this.fail(fmt.Errorf("action for label without rules: '%s'", lbl))
+  }
+  return this.fail(fmt.Errorf("action for undeclared rule alternative of label '%s': '%s'", lbl, alt))
+}
+
+func (this *actions) OnIndex(lbl string, idx int, action Action) Actions {
+  templates := this.tracer.templates[lbl]
+  if len(templates) == 0 {
+    return this.fail(fmt.Errorf("action for label without rules: '%s'", lbl))
+  }
+  if idx < 0 || idx >= len(templates) {
+    return this.fail(fmt.Errorf("action for rule alternative %d of label with %d alternative(s): '%s'", idx, len(templates), lbl))
+  }
+  if this.rules[lbl] == nil {
+    this.rules[lbl] = make([]Action, len(templates))
+  }
+  if this.rules[lbl][idx] != nil {
+    return this.fail(fmt.Errorf("double action for rule alternative %d of label: '%s'", idx, lbl))
+  }
+  this.rules[lbl][idx] = action
+  return this
+}
+
+func (this *actions) OnLabel(lbl string, action Action) Actions {
+  if len(this.tracer.templates[lbl]) == 0 {
+    return this.fail(fmt.Errorf("action for label without rules: '%s'", lbl))
+  }
+  if this.labels[lbl] != nil {
+    return this.fail(fmt.Errorf("double action for label: '%s'", lbl))
+  }
+  this.labels[lbl] = action
+  return this
+}
+
+// fail records the first error encountered in the registration of actions, the error
+// is reported by Apply.
+func (this *actions) fail(err error) Actions {
+  if this.err == nil {
+    this.err = err
+  }
+  return this
+}
+
+func (this *actions) Trace(ambit *Ambit, lbl string) (interface{}, error) {
+  return this.Apply(this.tracer.Trace(ambit, lbl))
+}
+
+func (this *actions) TraceUndent(source *Source, lbl string) (interface{}, error) {
+  return this.Apply(this.tracer.TraceUndent(source, lbl))
+}
+
+func (this *actions) Apply(trace *Trace) (interface{}, error) {
+  if this.err != nil {
+    return nil, this.err
+  }
+  if err := trace.ErrorN(20); err != nil {
+    return nil, err
+  }
+  var errs []error
+  value := this.apply(trace, &errs)
+  return value, SummaryError(errs, 20)
+}
+
+func (this *actions) apply(trace *Trace, errs *[]error) interface{} {
+  values := make([]interface{}, len(trace.Subs))
+  for index, sub := range trace.Subs {
+    values[index] = this.apply(sub, errs)
+  }
+  action := this.labels[trace.Lbl]
+  if rules := this.rules[trace.Lbl]; rules != nil && rules[trace.Idx] != nil {
+    action = rules[trace.Idx]
+  }
+  if action != nil {
+    return action(&ActionContext{ Trace: trace, Values: values, errs: errs })
+  }
+  if trace.tmpl != nil {
+    switch trace.tmpl.sugar {
+    case sugar_Empty:
+      if trace.Lbl[len(trace.Lbl)-1] == '?' {
+        return nil
+      }
+      return []interface{}{}
+    case sugar_Elem:
+      if trace.Lbl[len(trace.Lbl)-1] == '?' {
+        return values[0]
+      }
+      return []interface{}{ values[0] }
+    case sugar_Wrap:
+      return values[0]
+    case sugar_Left:
+      elems, ok := values[0].([]interface{})
+      if !ok {
+        return this.listError(trace.Subs[0], values[0], errs)
+      }
+      return append(elems, values[1])
+    case sugar_Right:
+      elems, ok := values[1].([]interface{})
+      if !ok {
+        return this.listError(trace.Subs[1], values[1], errs)
+      }
+      return append([]interface{}{ values[0] }, elems...)
+    }
+  }
+  if len(values) == 1 {
+    return values[0]
+  }
+  return nil
+}
+
+// listError reports that the value of the given trace of a list is not an
+// []interface{}, which happens if an action of the hidden label of the list returns
+// another value.
+func (this *actions) listError(trace *Trace, value interface{}, errs *[]error) interface{} {
+  *errs = append(*errs, AmbitError(trace.Syn.Ambit, fmt.Sprintf("value of list of label '%s' is not an []interface{}: %T", trace.Lbl, value)))
+  return nil
+}
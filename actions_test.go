@@ -0,0 +1,134 @@
+package dusl
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+  "testing"
+)
+
+type callNode struct {
+  fun string
+  args []interface{}
+}
+
+func TestActions(t *testing.T) {
+  lang, err := LoadSpec(SourceFromString(`lexical default
+category ID "identifier"
+category NUM "number"
+application ( )
+operator BFA + -
+operator BFA ,
+brackets ( )
+label X "expression"
+grammar
+X is> [call] fun:ID(args:X,*) or> [paren] (X) or> [add] X + X or> [sub] X - X or> [num] NUM or> [var] ID
+`))
+
+  if err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+
+  actions := lang.Tracer().Actions().
+    OnRule("X", "call", func(ctx *ActionContext) interface{} {
+      return &callNode{ fun: ctx.Cat("fun").Lit, args: ctx.Value("args").([]interface{}) }
+    }).
+    OnRule("X", "num", func(ctx *ActionContext) interface{} {
+      n, _ := strconv.Atoi(ctx.Trace.Syn.Lit)
+      return n
+    }).
+    OnRule("X", "var", func(ctx *ActionContext) interface{} {
+      ctx.Errorf("undefined variable: '%s'", ctx.Trace.Syn.Lit)
+      return 0
+    }).
+    OnRule("X", "paren", func(ctx *ActionContext) interface{} {
+      return ctx.Values[0]
+    }).
+    OnLabel("X", func(ctx *ActionContext) interface{} {
+      a, b := ctx.Values[0].(int), ctx.Values[1].(int)
+      if ctx.Trace.Alt == "sub" {
+        return a - b
+      }
+      return a + b
+    })
+
+  for _, tst := range []struct{ src string; res string }{
+    { "1 + (2 - 3)", "0" },
+    { "f()", "f[]" },
+    { "f(1, 2 + 3, g(4))", "f[1 5 g[4]]" },
+  } {
+    value, err := actions.Trace(AmbitFromString(tst.src), "X")
+    if err != nil {
+      t.Log(err)
+      t.Fail()
+      continue
+    }
+    if res := formatValue(value); res != tst.res {
+      t.Log(tst.src, res)
+      t.Fail()
+    }
+  }
+
+  if _, err := actions.Trace(AmbitFromString("1 + a"), "X"); err == nil || !strings.Contains(err.Error(), "str:1:4:5: undefined variable: 'a'") {
+    t.Log(err)
+    t.Fail()
+  }
+
+  if _, err := lang.Tracer().Actions().OnRule("X", "mul", nil).Trace(AmbitFromString("1"), "X"); err == nil ||
+     err.Error() != "action for undeclared rule alternative of label 'X': 'mul'" {
+    t.Log(err)
+    t.Fail()
+  }
+
+  for _, tst := range []struct{ actions Actions; err string }{
+    { lang.Tracer().Actions().OnRule("X", "", nil), "action for unnamed rule alternative of label, use OnIndex: 'X'" },
+    { lang.Tracer().Actions().OnIndex("X", 6, nil), "action for rule alternative 6 of label with 6 alternative(s): 'X'" },
+    { lang.Tracer().Actions().OnIndex("X", 4, func(ctx *ActionContext) interface{} { return 1 }).OnRule("X", "num", nil), "double action for rule alternative 4 of label: 'X'" },
+  } {
+    if _, err := tst.actions.Trace(AmbitFromString("1"), "X"); err == nil || err.Error() != tst.err {
+      t.Log(err)
+      t.Fail()
+    }
+  }
+}
+
+func TestActionsListValue(t *testing.T) {
+  lang, err := LoadSpec(SourceFromString(`lexical default
+category ID "identifier"
+category NUM "number"
+application ( )
+operator BFA ,
+brackets ( )
+label X "expression"
+grammar
+X is> [call] ID(X,*) or> [num] NUM
+`))
+
+  if err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+
+  // an element of a list that is not an []interface{} is reported rather than a panic:
+  actions := lang.Tracer().Actions().OnIndex("X,+", 1, func(ctx *ActionContext) interface{} { return 0 })
+  if _, err := actions.Trace(AmbitFromString("f(1, 2)"), "X"); err == nil ||
+     err.Error() != "str:1:2:3: value of list of label 'X,+' is not an []interface{}: int\n" {
+    t.Log(err)
+    t.Fail()
+  }
+}
+
+func formatValue(value interface{}) string {
+  switch v := value.(type) {
+  case *callNode:
+    args := make([]string, len(v.args))
+    for index, arg := range v.args {
+      args[index] = formatValue(arg)
+    }
+    return v.fun + "[" + strings.Join(args, " ") + "]"
+  }
+  return fmt.Sprint(value)
+}
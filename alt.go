@@ -0,0 +1,147 @@
+package dusl
+
+import (
+  "fmt"
+  "sort"
+)
+
+// meta_AltName is the category of the tokens of the altNameScanner.
+const meta_AltName = "$ALT"
+
+// The altNameScanner scans an alternative name: an identifier between square brackets,
+// such as "[add]".
+type altNameScanner struct {}
+
+func (this *altNameScanner) Scan() Scan {
+  return &altNameScan{}
+}
+
+type altNameScan struct {
+  state int
+  name []rune
+}
+
+func (this *altNameScan) Consume(r rune) (string, bool) {
+  const (
+    INIT = iota // convention requires: INIT == 0
+    OPEN
+    NAME
+    NOMORE
+  )
+  switch this.state {
+  case INIT:
+    if r == '[' {
+      this.state = OPEN
+      return "", true
+    }
+  case OPEN:
+    if isIdentifierStart(r) {
+      this.state = NAME
+      this.name = append(this.name, r)
+      return "", true
+    }
+  case NAME:
+    if isIdentifierRune(r) {
+      this.name = append(this.name, r)
+      return "", true
+    }
+    if r == ']' {
+      this.state = NOMORE
+      return meta_AltName, false
+    }
+  }
+  this.state = NOMORE
+  return "", false
+}
+
+func (this *altNameScan) Reset() {
+  this.state = 0
+  this.name = this.name[:0]
+}
+
+// altNameT is an alternative name of the grammar source.
+type altNameT struct {
+  name string
+  ambit *Ambit
+  used bool
+  symbol bool // the name is a declared symbol, see altName
+}
+
+// altName records the given alternative name token if it directly follows an is> or
+// or> meta operator (the given previous token), and reports whether it does. A name
+// that is a declared symbol is recorded as a conflict, as "or> [X]" may as well be
+// meant to match X in brackets, and is left to the template.
+func (this *metaTokenizerT) altName(prev *Token, token *Token) bool {
+  if prev == nil || prev.Cat != "OP" || (prev.Lit != "is>" && prev.Lit != "or>") {
+    return false
+  }
+  name := token.Lit[1:len(token.Lit)-1]
+  if this.symbolTable[name] != nil {
+    this.alts[prev.Ambit.End] = &altNameT{ name: name, ambit: token.Ambit, used: true, symbol: true }
+    return false
+  }
+  this.alts[prev.Ambit.End] = &altNameT{ name: name, ambit: token.Ambit }
+  return true
+}
+
+// altName returns the name of the alternative that is introduced by the meta
+// operator (is> or or>) with the given ambit, or the empty string if the alternative
+// is unnamed.
+func (this *tpT) altName(op *Ambit) string {
+  alt := this.metaTokenizer.alts[op.End]
+  if alt == nil || alt.symbol {
+    return ""
+  }
+  alt.used = true
+  return alt.name
+}
+
+// checkAltNames reports alternative names that are declared twice for the same label,
+// names that are not followed by an alternative and names that are declared symbols.
+func checkAltNames(templates map[string][]*templateT, alts map[int]*altNameT) []error {
+  var errs []error
+  for _, lbl := range sortedKeys(templates) {
+    names := make(map[string]*templateT)
+    for _, template := range templates[lbl] {
+      if template.alt == "" {
+        continue
+      }
+      if existing := names[template.alt]; existing != nil {
+        errs = append(errs, templateError(template, fmt.Sprintf("double declaration of alternative name for label '%s': '%s' (first declared at: %s)",
+                                                                lbl, template.alt, existing.loc)))
+        continue
+      }
+      names[template.alt] = template
+    }
+  }
+  for _, pos := range sortedAltPositions(alts) {
+    switch alt := alts[pos]; {
+    case alt.symbol:
+      errs = append(errs, AmbitError(alt.ambit, fmt.Sprintf("alternative name conflicts with declared symbol, write '[ %s ]' to match it in brackets: '%s'",
+                                                            alt.name, alt.name)))
+    case !alt.used:
+      errs = append(errs, AmbitError(alt.ambit, fmt.Sprintf("alternative name without alternative: '%s'", alt.name)))
+    }
+  }
+  return errs
+}
+
+func sortedAltPositions(alts map[int]*altNameT) []int {
+  positions := make([]int, 0, len(alts))
+  for pos, _ := range alts {
+    positions = append(positions, pos)
+  }
+  sort.Ints(positions)
+  return positions
+}
+
+// Alts returns the names of the alternatives of the given label by index, unnamed
+// alternatives have the empty string as their name.
+func (this *tracer) Alts(lbl string) []string {
+  templates := this.templates[lbl]
+  alts := make([]string, len(templates))
+  for index, template := range templates {
+    alts[index] = template.alt
+  }
+  return alts
+}
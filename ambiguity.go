@@ -0,0 +1,127 @@
+package dusl
+
+import (
+  "fmt"
+  "sort"
+  "strings"
+)
+
+// An Ambiguity reports a syntax node that is accepted by more than one rule
+// alternative of a label, see Tracer.Ambiguities. The Idxs field contains the indices
+// of the accepting alternatives in ascending order, the tracer applies the first one.
+type Ambiguity struct {
+  Lbl string
+  Idxs []int
+  Ambit *Ambit
+}
+
+// String returns the location and description of this ambiguity.
+func (this *Ambiguity) String() string {
+  idxs := make([]string, len(this.Idxs))
+  for index, idx := range this.Idxs {
+    idxs[index] = fmt.Sprint(idx)
+  }
+  return fmt.Sprintf("%s: ambiguous '%s', accepted by alternatives: %s", this.Ambit.Location(), this.Lbl, strings.Join(idxs, ", "))
+}
+
+// ambiguityEntryT is the memoized outcome of tracing a syntax node with a label: the
+// indices of the alternatives that accept the node, and for each of them the nodes
+// and labels of its sub-traces.
+type ambiguityEntryT struct {
+  idxs []int
+  subs [][]waitingItemT
+}
+
+type ambiguityFinderT struct {
+  tracer *tracer
+  memo map[backtrackKeyT]*ambiguityEntryT
+  inProgress map[backtrackKeyT]int // by the number of labels in progress when it started
+}
+
+func (this *tracer) Ambiguities(ambit *Ambit, lbl string) []*Ambiguity {
+  return this.ambiguities(this.sparser.Sparse(ambit), lbl)
+}
+
+func (this *tracer) AmbiguitiesUndent(source *Source, lbl string) []*Ambiguity {
+  return this.ambiguities(this.sparser.SparseUndent(source), lbl)
+}
+
+// ambiguities reports the ambiguities in the nodes that are reached from the given
+// root by means of accepting alternatives, ordered by position.
+func (this *tracer) ambiguities(root *Syntax, lbl string) []*Ambiguity {
+  finder := &ambiguityFinderT{ tracer: this, memo: make(map[backtrackKeyT]*ambiguityEntryT),
+                               inProgress: make(map[backtrackKeyT]int) }
+  if entry, _ := finder.accept(root, lbl); len(entry.idxs) == 0 {
+    return nil
+  }
+  var ambiguities []*Ambiguity
+  reported := make(map[backtrackKeyT]bool)
+  var rec func(node *Syntax, lbl string)
+  rec = func(node *Syntax, lbl string) {
+    key := backtrackKeyT{ node: node, lbl: lbl }
+    if reported[key] {
+      return
+    }
+    reported[key] = true
+    entry, _ := finder.accept(node, lbl) // <-- memoized, unless it depended on a label in progress
+    if len(entry.idxs) > 1 {
+      ambiguities = append(ambiguities, &Ambiguity{ Lbl: lbl, Idxs: entry.idxs, Ambit: node.Ambit })
+    }
+    for _, subs := range entry.subs {
+      for _, sub := range subs {
+        rec(sub.node, sub.trace.Lbl)
+      }
+    }
+  }
+  rec(root, lbl)
+  sort.SliceStable(ambiguities, func(i, j int) bool {
+    a, b := ambiguities[i].Ambit, ambiguities[j].Ambit
+    return a.Start < b.Start || (a.Start == b.Start && a.End > b.End)
+  })
+  return ambiguities
+}
+
+// accept determines which alternatives of the given label accept the given node,
+// including all of their sub-traces. As in backtrackerT.label, a label that is already
+// in progress for the same node does not accept it, and the results that depend on
+// such a provisional outcome are not memoized. The returned number is the lowest
+// position among the labels in progress that the entry depends on.
+func (this *ambiguityFinderT) accept(node *Syntax, lbl string) (*ambiguityEntryT, int) {
+  key := backtrackKeyT{ node: node, lbl: lbl }
+  if entry := this.memo[key]; entry != nil {
+    return entry, len(this.inProgress)
+  }
+  if pos, present := this.inProgress[key]; present {
+    return &ambiguityEntryT{}, pos
+  }
+  pos := len(this.inProgress)
+  this.inProgress[key] = pos
+  low := pos
+  entry := &ambiguityEntryT{}
+  for _, idx := range this.tracer.candidates(node, lbl) {
+    template := this.tracer.templates[lbl][idx]
+    if !template.checkMatch(node) || template.reject != "" {
+      continue
+    }
+    waiting := &waitingT{}
+    template.performMatch(node, waiting, 0, make([]*Trace, template.subCount), 0, make([]*Syntax, template.catCount))
+    accepted := true
+    for _, item := range waiting.list {
+      sub, subLow := this.accept(item.node, item.trace.Lbl)
+      low = min(low, subLow)
+      if len(sub.idxs) == 0 {
+        accepted = false
+        break
+      }
+    }
+    if accepted {
+      entry.idxs = append(entry.idxs, idx)
+      entry.subs = append(entry.subs, waiting.list)
+    }
+  }
+  delete(this.inProgress, key)
+  if low >= pos {
+    this.memo[key] = entry
+  }
+  return entry, low
+}
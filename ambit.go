@@ -31,9 +31,10 @@ func (this *Ambit) ToString() string {
 // it potentially requires scanning the entire source. Calling this repeatedly may
 // lead to quadratic time behaviour.
 func (this *Ambit) Location() string {
-  source := this.Source
-  startLine, startColumn := source.LineColumn(this.Start)
-  endLine, endColumn := source.LineColumn(this.End)
+  ambit := this.original()
+  source := ambit.Source
+  startLine, startColumn := source.LineColumn(ambit.Start)
+  endLine, endColumn := source.LineColumn(ambit.End)
   if startLine != endLine {
     return fmt.Sprintf("%s:%d:%d:%d:%d", source.Path, startLine, startColumn, endLine, endColumn)
   }
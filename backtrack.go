@@ -0,0 +1,127 @@
+package dusl
+
+// Backtracking returns a tracer for the same grammar that backtracks, see the Tracer
+// interface.
+func (this *tracer) Backtracking() Tracer {
+  backtracking := *this
+  backtracking.backtracking = true
+  return &backtracking
+}
+
+// backtrackKeyT identifies the tracing of a syntax node with a label.
+type backtrackKeyT struct {
+  node *Syntax
+  lbl string
+}
+
+// backtrackResultT is the memoized result of tracing a syntax node with a label. The
+// depth is the number of traces in between the trace and its deepest failure, or -1
+// if the trace succeeded.
+type backtrackResultT struct {
+  trace *Trace
+  depth int
+}
+
+type backtrackerT struct {
+  tracer *tracer
+  memo map[backtrackKeyT]*backtrackResultT
+  inProgress map[backtrackKeyT]int // by the number of labels in progress when it started
+}
+
+func (this *tracer) backtrack(root *Syntax, lbl string) *Trace {
+  backtracker := &backtrackerT{ tracer: this, memo: make(map[backtrackKeyT]*backtrackResultT),
+                                inProgress: make(map[backtrackKeyT]int) }
+  result, _ := backtracker.label(root, lbl)
+  return result.trace
+}
+
+// copy returns a deep copy of this trace. The traces that are handed out of the memo
+// are copies, such that the returned traces are trees rather than DAGs of shared
+// sub-traces.
+func (this *Trace) copy() *Trace {
+  copied := *this
+  if this.Subs != nil {
+    copied.Subs = make([]*Trace, len(this.Subs))
+    for index, sub := range this.Subs {
+      copied.Subs[index] = sub.copy()
+    }
+  }
+  if this.Cats != nil {
+    copied.Cats = append([]*Syntax(nil), this.Cats...)
+  }
+  return &copied
+}
+
+// label traces the given node with the given label, trying the alternatives in order
+// until one of them succeeds including all of its sub-traces. If all of them fail
+// the deepest failure is returned, the failure of the first alternative in case of a
+// tie. The first matching error production, if any, takes precedence over these
+// failures.
+//
+// Tracing a node with a label that is already in progress for the same node fails,
+// which cuts the cycles of rules. Such a failure is provisional: the results that
+// depend on it are not memoized, except for the result of the label in progress
+// itself, which is final once its alternatives are exhausted. The returned number is
+// the lowest position among the labels in progress that the result depends on.
+func (this *backtrackerT) label(node *Syntax, lbl string) (*backtrackResultT, int) {
+  key := backtrackKeyT{ node: node, lbl: lbl }
+  if result := this.memo[key]; result != nil {
+    return &backtrackResultT{ trace: result.trace.copy(), depth: result.depth }, len(this.inProgress)
+  }
+  if pos, present := this.inProgress[key]; present {
+    result := &backtrackResultT{ trace: &Trace{ Lbl: lbl, Syn: node }, depth: 0 }
+    this.tracer.fail(result.trace, node)
+    return result, pos
+  }
+  pos := len(this.inProgress)
+  this.inProgress[key] = pos
+  low := pos
+  result := &backtrackResultT{ trace: &Trace{ Lbl: lbl, Syn: node }, depth: 0 }
+  this.tracer.fail(result.trace, node)
+  var rejected *backtrackResultT
+  for _, idx := range this.tracer.candidates(node, lbl) {
+    template := this.tracer.templates[lbl][idx]
+    if !template.checkMatch(node) {
+      continue
+    }
+    if template.reject != "" {
+      if rejected == nil {
+        rejected = &backtrackResultT{ trace: &Trace{ Lbl: "ERR", Idx: idx, Alt: template.alt, Syn: node, Err: template.reject } }
+      }
+      continue
+    }
+    trace := &Trace{ Lbl: lbl, Idx: idx, Alt: template.alt, Syn: node, tmpl: template }
+    if template.subCount > 0 {
+      trace.Subs = make([]*Trace, template.subCount)
+    }
+    if template.catCount > 0 {
+      trace.Cats = make([]*Syntax, template.catCount)
+    }
+    waiting := &waitingT{}
+    template.performMatch(node, waiting, 0, trace.Subs, 0, trace.Cats)
+    depth := -1
+    for index, item := range waiting.list {
+      sub, subLow := this.label(item.node, item.trace.Lbl)
+      low = min(low, subLow)
+      trace.Subs[index] = sub.trace
+      if sub.depth >= 0 && sub.depth+1 > depth {
+        depth = sub.depth+1
+      }
+    }
+    if depth < 0 {
+      result = &backtrackResultT{ trace: trace, depth: -1 }
+      break
+    }
+    if depth > result.depth {
+      result = &backtrackResultT{ trace: trace, depth: depth }
+    }
+  }
+  if result.depth >= 0 && rejected != nil {
+    result = rejected
+  }
+  delete(this.inProgress, key)
+  if low >= pos {
+    this.memo[key] = result
+  }
+  return result, low
+}
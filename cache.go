@@ -0,0 +1,142 @@
+package dusl
+
+import (
+  "bufio"
+  "crypto/sha256"
+  "encoding/hex"
+  "os"
+  "path/filepath"
+)
+
+// A LangCache keeps compiled Langs in a directory on disk, keyed by a hash of the
+// content of their specification. On a hit the Lang is decoded from disk instead of
+// being compiled, which saves re-parsing the grammar and rebuilding all templates
+// and prefix trees on every process start. On a miss the Lang is compiled as usual
+// and stored for next time. Only successfully compiled Langs are stored, failing to
+// store a Lang is not an error: the cache is merely an optimization.
+type LangCache interface {
+  // Grammar is the cached equivalent of spec.Grammar(grammar). Specs with a scanner
+  // that cannot be encoded (see Lang.Encode) are compiled without caching.
+  Grammar(spec Spec, grammar string) (Lang, error)
+  // GrammarAt is the cached equivalent of spec.GrammarAt(path, line, grammar).
+  GrammarAt(spec Spec, path string, line int, grammar string) (Lang, error)
+  // GrammarFromSource is the cached equivalent of spec.GrammarFromSource(src).
+  GrammarFromSource(spec Spec, src *Source) (Lang, error)
+  // LoadSpec is the cached equivalent of LoadSpec(src).
+  LoadSpec(src *Source) (Lang, error)
+}
+
+type langCache struct {
+  dir string
+}
+
+// NewLangCache returns a LangCache that keeps its entries in the given directory,
+// the directory is created when the first entry is stored.
+func NewLangCache(dir string) LangCache {
+  return &langCache{ dir: dir }
+}
+
+func (this *langCache) Grammar(s Spec, grammar string) (Lang, error) {
+  path, line := grammarCaller(grammar)
+  return this.GrammarAt(s, path, line, grammar)
+}
+
+func (this *langCache) GrammarAt(s Spec, path string, line int, grammar string) (Lang, error) {
+  return this.GrammarFromSource(s, &Source{ Path: path, LineOffset: line-1, Text: []byte(grammar) })
+}
+
+func (this *langCache) GrammarFromSource(s Spec, grammarSource *Source) (Lang, error) {
+  spec := s.(*spec)
+  key := spec.key(grammarSource)
+  if key == "" {
+    return spec.grammarFromSource(grammarSource)
+  }
+  return this.lookup(key, func() (Lang, error) {
+    return spec.grammarFromSource(grammarSource)
+  })
+}
+
+func (this *langCache) LoadSpec(src *Source) (Lang, error) {
+  hash := sha256.New()
+  enc := &langEncoder{ out: bufio.NewWriter(hash) }
+  enc.string(langMagic)
+  enc.uint(langVersion)
+  enc.string("spec file")
+  enc.string(src.Path)
+  enc.int(src.LineOffset)
+  enc.string(string(src.Text))
+  enc.out.Flush()
+  return this.lookup(hex.EncodeToString(hash.Sum(nil)), func() (Lang, error) {
+    return LoadSpec(src)
+  })
+}
+
+// lookup decodes the Lang stored under the given key, or compiles and stores it if
+// there is no (valid) entry.
+func (this *langCache) lookup(key string, compile func() (Lang, error)) (Lang, error) {
+  path := filepath.Join(this.dir, key + ".lang")
+  if in, err := os.Open(path); err == nil {
+    lang, err := DecodeLang(in)
+    in.Close()
+    if err == nil {
+      return lang, nil
+    }
+  }
+  lang, err := compile()
+  if err != nil {
+    return nil, err
+  }
+  this.store(path, lang)
+  return lang, nil
+}
+
+// store writes the given Lang to the given path, it writes to a temporary file first
+// such that concurrent processes never observe a partially written entry.
+func (this *langCache) store(path string, lang Lang) {
+  if err := os.MkdirAll(this.dir, 0755); err != nil {
+    return
+  }
+  out, err := os.CreateTemp(this.dir, "tmp-*.lang")
+  if err != nil {
+    return
+  }
+  err = lang.Encode(out)
+  if closeErr := out.Close(); err == nil {
+    err = closeErr
+  }
+  if err == nil {
+    err = os.Rename(out.Name(), path)
+  }
+  if err != nil {
+    os.Remove(out.Name())
+  }
+}
+
+// key returns the cache key for this spec combined with the given grammar rules, or
+// the empty string if the spec cannot be cached. The location of the grammar rules is
+// part of the key as the compiled Lang refers to it.
+func (this *spec) key(grammarSource *Source) string {
+  if this.err != nil {
+    return ""
+  }
+  hash := sha256.New()
+  enc := &langEncoder{ out: bufio.NewWriter(hash) }
+  enc.string(langMagic)
+  enc.uint(langVersion)
+  enc.string("spec")
+  enc.scanner(this.scanner)
+  enc.declarations(this)
+  enc.templates(this.inherited)
+  enc.stringMap(this.inheritedDescs)
+  enc.stringMap(this.inheritedDocs)
+  enc.stringMap(this.inheritedMessages)
+  enc.strings(this.overrides)
+  enc.string(grammarSource.Path)
+  enc.int(grammarSource.LineOffset)
+  enc.string(string(grammarSource.Text))
+  if enc.err != nil {
+    return ""
+  }
+  enc.out.Flush()
+  return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,61 @@
+package dusl
+
+import (
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func TestLangCache(t *testing.T) {
+  dir := t.TempDir()
+  cache := NewLangCache(dir)
+  src := SourceFromString(`
+lexical default
+category ID "identifier"
+operator BFA +
+label X "expression"
+grammar
+X is> ID or> X + X
+`)
+  for round := 0; round < 2; round++ {
+    lang, err := cache.LoadSpec(src)
+    if err != nil {
+      t.Log(err)
+      t.Fail()
+      return
+    }
+    res := lang.Tracer().Trace(AmbitFromString("a + b"), "X").DumpToString(true)
+    tgt := `X:1:+
+  X:0:a
+  X:0:b
+`
+    if res != tgt {
+      t.Log(res)
+      t.Fail()
+    }
+    spec := NewSpec().Lexical(DefaultScanner).Category("ID", "identifier").Label("X", "expression")
+    if _, err := cache.Grammar(spec, "X is> ID"); err != nil {
+      t.Log(err)
+      t.Fail()
+    }
+  }
+  entries, _ := filepath.Glob(filepath.Join(dir, "*.lang"))
+  if len(entries) != 2 {
+    t.Log(entries)
+    t.Fail()
+  }
+
+  for _, entry := range entries {
+    os.WriteFile(entry, []byte("corrupt"), 0644)
+  }
+  if _, err := cache.LoadSpec(src); err != nil {
+    t.Log(err)
+    t.Fail()
+  }
+
+  _, err := cache.Grammar(NewSpec().Label("X", "expression"), "Y is> X")
+  if err == nil {
+    t.Log("expected error")
+    t.Fail()
+  }
+}
@@ -0,0 +1,134 @@
+package dusl
+
+import (
+  "bytes"
+  "fmt"
+  "strings"
+)
+
+// directiveT is a directive comment that applies to the rule alternatives on the line
+// below it (possibly after other comment lines).
+type directiveT struct {
+  kind string // "error" or "reject"
+  msg string
+  ambit *Ambit
+  used bool
+}
+
+// parseDirectives parses the directive comments of the given grammar source: the
+// lines of whitespace (according to the given meta tokenizer) that start with "#!",
+// such as "#! error: ..." or "#! reject: ..." for the rule alternatives below it and
+// "#! error X: ..." for the label X. The directives for rule alternatives are returned
+// in order, and indexed by the byte offset of the start of the line they apply to. The
+// messages of labels are added to the given map.
+func parseDirectives(src *Source, tokenizer Tokenizer, symbolTable map[string]*specSymbol,
+                     messages map[string]string) (map[int][]*directiveT, []*directiveT, []error) {
+  directives := make(map[int][]*directiveT)
+  var all, pending []*directiveT
+  var errs []error
+  text := src.Text
+  for _, token := range tokenizer.Tokenize(src.FullAmbit()) {
+    if token.Cat != "WS" {
+      if len(pending) > 0 {
+        directives[lineStart(text, token.Ambit.Start)] = pending
+        pending = nil
+      }
+      continue
+    }
+    for start := token.Ambit.Start; start < token.Ambit.End; {
+      end := token.Ambit.End
+      if index := bytes.IndexByte(text[start:end], '\n'); index >= 0 {
+        end = start+index+1
+      }
+      line := strings.TrimSpace(string(text[start:end]))
+      switch {
+      case lineStart(text, start) != start: // <-- the rest of a line with a rule
+      case line == "" && text[end-1] == '\n':
+        pending = nil // <-- reported by checkDirectives
+      case strings.HasPrefix(line, "#!"):
+        pos := start + strings.Index(string(text[start:end]), line)
+        ambit := &Ambit{ Source: src, Start: pos, End: pos+len(line) }
+        directive, err := parseDirective(ambit, tokenizer, symbolTable, messages)
+        if err != nil {
+          errs = append(errs, err)
+        } else if directive != nil {
+          all, pending = append(all, directive), append(pending, directive)
+        }
+      }
+      start = end
+    }
+  }
+  return directives, all, errs
+}
+
+// parseDirective parses the directive comment with the given ambit, by means of the
+// given meta tokenizer. It returns nil for a directive of a label, its message is
+// added to the given map.
+func parseDirective(ambit *Ambit, tokenizer Tokenizer, symbolTable map[string]*specSymbol,
+                    messages map[string]string) (*directiveT, error) {
+  var tokens []*Token
+  for _, token := range tokenizer.Tokenize(&Ambit{ Source: ambit.Source, Start: ambit.Start+2, End: ambit.End }) {
+    if token.Cat != "WS" {
+      tokens = append(tokens, token)
+    }
+  }
+  kind, lbl, colon := "", "", -1
+  switch {
+  case len(tokens) > 1 && tokens[1].Lit == ":":
+    kind, colon = tokens[0].Lit, 1
+  case len(tokens) > 2 && tokens[2].Lit == ":":
+    kind, lbl, colon = tokens[0].Lit, tokens[1].Lit, 2
+  }
+  if colon < 0 || (kind != "error" && kind != "reject") {
+    return nil, AmbitError(ambit, "expected directive: '#! error: ...', '#! error <label>: ...' or '#! reject: ...'")
+  }
+  msg := strings.TrimSpace(string(ambit.Source.Text[tokens[colon].Ambit.End:ambit.End]))
+  switch {
+  case msg == "":
+    return nil, AmbitError(ambit, fmt.Sprintf("missing message in directive: '%s'", kind))
+  case lbl == "":
+    return &directiveT{ kind: kind, msg: msg, ambit: ambit }, nil
+  case kind == "reject":
+    return nil, AmbitError(ambit, fmt.Sprintf("reject directive for label: '%s'", lbl))
+  case !isLabelSymbol(symbolTable[lbl]):
+    return nil, AmbitError(ambit, fmt.Sprintf("error directive for undeclared label: '%s'", lbl))
+  case messages[lbl] != "":
+    return nil, AmbitError(ambit, fmt.Sprintf("double error directive for label: '%s'", lbl))
+  }
+  messages[lbl] = msg
+  return nil, nil
+}
+
+// lineStart returns the offset of the start of the line of the given offset.
+func lineStart(text []byte, pos int) int {
+  return bytes.LastIndexByte(text[:pos], '\n')+1
+}
+
+func isLabelSymbol(symbol *specSymbol) bool {
+  return symbol != nil &&
+    (symbol.typ == spec_Label || symbol.typ == spec_SentenceLabel || symbol.typ == spec_SequenceLabel)
+}
+
+// direct applies the directives of the line of the given meta operator (is> or or>)
+// to the given top level template.
+func (this *tpT) direct(op *Ambit, template *templateT) {
+  for _, directive := range this.directives[lineStart(op.Source.Text, op.Start)] {
+    directive.used = true
+    if directive.kind == "reject" {
+      template.reject = directive.msg
+    } else {
+      template.msg = directive.msg
+    }
+  }
+}
+
+// checkDirectives reports the directives that do not apply to any rule alternative.
+func checkDirectives(directives []*directiveT) []error {
+  var errs []error
+  for _, directive := range directives {
+    if !directive.used {
+      errs = append(errs, AmbitError(directive.ambit, fmt.Sprintf("%s directive not followed by a rule", directive.kind)))
+    }
+  }
+  return errs
+}
@@ -0,0 +1,499 @@
+package dusl
+
+import (
+  "bytes"
+  "fmt"
+  "html"
+  "io"
+  "strings"
+)
+
+// The kinds of the elements of railroad diagrams.
+const (
+  rail_Seq = iota
+  rail_Choice
+  rail_Term // literal, operator or bracket
+  rail_Cat // lexical category
+  rail_Label
+  rail_Opt // items[0] or nothing
+  rail_Loop // one or more items[0], separated by items[1] (if any)
+  rail_Block // indented sequence of sentences
+  rail_Newline // separates the sentences of a sequence
+)
+
+// railT is an element of a railroad diagram.
+type railT struct {
+  kind int
+  text string
+  items []*railT
+}
+
+// patternDescriptions explains the binding patterns in the precedence table.
+var patternDescriptions = map[string]string{
+  "EFE": "zero-ary operators",
+  "EFA": "prefix operators",
+  "AFE": "postfix operators",
+  "AFB": "right associative infix operators",
+  "BFA": "left associative infix operators",
+  "BFB": "non-associative infix operators",
+  "MIX": "mixfix operators",
+  "B": "brackets",
+  "APP": "applications",
+  "LWA": "left juxtaposition",
+  "AWL": "right juxtaposition",
+  "LA": "left glue",
+  "AL": "right glue",
+}
+
+// DocMarkdown writes the documentation of this Lang in Markdown, see the Lang interface.
+func (this *lang) DocMarkdown(out io.Writer, title string, svgPrefix string) error {
+  buf := new(bytes.Buffer)
+  fmt.Fprintf(buf, "# %s\n\n", title)
+  if len(this.categories) > 0 {
+    fmt.Fprintf(buf, "## Lexical categories\n\n| Category | Description |\n| --- | --- |\n")
+    for _, cat := range this.categories {
+      fmt.Fprintf(buf, "| `%s` | %s |\n", cat, markdownEscape(this.descriptions[cat]))
+    }
+    fmt.Fprintf(buf, "\n")
+  }
+  if len(this.levels) > 0 {
+    fmt.Fprintf(buf, "## Operators and brackets\n\nFrom the highest to the lowest precedence:\n\n")
+    fmt.Fprintf(buf, "| Precedence | Pattern | Symbols |\n| --- | --- | --- |\n")
+    for _, row := range this.precedenceRows() {
+      fmt.Fprintf(buf, "| %d%s | %s (%s) | %s |\n", row.precedence, row.name, row.pattern, patternDescriptions[row.pattern],
+                  "`" + strings.Join(row.args, "` `") + "`")
+    }
+    fmt.Fprintf(buf, "\n")
+  }
+  fmt.Fprintf(buf, "## Grammar\n\n")
+  for _, lbl := range this.ruleLabels() {
+    fmt.Fprintf(buf, "### %s\n\n%s\n\n", lbl, markdownEscape(this.descriptions[lbl]))
+    if doc := this.docs[lbl]; doc != "" {
+      fmt.Fprintf(buf, "%s\n\n", doc)
+    }
+    if svgPrefix != "" {
+      fmt.Fprintf(buf, "![%s](%s%s.svg)\n\n", lbl, svgPrefix, DocFileName(lbl))
+    }
+    fmt.Fprintf(buf, "```\n%s```\n\n", this.ruleText(lbl))
+  }
+  _, err := out.Write(buf.Bytes())
+  return err
+}
+
+// DocHTML writes the documentation of this Lang as a standalone HTML page, see the Lang
+// interface.
+func (this *lang) DocHTML(out io.Writer, title string) error {
+  buf := new(bytes.Buffer)
+  esc := html.EscapeString
+  fmt.Fprintf(buf, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n", esc(title))
+  fmt.Fprintf(buf, "<style>\n%s</style>\n</head>\n<body>\n<h1>%s</h1>\n", docStyle, esc(title))
+  if len(this.categories) > 0 {
+    fmt.Fprintf(buf, "<h2>Lexical categories</h2>\n<table>\n<tr><th>Category</th><th>Description</th></tr>\n")
+    for _, cat := range this.categories {
+      fmt.Fprintf(buf, "<tr><td><code>%s</code></td><td>%s</td></tr>\n", esc(cat), esc(this.descriptions[cat]))
+    }
+    fmt.Fprintf(buf, "</table>\n")
+  }
+  if len(this.levels) > 0 {
+    fmt.Fprintf(buf, "<h2>Operators and brackets</h2>\n<p>From the highest to the lowest precedence:</p>\n<table>\n")
+    fmt.Fprintf(buf, "<tr><th>Precedence</th><th>Pattern</th><th>Symbols</th></tr>\n")
+    for _, row := range this.precedenceRows() {
+      fmt.Fprintf(buf, "<tr><td>%d%s</td><td>%s (%s)</td><td><code>%s</code></td></tr>\n", row.precedence, esc(row.name),
+                  row.pattern, patternDescriptions[row.pattern], esc(strings.Join(row.args, "  ")))
+    }
+    fmt.Fprintf(buf, "</table>\n")
+  }
+  fmt.Fprintf(buf, "<h2>Grammar</h2>\n")
+  for _, lbl := range this.ruleLabels() {
+    fmt.Fprintf(buf, "<h3 id=\"%s\">%s</h3>\n<p>%s</p>\n", DocFileName(lbl), esc(lbl), esc(this.descriptions[lbl]))
+    if doc := this.docs[lbl]; doc != "" {
+      fmt.Fprintf(buf, "<p>%s</p>\n", strings.Replace(esc(doc), "\n", "<br>\n", -1))
+    }
+    this.railroad(lbl).svg(buf, true)
+    fmt.Fprintf(buf, "<pre>%s</pre>\n", esc(this.ruleText(lbl)))
+  }
+  fmt.Fprintf(buf, "</body>\n</html>\n")
+  _, err := out.Write(buf.Bytes())
+  return err
+}
+
+// DocSVG writes the railroad diagram of the given label as a standalone SVG image, see
+// the Lang interface.
+func (this *lang) DocSVG(out io.Writer, lbl string) error {
+  if len(this.templates[lbl]) == 0 {
+    return fmt.Errorf("label without rules: '%s'", lbl)
+  }
+  buf := new(bytes.Buffer)
+  this.railroad(lbl).svg(buf, false)
+  _, err := out.Write(buf.Bytes())
+  return err
+}
+
+// DocFileName returns a name for the given label that is safe to use in file names and
+// URLs, such as the names of the SVG images referenced by Lang.DocMarkdown.
+func DocFileName(lbl string) string {
+  buf := new(bytes.Buffer)
+  for _, c := range lbl {
+    if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' || c == '-' {
+      buf.WriteRune(c)
+    } else {
+      fmt.Fprintf(buf, "_%x", c)
+    }
+  }
+  return buf.String()
+}
+
+type precedenceRowT struct {
+  precedence int
+  name string
+  pattern string
+  args []string
+}
+
+// precedenceRows returns the layers of this Lang from the highest to the lowest
+// precedence.
+func (this *lang) precedenceRows() []*precedenceRowT {
+  var rows []*precedenceRowT
+  for _, level := range this.levels {
+    for _, layer := range level.layers {
+      name := ""
+      if layer.name != "" {
+        name = fmt.Sprintf(" (%s)", layer.name)
+      }
+      rows = append(rows, &precedenceRowT{ precedence: level.precedence, name: name, pattern: layer.pattern, args: layer.args })
+    }
+  }
+  return rows
+}
+
+// ruleLabels returns the labels that are documented and for which code is generated:
+// the declared labels with rules, in order of declaration, followed by the instances
+// of macros in lexical order. The hidden labels of list and optional placeholders are
+// left out, they are presented as part of the rules that refer to them.
+func (this *lang) ruleLabels() []string {
+  declared := make(map[string]bool, len(this.labels))
+  var lbls []string
+  for _, lbl := range this.labels {
+    declared[lbl] = true
+    if len(this.templates[lbl]) > 0 {
+      lbls = append(lbls, lbl)
+    }
+  }
+  for _, lbl := range sortedKeys(this.templates) {
+    if kind, _, _ := this.sugarOf(lbl); !declared[lbl] && kind == 0 {
+      lbls = append(lbls, lbl)
+    }
+  }
+  return lbls
+}
+
+// railroad returns the railroad diagram of the given label: a choice between its
+// rule alternatives.
+func (this *lang) railroad(lbl string) *railT {
+  choice := &railT{ kind: rail_Choice }
+  for _, template := range this.templates[lbl] {
+    choice.items = append(choice.items, &railT{ kind: rail_Seq, items: this.rail(template, nil) })
+  }
+  return choice
+}
+
+// rail appends the railroad diagram elements of the given template to the given
+// sequence, following the order in which the constituents occur in the source.
+func (this *lang) rail(template *templateT, seq []*railT) []*railT {
+  if template == nil {
+    return seq
+  }
+  if template.lbl != "" {
+    kind, elem, sep := this.sugarOf(template.lbl)
+    label := &railT{ kind: rail_Label, text: elem }
+    switch kind {
+    case '?':
+      return append(seq, &railT{ kind: rail_Opt, items: []*railT{ label } })
+    case '+', '*':
+      loop := &railT{ kind: rail_Loop, items: []*railT{ label, &railT{ kind: rail_Term, text: sep } } }
+      if kind == '*' {
+        loop = &railT{ kind: rail_Opt, items: []*railT{ loop } }
+      }
+      return append(seq, loop)
+    }
+    return append(seq, &railT{ kind: rail_Label, text: template.lbl })
+  }
+  if template.left == nil {
+    switch {
+    case template.matchCat && template.cat == "":
+      return seq // <-- empty
+    case template.matchLit && template.litSet == nil:
+      return append(seq, &railT{ kind: rail_Term, text: template.lit })
+    case template.matchCat:
+      return append(seq, &railT{ kind: rail_Cat, text: template.cat })
+    }
+    return seq // <-- defensive
+  }
+  switch template.cat {
+  case "BB", "APP":
+    brackets := strings.SplitN(template.lit, " ", 2)
+    if template.cat == "APP" {
+      seq = this.rail(template.left, seq)
+    }
+    seq = append(seq, &railT{ kind: rail_Term, text: brackets[0] })
+    if template.cat == "APP" {
+      seq = this.rail(template.right, seq)
+    } else {
+      seq = this.rail(template.left, seq)
+    }
+    return append(seq, &railT{ kind: rail_Term, text: brackets[len(brackets)-1] })
+  case "SN":
+    seq = this.rail(template.left, seq)
+    if body := this.rail(template.right, nil); len(body) > 0 {
+      seq = append(seq, &railT{ kind: rail_Block, items: body })
+    }
+    return seq
+  case "SQ":
+    seq = this.rail(template.left, seq)
+    if tail := this.rail(template.right, nil); len(tail) > 0 {
+      seq = append(append(seq, &railT{ kind: rail_Newline }), tail...)
+    }
+    return seq
+  case "OP":
+    seq = this.rail(template.left, seq)
+    if template.litSet != nil {
+      ops := &railT{ kind: rail_Choice }
+      for _, op := range sortedKeys(template.litSet) {
+        ops.items = append(ops.items, &railT{ kind: rail_Term, text: op })
+      }
+      seq = append(seq, ops)
+    } else {
+      functors := strings.Split(template.lit, " ")
+      seq = append(seq, &railT{ kind: rail_Term, text: functors[0] })
+      for index, mid := range template.mid {
+        seq = this.rail(mid, seq)
+        seq = append(seq, &railT{ kind: rail_Term, text: functors[index+1] })
+      }
+    }
+    return this.rail(template.right, seq)
+  }
+  // JUXT, GLUE
+  return this.rail(template.right, this.rail(template.left, seq))
+}
+
+// ruleText returns the rules of the given label in the notation of the grammar.
+func (this *lang) ruleText(lbl string) string {
+  buf := new(bytes.Buffer)
+  for index, alt := range this.railroad(lbl).items {
+    lines := strings.Split(railText(alt.items, ""), "\n")
+    meta := "or>"
+    if index == 0 {
+      meta = lbl + " is>"
+    }
+    if len(lines) == 1 {
+      if index > 0 {
+        meta = strings.Repeat(" ", len(lbl)+1) + meta
+      }
+      fmt.Fprintf(buf, "%s %s\n", meta, lines[0])
+      continue
+    }
+    fmt.Fprintf(buf, "%s\n", meta)
+    for _, line := range lines {
+      fmt.Fprintf(buf, "  %s\n", line)
+    }
+  }
+  return buf.String()
+}
+
+func railText(seq []*railT, indent string) string {
+  if len(seq) == 0 {
+    return "<empty"
+  }
+  buf := new(bytes.Buffer)
+  for index, item := range seq {
+    if index > 0 && item.kind != rail_Newline && item.kind != rail_Block && seq[index-1].kind != rail_Newline {
+      buf.WriteString(" ")
+    }
+    switch item.kind {
+    case rail_Newline:
+      buf.WriteString("\n" + indent)
+    case rail_Block:
+      buf.WriteString("\n" + indent + "  " + railText(item.items, indent + "  "))
+    default:
+      buf.WriteString(item.text1())
+    }
+  }
+  return buf.String()
+}
+
+// text1 returns the grammar notation of a single element.
+func (this *railT) text1() string {
+  switch this.kind {
+  case rail_Choice:
+    alts := make([]string, len(this.items))
+    for index, item := range this.items {
+      alts[index] = item.text1()
+    }
+    return "(" + strings.Join(alts, " | ") + ")"
+  case rail_Opt:
+    if item := this.items[0]; item.kind == rail_Loop {
+      return item.items[0].text + item.items[1].text + "*"
+    }
+    return this.items[0].text + "?"
+  case rail_Loop:
+    return this.items[0].text + this.items[1].text + "+"
+  case rail_Seq:
+    return railText(this.items, "")
+  }
+  return this.text
+}
+
+// Layout of railroad diagrams: elements are laid out around a horizontal baseline, up
+// and down are their extents above and below it.
+const (
+  rail_CharWidth = 8
+  rail_BoxHeight = 24
+  rail_Gap = 10
+  rail_Margin = 20
+)
+
+func (this *railT) measure() (int, int, int) {
+  switch this.kind {
+  case rail_Term, rail_Cat, rail_Label:
+    return len([]rune(this.text))*rail_CharWidth + 2*rail_Gap, rail_BoxHeight/2, rail_BoxHeight/2
+  case rail_Newline:
+    w, up, down := (&railT{ kind: rail_Term, text: "newline" }).measure()
+    return w, up, down
+  case rail_Seq:
+    width, up, down := 0, 0, 0
+    for index, item := range this.items {
+      w, u, d := item.measure()
+      if index > 0 {
+        width += rail_Gap
+      }
+      width, up, down = width+w, max(up, u), max(down, d)
+    }
+    return width, up, down
+  case rail_Block:
+    w, up, down := (&railT{ kind: rail_Seq, items: this.items }).measure()
+    return w + 2*rail_Margin, 0, rail_Gap + up + down
+  case rail_Choice, rail_Opt:
+    items := this.choices()
+    width, up, down := 0, 0, 0
+    for index, item := range items {
+      w, u, d := item.measure()
+      width = max(width, w)
+      if index == 0 {
+        up, down = u, d
+      } else {
+        down += rail_Gap + u + d
+      }
+    }
+    return width + 2*rail_Margin, up, down
+  case rail_Loop:
+    w, up, down := this.items[0].measure()
+    sw, su, sd := this.items[1].measure()
+    return max(w, sw) + 2*rail_Margin, up, down + rail_Gap + su + sd
+  }
+  return 0, 0, 0
+}
+
+func (this *railT) choices() []*railT {
+  if this.kind == rail_Opt {
+    return []*railT{ &railT{ kind: rail_Seq }, this.items[0] }
+  }
+  return this.items
+}
+
+// svg writes the diagram as an SVG element, or as a standalone SVG image.
+func (this *railT) svg(buf *bytes.Buffer, inline bool) {
+  w, up, down := this.measure()
+  width, height := w + 2*rail_Margin, up + down + 2*rail_Gap
+  if !inline {
+    fmt.Fprintf(buf, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+  }
+  fmt.Fprintf(buf, "<svg xmlns=\"http://www.w3.org/2000/svg\" class=\"railroad\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+              width, height, width, height)
+  if !inline {
+    fmt.Fprintf(buf, "<style>\n%s</style>\n", railStyle)
+  }
+  y := rail_Gap + up
+  fmt.Fprintf(buf, "<path d=\"M%d %dv%dm0 %dv%dM%d %dh%d\"/>\n", rail_Gap, y-rail_Gap/2, rail_Gap, -rail_Gap, rail_Gap, rail_Gap, y, rail_Margin-rail_Gap)
+  this.draw(buf, rail_Margin, y)
+  x := rail_Margin + w
+  fmt.Fprintf(buf, "<path d=\"M%d %dh%dM%d %dv%d\"/>\n", x, y, rail_Margin-rail_Gap, x+rail_Margin-rail_Gap, y-rail_Gap/2, rail_Gap)
+  fmt.Fprintf(buf, "</svg>\n")
+}
+
+// draw draws the element with its baseline entry point at the given coordinates.
+func (this *railT) draw(buf *bytes.Buffer, x int, y int) {
+  w, _, _ := this.measure()
+  switch this.kind {
+  case rail_Term, rail_Cat, rail_Label, rail_Newline:
+    class, radius, text := "term", rail_BoxHeight/2, this.text
+    switch this.kind {
+    case rail_Cat:
+      class, radius = "cat", 0
+    case rail_Label:
+      class, radius = "label", 0
+    case rail_Newline:
+      class, text = "newline", "newline"
+    }
+    fmt.Fprintf(buf, "<g class=\"%s\"><rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" rx=\"%d\"/>", class, x, y-rail_BoxHeight/2, w, rail_BoxHeight, radius)
+    fmt.Fprintf(buf, "<text x=\"%d\" y=\"%d\">%s</text></g>\n", x+w/2, y+4, html.EscapeString(text))
+  case rail_Seq:
+    for index, item := range this.items {
+      iw, _, _ := item.measure()
+      if index > 0 {
+        fmt.Fprintf(buf, "<path d=\"M%d %dh%d\"/>\n", x-rail_Gap, y, rail_Gap)
+      }
+      item.draw(buf, x, y)
+      x += iw + rail_Gap
+    }
+  case rail_Block:
+    // the body is drawn indented below the baseline, the line returns to it at the end:
+    body := &railT{ kind: rail_Seq, items: this.items }
+    bw, bu, _ := body.measure()
+    by := y + rail_Gap + bu
+    fmt.Fprintf(buf, "<path d=\"M%d %dv%dh%d\"/>\n", x, y, by-y, rail_Margin)
+    body.draw(buf, x+rail_Margin, by)
+    fmt.Fprintf(buf, "<path d=\"M%d %dh%dv%dh%d\"/>\n", x+rail_Margin+bw, by, rail_Margin-rail_Gap, y-by, rail_Gap)
+  case rail_Choice, rail_Opt:
+    iy := y
+    for index, item := range this.choices() {
+      iw, iu, id := item.measure()
+      if index > 0 {
+        iy += rail_Gap + iu
+      }
+      fmt.Fprintf(buf, "<path d=\"M%d %dh%dv%dh%d\"/>\n", x, y, rail_Margin/2, iy-y, rail_Margin/2)
+      item.draw(buf, x+rail_Margin, iy)
+      fmt.Fprintf(buf, "<path d=\"M%d %dh%dv%dh%d\"/>\n", x+rail_Margin+iw, iy, w-2*rail_Margin-iw+rail_Margin/2, y-iy, rail_Margin/2)
+      iy += id
+    }
+  case rail_Loop:
+    item, sep := this.items[0], this.items[1]
+    iw, _, id := item.measure()
+    sw, su, _ := sep.measure()
+    fmt.Fprintf(buf, "<path d=\"M%d %dh%d\"/>\n", x, y, rail_Margin)
+    item.draw(buf, x+rail_Margin, y)
+    fmt.Fprintf(buf, "<path d=\"M%d %dh%d\"/>\n", x+rail_Margin+iw, y, w-rail_Margin-iw)
+    sy := y + id + rail_Gap + su
+    sx := x + (w-sw)/2
+    fmt.Fprintf(buf, "<path d=\"M%d %dv%dh%d\"/>\n", x+w-rail_Margin/2, y, sy-y, sx+sw-(x+w-rail_Margin/2))
+    sep.draw(buf, sx, sy)
+    fmt.Fprintf(buf, "<path d=\"M%d %dh%dv%d\"/>\n", sx, sy, x+rail_Margin/2-sx, y-sy)
+  }
+}
+
+const railStyle = `path { fill: none; stroke: #333; stroke-width: 1.5; }
+rect { fill: #fff; stroke: #333; stroke-width: 1.5; }
+.term rect { fill: #e8f4e8; }
+.cat rect { fill: #e8eef8; }
+.newline rect { fill: #f4f4f4; stroke-dasharray: 4 2; }
+text { font-family: monospace; font-size: 13px; text-anchor: middle; }
+.cat text, .newline text { font-style: italic; }
+`
+
+const docStyle = `body { font-family: sans-serif; max-width: 60em; margin: 2em auto; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+` + railStyle
+
+func markdownEscape(text string) string {
+  return strings.NewReplacer("|", "\\|", "*", "\\*", "_", "\\_", "`", "\\`").Replace(text)
+}
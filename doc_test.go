@@ -0,0 +1,98 @@
+package dusl
+
+import (
+  "bytes"
+  "encoding/xml"
+  "io"
+  "strings"
+  "testing"
+)
+
+func TestDoc(t *testing.T) {
+  lang, err := LoadSpec(SourceFromString(`lexical default
+category ID "identifier"
+category NUM "number"
+application ( )
+operator BFA + -
+level additive
+operator BFA ,
+brackets ( )
+literal if
+label X "expression"
+sentence S "statement"
+sequence Q "statements"
+grammar
+# An expression is a call, a sum or an atom.
+X is> [call] f:ID(args:X,*) or> X + X or> NUM or> ID
+
+# A statement.
+S is>
+  if X
+    Q
+or>
+  X
+
+Q is>
+  <empty
+or>
+  S
+  Q
+`))
+
+  if err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+
+  buf := new(bytes.Buffer)
+  if err := lang.DocMarkdown(buf, "Test", "img/"); err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+  res := buf.String()
+  for _, tgt := range []string{
+    "| `ID` | identifier |\n",
+    "| 12 (additive) | BFA (left associative infix operators) | `+` `-` |\n",
+    "### X\n\nexpression\n\nAn expression is a call, a sum or an atom.\n\n![X](img/X.svg)\n\n",
+    "```\nX is> ID ( X,* )\n  or> X + X\n  or> NUM\n  or> ID\n```\n",
+    "```\nS is>\n  if X\n    Q\n  or> X\n```\n",
+    "```\nQ is> <empty\nor>\n  S\n  Q\n```\n",
+  } {
+    if !strings.Contains(res, tgt) {
+      t.Logf("missing: %q", tgt)
+      t.Fail()
+    }
+  }
+  if t.Failed() {
+    t.Log(res)
+  }
+
+  for _, lbl := range []string{ "X", "S", "Q" } {
+    buf := new(bytes.Buffer)
+    if err := lang.DocSVG(buf, lbl); err != nil {
+      t.Log(err)
+      t.Fail()
+      continue
+    }
+    dec := xml.NewDecoder(buf)
+    for {
+      _, err := dec.Token()
+      if err == io.EOF {
+        break
+      }
+      if err != nil {
+        t.Log(lbl, err)
+        t.Fail()
+        break
+      }
+    }
+  }
+
+  buf.Reset()
+  if err := lang.DocHTML(buf, "Test"); err != nil || !strings.Contains(buf.String(), "<svg") {
+    t.Log(err)
+    t.Fail()
+  }
+}
@@ -0,0 +1,139 @@
+// Command duslgen generates the Go source code of a typed abstract syntax tree, and
+// of the functions that build it from traces, for the grammar of a spec file (see
+// dusl.LoadSpec and dusl.Lang.GenerateGo). It is meant to be run by go generate:
+//
+//   //go:generate duslgen -o ast_gen.go lang.dusl
+//
+// The package of the generated code defaults to $GOPACKAGE, as set by go generate.
+//
+// A grammar that is built in Go by means of dusl.NewSpec is read from a function of
+// another package that returns its Lang, given by import path and name:
+//
+//   //go:generate duslgen -o ast_gen.go -go example.com/calc/lang.Calc
+//
+// where the function is declared as: func Calc() (dusl.Lang, error). Duslgen writes a
+// helper program that calls the function and GenerateGo into a temporary directory
+// below the current one, and runs it by means of "go run".
+package main
+
+import(
+  "bytes"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "strings"
+  "dusl"
+)
+
+func main() {
+  err := doIt(os.Args[1:])
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+    os.Exit(1)
+  }
+}
+
+func doIt(args []string) error {
+  flags := flag.NewFlagSet("duslgen", flag.ContinueOnError)
+  pkg := flags.String("pkg", os.Getenv("GOPACKAGE"), "package of the generated code")
+  out := flags.String("o", "", "output file (default: <specfile>_gen.go)")
+  imp := flags.String("dusl", "dusl", "import path of the dusl package")
+  fun := flags.String("go", "", "import path and name of a function that returns the Lang, instead of a spec file")
+  if err := flags.Parse(args); err != nil {
+    return err
+  }
+  if *pkg == "" || (*fun == "" && flags.NArg() != 1) || (*fun != "" && (flags.NArg() != 0 || *out == "")) {
+    return fmt.Errorf("usage: duslgen [-pkg <package>] [-o <output file>] [-dusl <import path>] <specfile>\n" +
+                      "       duslgen [-pkg <package>] -o <output file> [-dusl <import path>] -go <import path>.<function>")
+  }
+  if *fun != "" {
+    return generateFromGo(*fun, *pkg, *out, *imp)
+  }
+  path := flags.Arg(0)
+  if *out == "" {
+    *out = strings.TrimSuffix(path, filepath.Ext(path)) + "_gen.go"
+  }
+  text, err := ioutil.ReadFile(path)
+  if err != nil {
+    return err
+  }
+  lang, err := dusl.LoadSpec(&dusl.Source{ Path: path, Text: text })
+  if err != nil {
+    return err
+  }
+  buf := new(bytes.Buffer)
+  if err := lang.GenerateGo(buf, *pkg, *imp); err != nil {
+    return err
+  }
+  return ioutil.WriteFile(*out, buf.Bytes(), 0644)
+}
+
+// generateFromGo generates the code for the Lang that is returned by the given
+// function, by running a helper program that calls it. The helper program is written
+// below the current directory, such that it resolves its imports in the same module
+// or GOPATH workspace as the package that is generated for.
+func generateFromGo(fun string, pkg string, out string, imp string) error {
+  dot := strings.LastIndexByte(fun, '.')
+  if dot <= strings.LastIndexByte(fun, '/') {
+    return fmt.Errorf("expected <import path>.<function> instead of: '%s'", fun)
+  }
+  out, err := filepath.Abs(out)
+  if err != nil {
+    return err
+  }
+  dir, err := os.MkdirTemp(".", "duslgen-")
+  if err != nil {
+    return err
+  }
+  defer os.RemoveAll(dir)
+  helper := fmt.Sprintf(helperTemplate, fun[:dot], imp, fun[dot+1:], pkg, imp, out)
+  if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte(helper), 0644); err != nil {
+    return err
+  }
+  cmd := exec.Command("go", "run", "./" + filepath.Base(dir))
+  cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+  if err := cmd.Run(); err != nil {
+    return fmt.Errorf("failed to generate from: '%s': %s", fun, err.Error())
+  }
+  return nil
+}
+
+// helperTemplate is the helper program of generateFromGo, with the import path of the
+// function, the import path of dusl, the name of the function, the package of the
+// generated code, the import path of dusl again for the generated code and the path
+// of the output file.
+const helperTemplate = `// Code generated by duslgen. DO NOT EDIT.
+
+package main
+
+import (
+  "bytes"
+  "fmt"
+  "io/ioutil"
+  "os"
+  lang %q
+  dusl %q
+)
+
+func main() {
+  if err := generate(lang.%s); err != nil {
+    fmt.Fprintf(os.Stderr, "%%s\n", err.Error())
+    os.Exit(1)
+  }
+}
+
+func generate(fun func() (dusl.Lang, error)) error {
+  l, err := fun()
+  if err != nil {
+    return err
+  }
+  buf := new(bytes.Buffer)
+  if err := l.GenerateGo(buf, %q, %q); err != nil {
+    return err
+  }
+  return ioutil.WriteFile(%q, buf.Bytes(), 0644)
+}
+`
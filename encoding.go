@@ -0,0 +1,619 @@
+package dusl
+
+import (
+  "bufio"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "io"
+  "reflect"
+  "sort"
+)
+
+// The binary form of a Lang starts with a magic string followed by the format
+// version, the version must be bumped whenever the layout below changes.
+const (
+  langMagic = "dusl"
+  langVersion = 11
+)
+
+// Scanners are encoded as a tree of registered scanners and their compositions.
+const (
+  scanner_Nil = iota
+  scanner_Registered
+  scanner_Sequence
+  scanner_Composition
+  scanner_Prefix
+)
+
+// Encode writes this Lang in a compact binary form to the given writer, the
+// Lang can be reloaded from it using DecodeLang. The scanner is encoded by
+// reference: it must be a scanner registered through RegisterScanner, a
+// PrefixScanner, or a combination of these as made by Lexical and by the lexical
+// declarations of spec files.
+func (this *lang) Encode(out io.Writer) error {
+  enc := &langEncoder{ out: bufio.NewWriter(out) }
+  enc.string(langMagic)
+  enc.uint(langVersion)
+  enc.scanner(this.scanner)
+  enc.prfxTree(this.prfx)
+  enc.strings(sortedKeys(this.words))
+  enc.precedence(this.precedence)
+  enc.templates(this.templates)
+  enc.stringMap(this.descriptions)
+  enc.levels(this.levels)
+  enc.strings(this.labels)
+  enc.strings(this.categories)
+  enc.declarations(this.decls)
+  enc.stringMap(this.docs)
+  enc.stringMap(this.messages)
+  if enc.err != nil {
+    return enc.err
+  }
+  return enc.out.Flush()
+}
+
+// DecodeLang reads a Lang in the binary form written by Lang.Encode. Scanners are
+// looked up by the name under which they are registered (see RegisterScanner).
+func DecodeLang(in io.Reader) (Lang, error) {
+  dec := &langDecoder{ in: bufio.NewReader(in) }
+  if dec.string() != langMagic {
+    return nil, errors.New("not an encoded dusl Lang")
+  }
+  if version := dec.uint(); version != langVersion {
+    return nil, fmt.Errorf("unsupported encoding version: %d", version)
+  }
+  scanner := dec.scanner()
+  prfx := dec.prfxTree()
+  words := make(map[string]bool)
+  for _, word := range dec.strings() {
+    words[word] = true
+  }
+  precedence := dec.precedence()
+  templates := dec.templates()
+  descriptions := dec.stringMap()
+  levels := dec.levels()
+  labels := dec.strings()
+  categories := dec.strings()
+  decls := dec.declarations()
+  docs := dec.stringMap()
+  messages := dec.stringMap()
+  if dec.err != nil {
+    return nil, dec.err
+  }
+  lang := newLang(scanner, prfx, words, precedence, templates, descriptions, messages, levels)
+  lang.labels, lang.categories = labels, categories
+  lang.docs = docs
+  decls.scanner = scanner
+  lang.decls = decls
+  return lang, nil
+}
+
+type langEncoder struct {
+  out *bufio.Writer
+  buf [binary.MaxVarintLen64]byte
+  err error
+}
+
+func (this *langEncoder) uint(n uint64) {
+  this.out.Write(this.buf[:binary.PutUvarint(this.buf[:], n)])
+}
+
+func (this *langEncoder) int(n int) {
+  this.out.Write(this.buf[:binary.PutVarint(this.buf[:], int64(n))])
+}
+
+func (this *langEncoder) bool(b bool) {
+  if b {
+    this.out.WriteByte(1)
+  } else {
+    this.out.WriteByte(0)
+  }
+}
+
+func (this *langEncoder) string(s string) {
+  this.uint(uint64(len(s)))
+  this.out.WriteString(s)
+}
+
+func (this *langEncoder) strings(list []string) {
+  this.uint(uint64(len(list)))
+  for _, s := range list {
+    this.string(s)
+  }
+}
+
+func (this *langEncoder) stringMap(m map[string]string) {
+  keys := sortedKeys(m)
+  this.uint(uint64(len(keys)))
+  for _, key := range keys {
+    this.string(key)
+    this.string(m[key])
+  }
+}
+
+func (this *langEncoder) intMap(m map[string]int) {
+  keys := sortedKeys(m)
+  this.uint(uint64(len(keys)))
+  for _, key := range keys {
+    this.string(key)
+    this.int(m[key])
+  }
+}
+
+func (this *langEncoder) scanner(scanner Scanner) {
+  if scanner == nil {
+    this.uint(scanner_Nil)
+    return
+  }
+  if name := registeredScannerName(scanner); name != "" {
+    this.uint(scanner_Registered)
+    this.string(name)
+    return
+  }
+  switch scanner := scanner.(type) {
+  case *seqScanner:
+    this.uint(scanner_Sequence)
+    this.scanner(scanner.master)
+    this.scanner(scanner.slave)
+  case *compScanner:
+    this.uint(scanner_Composition)
+    this.scanner(scanner.scannerA)
+    this.scanner(scanner.scannerB)
+  case *prfxTree:
+    this.uint(scanner_Prefix)
+    this.prfxTree(scanner)
+  default:
+    if this.err == nil {
+      this.err = fmt.Errorf("cannot encode unregistered scanner: %T", scanner)
+    }
+  }
+}
+
+func (this *langEncoder) prfxTree(tree *prfxTree) {
+  this.string(tree.cat)
+  runes := make([]rune, 0, len(tree.children))
+  for r, _ := range tree.children {
+    runes = append(runes, r)
+  }
+  sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+  this.uint(uint64(len(runes)))
+  for _, r := range runes {
+    this.int(int(r))
+    this.prfxTree(tree.children[r])
+  }
+}
+
+func (this *langEncoder) precedence(precedence *precedenceLevels) {
+  for _, m := range precedence.maps() {
+    this.intMap(*m)
+  }
+  keys := sortedKeys(precedence.mixfix)
+  this.uint(uint64(len(keys)))
+  for _, key := range keys {
+    this.string(key)
+    this.strings(precedence.mixfix[key])
+  }
+  patterns := sortedKeys(precedence.shared)
+  this.uint(uint64(len(patterns)))
+  for _, pattern := range patterns {
+    this.string(pattern)
+    lits := sortedKeys(precedence.shared[pattern])
+    this.uint(uint64(len(lits)))
+    for _, lit := range lits {
+      this.string(lit)
+      precs := precedence.shared[pattern][lit]
+      this.uint(uint64(len(precs)))
+      for _, prec := range precs {
+        this.int(prec)
+      }
+    }
+  }
+}
+
+func (this *langEncoder) templates(templates map[string][]*templateT) {
+  keys := sortedKeys(templates)
+  this.uint(uint64(len(keys)))
+  for _, key := range keys {
+    this.string(key)
+    this.uint(uint64(len(templates[key])))
+    for _, template := range templates[key] {
+      this.template(template)
+    }
+  }
+}
+
+func (this *langEncoder) template(template *templateT) {
+  this.bool(template != nil)
+  if template == nil {
+    return
+  }
+  this.string(template.lbl)
+  this.int(template.subCount)
+  this.int(template.catCount)
+  this.bool(template.matchCat)
+  this.string(template.cat)
+  this.bool(template.matchLit)
+  this.string(template.lit)
+  this.bool(template.litSet != nil)
+  if template.litSet != nil {
+    this.strings(sortedKeys(template.litSet))
+  }
+  this.template(template.left)
+  this.template(template.right)
+  this.bool(template.mid != nil)
+  if template.mid != nil {
+    this.uint(uint64(len(template.mid)))
+    for _, mid := range template.mid {
+      this.template(mid)
+    }
+  }
+  this.string(template.loc)
+  this.string(template.alt)
+  this.string(template.name)
+  this.int(template.sugar)
+  this.string(template.msg)
+  this.string(template.reject)
+}
+
+func (this *langEncoder) levels(levels []*precedenceLevelT) {
+  this.uint(uint64(len(levels)))
+  for _, level := range levels {
+    this.int(level.precedence)
+    this.uint(uint64(len(level.layers)))
+    for _, layer := range level.layers {
+      this.layer(layer)
+    }
+  }
+}
+
+// declarations writes the layers, symbols and word operators of the given spec, in
+// order of declaration. The scanner is not included.
+func (this *langEncoder) declarations(decls *spec) {
+  this.uint(uint64(len(decls.layers)))
+  for _, layer := range decls.layers {
+    this.layer(layer)
+  }
+  this.uint(uint64(len(decls.symbols)))
+  for _, symbol := range decls.symbols {
+    this.int(symbol.typ)
+    this.string(symbol.symb)
+    this.string(symbol.lbl)
+    this.string(symbol.cat)
+    this.string(symbol.lit)
+    this.string(symbol.desc)
+    this.strings(symbol.ops)
+    this.strings(symbol.params)
+    this.string(symbol.at.location())
+  }
+  this.strings(decls.words)
+  for _, at := range decls.wordLocs {
+    this.string(at.location())
+  }
+}
+
+func (this *langEncoder) layer(layer *specLayer) {
+  this.string(layer.pattern)
+  this.strings(layer.args)
+  this.string(layer.name)
+  this.string(layer.rel)
+  this.string(layer.ref)
+  this.string(layer.at.location())
+  this.string(layer.nameAt.location())
+  this.string(layer.relAt.location())
+}
+
+type langDecoder struct {
+  in *bufio.Reader
+  err error
+}
+
+// fail records the first error encountered while decoding, after which all
+// reads return zero values.
+func (this *langDecoder) fail(err error) {
+  if err == io.EOF {
+    err = io.ErrUnexpectedEOF
+  }
+  if this.err == nil {
+    this.err = err
+  }
+}
+
+func (this *langDecoder) uint() uint64 {
+  if this.err != nil {
+    return 0
+  }
+  n, err := binary.ReadUvarint(this.in)
+  if err != nil {
+    this.fail(err)
+  }
+  return n
+}
+
+// count reads a length prefix and rejects implausibly large lengths, such that
+// corrupt input does not lead to huge allocations.
+func (this *langDecoder) count() int {
+  n := this.uint()
+  if n > 1 << 24 {
+    this.fail(errors.New("corrupt encoding: length out of range"))
+    return 0
+  }
+  return int(n)
+}
+
+func (this *langDecoder) int() int {
+  if this.err != nil {
+    return 0
+  }
+  n, err := binary.ReadVarint(this.in)
+  if err != nil {
+    this.fail(err)
+  }
+  return int(n)
+}
+
+func (this *langDecoder) bool() bool {
+  if this.err != nil {
+    return false
+  }
+  b, err := this.in.ReadByte()
+  if err != nil {
+    this.fail(err)
+  }
+  return b == 1
+}
+
+func (this *langDecoder) string() string {
+  n := this.count()
+  if this.err != nil {
+    return ""
+  }
+  buf := make([]byte, n)
+  if _, err := io.ReadFull(this.in, buf); err != nil {
+    this.fail(err)
+    return ""
+  }
+  return string(buf)
+}
+
+func (this *langDecoder) strings() []string {
+  n := this.count()
+  list := make([]string, 0, n)
+  for i := 0; i < n && this.err == nil; i++ {
+    list = append(list, this.string())
+  }
+  return list
+}
+
+func (this *langDecoder) stringMap() map[string]string {
+  n := this.count()
+  m := make(map[string]string, n)
+  for i := 0; i < n && this.err == nil; i++ {
+    key := this.string()
+    m[key] = this.string()
+  }
+  return m
+}
+
+func (this *langDecoder) intMap() map[string]int {
+  n := this.count()
+  m := make(map[string]int, n)
+  for i := 0; i < n && this.err == nil; i++ {
+    key := this.string()
+    m[key] = this.int()
+  }
+  return m
+}
+
+func (this *langDecoder) scanner() Scanner {
+  switch tag := this.uint(); tag {
+  case scanner_Nil:
+    return nil
+  case scanner_Registered:
+    name := this.string()
+    scanner := scannerRegistry[name]
+    if scanner == nil && this.err == nil {
+      this.fail(fmt.Errorf("unregistered scanner: '%s'", name))
+    }
+    return scanner
+  case scanner_Sequence:
+    master := this.scanner()
+    slave := this.scanner()
+    return &seqScanner{ master: master, slave: slave }
+  case scanner_Composition:
+    scannerA := this.scanner()
+    scannerB := this.scanner()
+    return &compScanner{ scannerA: scannerA, scannerB: scannerB }
+  case scanner_Prefix:
+    return this.prfxTree()
+  default:
+    this.fail(fmt.Errorf("corrupt encoding: unknown scanner tag: %d", tag))
+  }
+  return nil
+}
+
+func (this *langDecoder) prfxTree() *prfxTree {
+  tree := &prfxTree{ cat: this.string() }
+  n := this.count()
+  if n > 0 {
+    tree.children = make(map[rune]*prfxTree, n)
+  }
+  for i := 0; i < n && this.err == nil; i++ {
+    r := rune(this.int())
+    tree.children[r] = this.prfxTree()
+  }
+  return tree
+}
+
+func (this *langDecoder) precedence() *precedenceLevels {
+  precedence := &precedenceLevels{}
+  for _, m := range precedence.maps() {
+    *m = this.intMap()
+  }
+  n := this.count()
+  precedence.mixfix = make(map[string][]string, n)
+  for i := 0; i < n && this.err == nil; i++ {
+    key := this.string()
+    precedence.mixfix[key] = this.strings()
+  }
+  n = this.count()
+  precedence.shared = make(map[string]map[string][]int, n)
+  for i := 0; i < n && this.err == nil; i++ {
+    pattern := this.string()
+    m := this.count()
+    precedence.shared[pattern] = make(map[string][]int, m)
+    for j := 0; j < m && this.err == nil; j++ {
+      lit := this.string()
+      precs := make([]int, this.count())
+      for k := range precs {
+        precs[k] = this.int()
+      }
+      precedence.shared[pattern][lit] = precs
+    }
+  }
+  return precedence
+}
+
+func (this *langDecoder) templates() map[string][]*templateT {
+  n := this.count()
+  templates := make(map[string][]*templateT, n)
+  for i := 0; i < n && this.err == nil; i++ {
+    key := this.string()
+    m := this.count()
+    list := make([]*templateT, 0, m)
+    for j := 0; j < m && this.err == nil; j++ {
+      template := this.template()
+      if template != nil {
+        template.indexNames()
+      }
+      list = append(list, template)
+    }
+    templates[key] = list
+  }
+  return templates
+}
+
+func (this *langDecoder) template() *templateT {
+  if !this.bool() {
+    return nil
+  }
+  template := &templateT{}
+  template.lbl = this.string()
+  template.subCount = this.int()
+  template.catCount = this.int()
+  template.matchCat = this.bool()
+  template.cat = this.string()
+  template.matchLit = this.bool()
+  template.lit = this.string()
+  if this.bool() {
+    lits := this.strings()
+    template.litSet = make(map[string]bool, len(lits))
+    for _, lit := range lits {
+      template.litSet[lit] = true
+    }
+  }
+  template.left = this.template()
+  template.right = this.template()
+  if this.bool() {
+    n := this.count()
+    template.mid = make([]*templateT, 0, n)
+    for i := 0; i < n && this.err == nil; i++ {
+      template.mid = append(template.mid, this.template())
+    }
+  }
+  template.loc = this.string()
+  template.alt = this.string()
+  template.name = this.string()
+  template.sugar = this.int()
+  template.msg = this.string()
+  template.reject = this.string()
+  return template
+}
+
+func (this *langDecoder) levels() []*precedenceLevelT {
+  n := this.count()
+  levels := make([]*precedenceLevelT, 0, n)
+  for i := 0; i < n && this.err == nil; i++ {
+    level := &precedenceLevelT{ precedence: this.int() }
+    m := this.count()
+    for j := 0; j < m && this.err == nil; j++ {
+      level.layers = append(level.layers, this.layer())
+    }
+    levels = append(levels, level)
+  }
+  return levels
+}
+
+func (this *langDecoder) declarations() *spec {
+  decls := &spec{}
+  n := this.count()
+  for i := 0; i < n && this.err == nil; i++ {
+    decls.layers = append(decls.layers, this.layer())
+  }
+  n = this.count()
+  for i := 0; i < n && this.err == nil; i++ {
+    symbol := &specSymbol{ typ: this.int() }
+    symbol.symb = this.string()
+    symbol.lbl = this.string()
+    symbol.cat = this.string()
+    symbol.lit = this.string()
+    symbol.desc = this.string()
+    symbol.ops = this.strings()
+    symbol.params = this.strings()
+    symbol.at = specLoc{ loc: this.string() }
+    decls.symbols = append(decls.symbols, symbol)
+  }
+  decls.words = this.strings()
+  decls.wordLocs = make([]specLoc, len(decls.words))
+  for index := range decls.wordLocs {
+    decls.wordLocs[index] = specLoc{ loc: this.string() }
+  }
+  return decls
+}
+
+func (this *langDecoder) layer() *specLayer {
+  layer := &specLayer{ pattern: this.string(), args: this.strings() }
+  layer.name = this.string()
+  layer.rel = this.string()
+  layer.ref = this.string()
+  layer.at = specLoc{ loc: this.string() }
+  layer.nameAt = specLoc{ loc: this.string() }
+  layer.relAt = specLoc{ loc: this.string() }
+  return layer
+}
+
+// maps lists the precedence tables in the fixed order in which they are encoded.
+func (this *precedenceLevels) maps() []*map[string]int {
+  return []*map[string]int{ &this.precedenceB, &this.precedenceEFE, &this.precedenceEFA,
+                            &this.precedenceAFE, &this.precedenceAFB, &this.precedenceBFA,
+                            &this.precedenceBFB, &this.precedenceLWA, &this.precedenceAWL,
+                            &this.precedenceLA, &this.precedenceAL, &this.precedenceMIX,
+                            &this.precedenceAPP }
+}
+
+// registeredScannerName returns the (alphabetically first) name under which the
+// given scanner is registered, or the empty string if it is not registered.
+func registeredScannerName(scanner Scanner) string {
+  if !reflect.TypeOf(scanner).Comparable() {
+    return ""
+  }
+  found := ""
+  for name, registered := range scannerRegistry {
+    if registered == scanner && (found == "" || name < found) {
+      found = name
+    }
+  }
+  return found
+}
+
+// sortedKeys returns the keys of the given map with string keys in sorted order,
+// such that encodings are deterministic.
+func sortedKeys(m interface{}) []string {
+  values := reflect.ValueOf(m).MapKeys()
+  keys := make([]string, len(values))
+  for index, value := range values {
+    keys[index] = value.String()
+  }
+  sort.Strings(keys)
+  return keys
+}
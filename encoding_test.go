@@ -0,0 +1,140 @@
+package dusl
+
+import (
+  "bytes"
+  "crypto/sha256"
+  "encoding/hex"
+  "reflect"
+  "testing"
+)
+
+// encodingTestSpec is a spec that exercises every part of the encoding, it is loaded
+// from a fixed path such that the encoding does not depend on where the test runs.
+var encodingTestSpec = &Source{ Path: "encoding.dusl", Text: []byte(`lexical default
+category ID "identifier"
+category NUM "number"
+operator EFA - not
+operator BFA * /
+level multiplicative
+operator BFA + -
+operator BFA and
+operator BFA -
+above multiplicative
+mixfix ? :
+brackets ( )
+application ( )
+words not and
+shorthand ~arith~ * / +
+label X "expression"
+grammar
+X is> (X) or> X(X) or> NUM or> ID or> -X or> not X or> X ~arith~ X or> X - X or> X and X or> X ? X : X
+`) }
+
+func TestEncodeLang(t *testing.T) {
+  lang, err := LoadSpec(encodingTestSpec)
+
+  if err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+
+  buf := new(bytes.Buffer)
+  if err := lang.Encode(buf); err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+  decoded, err := DecodeLang(bytes.NewReader(buf.Bytes()))
+  if err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+
+  for _, src := range []string{ "a + 2 * f(b) and not c ? -d : e", "a ~ b", "a and" } {
+    res := decoded.Tracer().Trace(AmbitFromString(src), "X").DumpToString(true)
+    tgt := lang.Tracer().Trace(AmbitFromString(src), "X").DumpToString(true)
+    if res != tgt {
+      t.Log(res)
+      t.Log(tgt)
+      t.Fail()
+    }
+  }
+
+  shared := lang.Sparser().(*sparser).shared
+  if len(shared) == 0 || !reflect.DeepEqual(decoded.Sparser().(*sparser).shared, shared) {
+    t.Log(shared)
+    t.Fail()
+  }
+
+  res, tgt := new(bytes.Buffer), new(bytes.Buffer)
+  decoded.DumpPrecedence(res, "")
+  lang.DumpPrecedence(tgt, "")
+  if res.String() != tgt.String() {
+    t.Log(res.String())
+    t.Fail()
+  }
+
+  reencoded := new(bytes.Buffer)
+  decoded.Encode(reencoded)
+  if !bytes.Equal(reencoded.Bytes(), buf.Bytes()) {
+    t.Log("re-encoding differs")
+    t.Fail()
+  }
+
+  // the locations of the declarations survive the encoding:
+  if _, err := decoded.Extend().OperatorBFA(":").Grammar(""); err == nil ||
+     err.Error() != "encoding.dusl:11:0:12:0: MIX functor conflicts with BFA operator: ':'" {
+    t.Log(err)
+    t.Fail()
+  }
+
+  if _, err := DecodeLang(bytes.NewReader(buf.Bytes()[:buf.Len()/2])); err == nil {
+    t.Log("expected error for truncated encoding")
+    t.Fail()
+  }
+
+  unregistered, _ := NewSpec().Lexical(PrefixScanner("KW if")).Lexical(&unregisteredScanner{}).Grammar("")
+  if err := unregistered.Encode(new(bytes.Buffer)); err == nil || err.Error() != "cannot encode unregistered scanner: *dusl.unregisteredScanner" {
+    t.Log(err)
+    t.Fail()
+  }
+}
+
+// The encoding of the test spec at langVersion encodedVersion hashes to encodedHash,
+// a change of the encoding must come with a bump of langVersion and a new hash.
+const (
+  encodedVersion = 11
+  encodedHash = "ed555bea165b63f87f4778c1875446919782955a487b59e9dfc25491960bae3c"
+)
+
+func TestEncodeLangVersion(t *testing.T) {
+  lang, err := LoadSpec(encodingTestSpec)
+  if err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+
+  buf := new(bytes.Buffer)
+  if err := lang.Encode(buf); err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+  hash := sha256.Sum256(buf.Bytes())
+  switch {
+  case langVersion != encodedVersion:
+    t.Logf("record the hash of the encoding at langVersion %d: %x", langVersion, hash)
+    t.Fail()
+  case hex.EncodeToString(hash[:]) != encodedHash:
+    t.Logf("the encoding changed without a bump of langVersion, its hash is now: %x", hash)
+    t.Fail()
+  }
+}
+
+type unregisteredScanner struct {
+  emptyScanner
+  name string
+}
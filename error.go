@@ -10,22 +10,62 @@ import (
 // and the descriptive error message to be shown back to the user.
 // The Error() method is memoized so it is computed only once in a lazy fashion.
 func AmbitError(ambit *Ambit, msg string) error {
+  if ambit != nil {
+    ambit = ambit.original()
+  }
   return &ambitError{ ambit: ambit, msg: msg }
 }
 
+// A LocatedError is an error that applies to a location within a source, such as the
+// errors created by AmbitError and the errors in grammar rules (see Errors). Tools
+// can use it to present diagnostics rather than formatted error messages.
+type LocatedError interface {
+  error
+  // Ambit returns the region within the source to which the error applies. It is nil
+  // if only the location of the error is known, for example for errors in rules
+  // that are inherited from a decoded Lang (see DecodeLang and Spec.Extend).
+  Ambit() *Ambit
+  // Location returns the location of the error, see Ambit.Location.
+  Location() string
+  // Message returns the descriptive error message without the location.
+  Message() string
+}
+
 type ambitError struct {
   ambit *Ambit
+  loc string // the location if there is no ambit
   msg string
   formattedMsg string
 }
 
+// templateError returns an error located at the rule alternative of the given top
+// level template.
+func templateError(template *templateT, msg string) error {
+  return &ambitError{ ambit: template.ambit, loc: template.loc, msg: msg }
+}
+
 func (this *ambitError) Error() string {
   if this.formattedMsg == "" {
-    this.formattedMsg = fmt.Sprintf("%s: %s", this.ambit.Location(), this.msg)
+    this.formattedMsg = fmt.Sprintf("%s: %s", this.Location(), this.msg)
   }
   return this.formattedMsg
 }
 
+func (this *ambitError) Ambit() *Ambit {
+  return this.ambit
+}
+
+func (this *ambitError) Location() string {
+  if this.ambit == nil {
+    return this.loc
+  }
+  return this.ambit.Location()
+}
+
+func (this *ambitError) Message() string {
+  return this.msg
+}
+
 // A SummaryError summarizes a whole bunch of errors into one. The N field can be
 // positive in which case it represent the cut-off value, or negative in which case
 // all the errors will be reported. The Error() method is memoized so it is
@@ -64,3 +104,16 @@ func (this *summaryError) Error() string {
   }
   return this.formattedMsg
 }
+
+// Errors returns the individual errors of the given error: all the errors that are
+// summarized by a SummaryError, regardless of its cut-off value, or otherwise the
+// given error itself. Errors returns nil for nil.
+func Errors(err error) []error {
+  if err == nil {
+    return nil
+  }
+  if summary, ok := err.(*summaryError); ok {
+    return summary.errs
+  }
+  return []error{ err }
+}
@@ -0,0 +1,146 @@
+package dusl
+
+import (
+  "fmt"
+  "io"
+  "sort"
+  "strings"
+)
+
+// An Explanation records the decision the sparser took for an ambit: how it was
+// split (or why it could not be split), which other candidates were considered and
+// why they were rejected. The explanations of the sub-ambits that were sparsed as a
+// consequence of the decision are held in Subs, in the order in which they were sparsed.
+type Explanation struct {
+  Ambit *Ambit
+  MinPrecedence int
+  Decision string
+  Candidates []*ExplainedCandidate
+  Subs []*Explanation
+}
+
+// An ExplainedCandidate is a literal that could have determined the split of an
+// ambit by means of the given binding pattern. The reason is "chosen" for the
+// candidate that did determine the split.
+type ExplainedCandidate struct {
+  Pattern string
+  Lit string
+  Precedence int
+  Ambit *Ambit
+  Reason string
+}
+
+// Explain returns the syntax tree constructed for the given ambit, together with
+// the explanation of all the decisions taken by the sparser to construct it.
+func (this *sparser) Explain(ambit *Ambit) (*Syntax, *Explanation) {
+  root := &Explanation{}
+  explaining := &sparser{ precedenceLevels: this.precedenceLevels, spanner: this.spanner, explanation: root }
+  syntax := explaining.Sparse(ambit)
+  return syntax, root.Subs[0]
+}
+
+// Dump writes the explanation as an indented log, each decision is followed by
+// the candidates that were considered and then by the decisions for the sub-ambits.
+func (this *Explanation) Dump(out io.Writer, prfx string) {
+  fmt.Fprintf(out, "%s%q %s min %d: %s\n", prfx, this.Ambit.ToString(), this.Ambit, this.MinPrecedence, this.Decision)
+  for _, candidate := range this.Candidates {
+    fmt.Fprintf(out, "%s  - %s '%s' %s at %d: %s\n", prfx, candidate.Pattern, candidate.Lit, candidate.Ambit,
+                candidate.Precedence, candidate.Reason)
+  }
+  for _, sub := range this.Subs {
+    sub.Dump(out, prfx + "  ")
+  }
+}
+
+func (this *sparser) explain(format string, args ...interface{}) {
+  if this.explanation != nil {
+    this.explanation.Decision = fmt.Sprintf(format, args...)
+  }
+}
+
+// A splitReasonsT is the sink in which findSplit records the candidates it considers,
+// together with the reason why each is rejected. The sparser only passes it when
+// it explains its decisions, on nil all the methods are no-ops.
+type splitReasonsT struct {
+  mixfix map[string][]string
+  candidates []*ExplainedCandidate
+  split *ExplainedCandidate
+}
+
+// reject records the candidate for the given pattern, with the reason formatted by
+// the given precedence.
+func (this *splitReasonsT) reject(pattern string, span *spanT, prec int, reason string, reasonPrec int) {
+  if this == nil || prec == 0 {
+    return
+  }
+  if strings.Contains(reason, "%d") {
+    reason = fmt.Sprintf(reason, reasonPrec)
+  }
+  lit := span.Lit
+  if pattern == "MIX" {
+    lit = strings.Join(this.mixfix[lit], " ")
+  }
+  this.candidates = append(this.candidates, &ExplainedCandidate{ Pattern: pattern, Lit: lit, Precedence: prec,
+                                                                 Ambit: span.Ambit, Reason: reason })
+}
+
+// outrank records a candidate that is rejected in favour of the split at the given
+// precedence.
+func (this *splitReasonsT) outrank(pattern string, span *spanT, prec int, splitPrec int) {
+  if prec > splitPrec {
+    this.reject(pattern, span, prec, "binds tighter than the split at precedence %d", splitPrec)
+  } else {
+    this.reject(pattern, span, prec, "preceded by the split at precedence %d", splitPrec)
+  }
+}
+
+// propose records the candidate that is the split found so far, the previous split
+// it replaces binds tighter.
+func (this *splitReasonsT) propose(pattern string, span *spanT, prec int) {
+  if this == nil {
+    return
+  }
+  if this.split != nil {
+    this.split.Reason = fmt.Sprintf("binds tighter than the split at precedence %d", prec)
+  }
+  this.reject(pattern, span, prec, "chosen", 0)
+  this.split = this.candidates[len(this.candidates)-1]
+}
+
+// rejectInfix records an infix or mixfix candidate that is no valid split for the
+// subrange of the list starting at lo.
+func (this *splitReasonsT) rejectInfix(list *spanListT, candidate *infixCandidateT, lo int, minPrecedence int) {
+  pattern, span, prec := infixPatterns[candidate.order], list.spans[candidate.loc], candidate.prec
+  minPrecLeft, minPrecRight := infixOperandChecks(candidate.order, prec)
+  switch {
+  case prec < minPrecedence:
+    this.reject(pattern, span, prec, "precedence below minimum %d", minPrecedence)
+  case candidate.left < lo:
+    this.reject(pattern, span, prec, "no left operand at precedence %d", minPrecLeft)
+  case candidate.order == orderMIX && candidate.locs == nil:
+    this.reject(pattern, span, prec, "incomplete mixfix operator", 0)
+  default:
+    this.reject(pattern, span, prec, "no right operand at precedence %d", minPrecRight)
+  }
+}
+
+// explainSplit records the decision for a range of at least two spans, together
+// with the candidates from reasons in the order of their location.
+func (this *sparser) explainSplit(spans []*spanT, minPrecedence int, split splitT, reasons *splitReasonsT) {
+  l := len(spans)-1
+  candidates := reasons.candidates
+  sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Ambit.Start < candidates[j].Ambit.Start })
+  this.explanation.Candidates = candidates
+  switch split.pattern {
+  case "JUXT", "GLUE":
+    this.explain("no split at precedence %d or above: %s fallback", minPrecedence, split.pattern)
+  case "LWA":
+    this.explain("split on %s '%s' at precedence %d", split.pattern, spans[0].Lit, split.prec)
+  case "AWL":
+    this.explain("split on %s '%s' at precedence %d", split.pattern, spans[l].Lit, split.prec)
+  case "MIX":
+    this.explain("split on %s '%s' at precedence %d", split.pattern, strings.Join(this.mixfix[spans[split.loc].Lit], " "), split.prec)
+  default:
+    this.explain("split on %s '%s' at precedence %d", split.pattern, spans[split.loc].Lit, split.prec)
+  }
+}
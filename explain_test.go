@@ -0,0 +1,155 @@
+package dusl
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+
+	lang, err := NewSpec().
+		Lexical(DefaultScanner).
+		OperatorBFA("+").
+		OperatorAFB("*").
+		OperatorEFA("-").
+		JuxtapositionLWA("f").
+		Brackets("( )").
+		Grammar("")
+
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	sparser := lang.Sparser()
+
+	text := []byte(`f x * -(1 + 2) + y`)
+	source := &Source{Path: "tst", Text: text}
+	ambit := source.FullAmbit()
+
+	tree, explanation := sparser.Explain(ambit)
+
+	if tree.DumpToString(false) != sparser.Sparse(ambit).DumpToString(false) {
+		t.Log(tree.DumpToString(false))
+		t.Fail()
+	}
+
+	buf := new(bytes.Buffer)
+	explanation.Dump(buf, "> ")
+	res := buf.String()
+	tgt := `> "f x * -(1 + 2) + y" tst[0:18] min 1: split on LWA 'f' at precedence 11
+>   - LWA 'f' tst[0:1] at 11: chosen
+>   - AFB '*' tst[4:5] at 13: no right operand at precedence 14
+>   - BFA '+' tst[15:16] at 14: binds tighter than the split at precedence 11
+>   "f" tst[0:1] min 11: single ID 'f'
+>   "x * -(1 + 2) + y" tst[2:18] min 11: split on BFA '+' at precedence 14
+>     - AFB '*' tst[4:5] at 13: no right operand at precedence 14
+>     - BFA '+' tst[15:16] at 14: chosen
+>     "x * -(1 + 2)" tst[2:14] min 14: no split at precedence 14 or above: JUXT fallback
+>       - AFB '*' tst[4:5] at 13: precedence below minimum 14
+>       "x" tst[2:3] min 14: single ID 'x'
+>       "* -(1 + 2)" tst[4:14] min 14: no split at precedence 14 or above: JUXT fallback
+>         "*" tst[4:5] min 14: operator without operands: '*' is no EFE operator at precedence 14 or above
+>         "-(1 + 2)" tst[6:14] min 14: no split at precedence 14 or above: GLUE fallback
+>           - EFA '-' tst[6:7] at 12: precedence below minimum 14
+>           "-" tst[6:7] min 14: operator without operands: '-' is no EFE operator at precedence 14 or above
+>           "(1 + 2)" tst[7:14] min 14: brackets '( )' at precedence 10
+>             "1 + 2" tst[8:13] min 10: split on BFA '+' at precedence 14
+>               - BFA '+' tst[10:11] at 14: chosen
+>               "1" tst[8:9] min 14: single NUM '1'
+>               "2" tst[12:13] min 15: single NUM '2'
+>     "y" tst[17:18] min 15: single ID 'y'
+`
+	if res != tgt {
+		t.Log(res)
+		t.Fail()
+	}
+}
+
+// explanationMismatch returns a description of the first candidate in the given
+// explanation or its subs whose reason does not match the decision, if any.
+func explanationMismatch(explanation *Explanation) string {
+	var chosen *ExplainedCandidate
+	for _, candidate := range explanation.Candidates {
+		if candidate.Reason == "chosen" {
+			if chosen != nil {
+				return fmt.Sprintf("%s: two candidates chosen", explanation.Ambit)
+			}
+			chosen = candidate
+		}
+	}
+	split := strings.HasPrefix(explanation.Decision, "split on ")
+	if chosen == nil {
+		if split {
+			return fmt.Sprintf("%s: no candidate chosen for: %s", explanation.Ambit, explanation.Decision)
+		}
+	} else if decision := fmt.Sprintf("split on %s '%s' at precedence %d", chosen.Pattern, chosen.Lit, chosen.Precedence); decision != explanation.Decision {
+		return fmt.Sprintf("%s: chosen %s for: %s", explanation.Ambit, decision, explanation.Decision)
+	}
+	for _, candidate := range explanation.Candidates {
+		var prec int
+		switch {
+		case candidate == chosen:
+			continue
+		case sscanReason(candidate.Reason, "precedence below minimum %d", &prec):
+			if prec != explanation.MinPrecedence || candidate.Precedence >= prec {
+				return fmt.Sprintf("%s: %s '%s' at %d: %s", explanation.Ambit, candidate.Pattern, candidate.Lit, candidate.Precedence, candidate.Reason)
+			}
+		case sscanReason(candidate.Reason, "binds tighter than the split at precedence %d", &prec):
+			if chosen == nil || candidate.Precedence <= prec || prec < chosen.Precedence {
+				return fmt.Sprintf("%s: %s '%s' at %d: %s", explanation.Ambit, candidate.Pattern, candidate.Lit, candidate.Precedence, candidate.Reason)
+			}
+		case sscanReason(candidate.Reason, "preceded by the split at precedence %d", &prec):
+			if chosen == nil || candidate.Precedence != prec || prec < chosen.Precedence {
+				return fmt.Sprintf("%s: %s '%s' at %d: %s", explanation.Ambit, candidate.Pattern, candidate.Lit, candidate.Precedence, candidate.Reason)
+			}
+		default: // the candidate itself is invalid
+			if candidate.Precedence < explanation.MinPrecedence {
+				return fmt.Sprintf("%s: %s '%s' at %d: %s", explanation.Ambit, candidate.Pattern, candidate.Lit, candidate.Precedence, candidate.Reason)
+			}
+		}
+	}
+	for _, sub := range explanation.Subs {
+		if mismatch := explanationMismatch(sub); mismatch != "" {
+			return mismatch
+		}
+	}
+	return ""
+}
+
+func sscanReason(reason string, format string, prec *int) bool {
+	n, _ := fmt.Sscanf(reason, format, prec)
+	return n == 1
+}
+
+func TestExplainReasons(t *testing.T) {
+
+	rnd := rand.New(rand.NewSource(1))
+
+	for specIndex := 0; specIndex < 50; specIndex++ {
+		lang, err := randomSparserSpec(rnd).Grammar("")
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		sparser := lang.Sparser()
+		for textIndex := 0; textIndex < 100; textIndex++ {
+			buf := new(bytes.Buffer)
+			randomSparserText(rnd, buf, 0)
+			source := &Source{Path: "tst", Text: buf.Bytes()}
+			tree, explanation := sparser.Explain(source.FullAmbit())
+			if mismatch := explanationMismatch(explanation); mismatch != "" {
+				dump := new(bytes.Buffer)
+				explanation.Dump(dump, "")
+				t.Logf("spec %d, text %q: %s\n%s\n%s", specIndex, buf.String(), mismatch, dump.String(), tree.DumpToString(true))
+				t.Fail()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package dusl
+
+import (
+  "bytes"
+  "errors"
+  "fmt"
+  "go/format"
+  "go/token"
+  "io"
+  "strings"
+)
+
+// GenerateGo writes Go source code for a typed abstract syntax tree of this Lang,
+// see the Lang interface.
+func (this *lang) GenerateGo(out io.Writer, pkg string, duslImport string) error {
+  gen := &goGenT{ lang: this, buf: new(bytes.Buffer), listHelpers: make(map[string]bool), optHelpers: make(map[string]bool),
+                  decls: make(map[string]string) }
+  gen.printf("// Code generated by duslgen. DO NOT EDIT.\n\n")
+  gen.printf("package %s\n\n", pkg)
+  gen.printf("import \"%s\"\n\n", duslImport)
+  for _, lbl := range this.ruleLabels() {
+    gen.label(lbl)
+  }
+  for _, lbl := range sortedKeys(gen.listHelpers) {
+    typ := goName(lbl)
+    gen.printf("func parse%sList(trace *dusl.Trace) []%s {\n", typ, typ)
+    gen.printf("elems := trace.List()\n")
+    gen.printf("list := make([]%s, len(elems))\n", typ)
+    gen.printf("for index, elem := range elems {\nlist[index] = build%s(elem)\n}\n", typ)
+    gen.printf("return list\n}\n\n")
+  }
+  for _, lbl := range sortedKeys(gen.optHelpers) {
+    typ := goName(lbl)
+    gen.printf("func parse%sOpt(trace *dusl.Trace) %s {\n", typ, typ)
+    gen.printf("if opt := trace.Opt(); opt != nil {\nreturn build%s(opt)\n}\n", typ)
+    gen.printf("return nil\n}\n\n")
+  }
+  if len(gen.errs) > 0 {
+    return SummaryError(gen.errs, 20)
+  }
+  src, err := format.Source(gen.buf.Bytes())
+  if err != nil {
+    return fmt.Errorf("generated invalid Go code: %s", err.Error()) // <-- defensive
+  }
+  _, err = out.Write(src)
+  return err
+}
+
+type goGenT struct {
+  lang *lang
+  buf *bytes.Buffer
+  listHelpers map[string]bool // element labels of list placeholders
+  optHelpers map[string]bool // element labels of optional placeholders
+  decls map[string]string // what the generated top level identifiers are declared for
+  errs []error
+}
+
+// goPlaceholderT is a placeholder of a rule alternative that becomes a field of the
+// struct of the alternative.
+type goPlaceholderT struct {
+  field string
+  typ string
+  expr string // the expression that computes the field from a trace
+}
+
+func (this *goGenT) printf(format string, args ...interface{}) {
+  fmt.Fprintf(this.buf, format, args...)
+}
+
+// declare records the given generated top level identifier, and reports it if it is
+// already declared. The given template locates the error, if any.
+func (this *goGenT) declare(name string, what string, template *templateT) {
+  if first, present := this.decls[name]; present {
+    this.errs = append(this.errs, this.error(template, fmt.Sprintf("double declaration of generated Go identifier for %s: '%s' (first declared for %s)",
+                                                                   what, name, first)))
+    return
+  }
+  this.decls[name] = what
+}
+
+// members reports the fields of the struct of the given alternative that conflict with
+// each other or with its methods, and the constructor parameters that conflict with
+// each other or with the ambit parameter.
+func (this *goGenT) members(template *templateT, what string, placeholders []*goPlaceholderT) {
+  fields := map[string]string{ "Ambit": "the Ambit method" }
+  params := map[string]string{ "ambit": "the ambit parameter" }
+  for _, placeholder := range placeholders {
+    if first, present := fields[placeholder.field]; present {
+      this.errs = append(this.errs, this.error(template, fmt.Sprintf("generated Go field of %s conflicts with %s: '%s'",
+                                                                     what, first, placeholder.field)))
+      continue
+    }
+    fields[placeholder.field] = "another field"
+    param := goParam(placeholder.field)
+    if first, present := params[param]; present {
+      this.errs = append(this.errs, this.error(template, fmt.Sprintf("generated Go constructor parameter of %s conflicts with %s: '%s'",
+                                                                     what, first, param)))
+      continue
+    }
+    params[param] = "another parameter"
+  }
+}
+
+// error returns an error located at the given template, or an error without location
+// if there is no template.
+func (this *goGenT) error(template *templateT, msg string) error {
+  if template == nil {
+    return errors.New(msg)
+  }
+  return templateError(template, msg)
+}
+
+func (this *goGenT) label(lbl string) {
+  typ := goName(lbl)
+  desc := this.lang.descriptions[lbl]
+  templates := this.lang.templates[lbl]
+  var first *templateT
+  if len(templates) > 0 {
+    first = templates[0]
+  }
+  this.declare(typ, fmt.Sprintf("label '%s'", lbl), first)
+  this.declare("Parse" + typ, fmt.Sprintf("the parse function of label '%s'", lbl), first)
+  this.printf("// %s is a node of label %q (%s).\n", typ, lbl, desc)
+  this.printf("type %s interface {\nAmbit() *dusl.Ambit\nis%s()\n}\n\n", typ, typ)
+
+  alts := make([]string, len(templates))
+  for index, template := range templates {
+    alt := fmt.Sprintf("%sAlt%d", typ, index)
+    what := fmt.Sprintf("alternative %d of label '%s'", index, lbl)
+    if template.alt != "" {
+      alt = typ + goName(template.alt)
+      what = fmt.Sprintf("alternative %d (%s) of label '%s'", index, template.alt, lbl)
+    }
+    alts[index] = alt
+    placeholders := this.placeholders(template)
+    this.declare(alt, what, template)
+    this.declare("New" + alt, "the constructor of " + what, template)
+    this.members(template, what, placeholders)
+
+    if template.alt != "" {
+      this.printf("// %s is alternative %d (%s) of label %q.\n", alt, index, template.alt, lbl)
+    } else {
+      this.printf("// %s is alternative %d of label %q.\n", alt, index, lbl)
+    }
+    this.printf("type %s struct {\nambit *dusl.Ambit\n", alt)
+    for _, placeholder := range placeholders {
+      this.printf("%s %s\n", placeholder.field, placeholder.typ)
+    }
+    this.printf("}\n\n")
+
+    params := []string{ "ambit *dusl.Ambit" }
+    inits := []string{ "ambit: ambit" }
+    for _, placeholder := range placeholders {
+      param := goParam(placeholder.field)
+      params = append(params, param + " " + placeholder.typ)
+      inits = append(inits, placeholder.field + ": " + param)
+    }
+    this.printf("// New%s returns a new %s located at the given ambit.\n", alt, alt)
+    this.printf("func New%s(%s) *%s {\nreturn &%s{ %s }\n}\n\n", alt, strings.Join(params, ", "), alt, alt, strings.Join(inits, ", "))
+    this.printf("func (this *%s) Ambit() *dusl.Ambit {\nreturn this.ambit\n}\n\n", alt)
+    this.printf("func (this *%s) is%s() {}\n\n", alt, typ)
+  }
+
+  this.printf("// Parse%s returns the %s of the given trace of label %q, or the errors of\n", typ, typ, lbl)
+  this.printf("// the trace if it has any.\n")
+  this.printf("func Parse%s(trace *dusl.Trace) (%s, error) {\n", typ, typ)
+  this.printf("if err := trace.ErrorN(20); err != nil {\nreturn nil, err\n}\nreturn build%s(trace), nil\n}\n\n", typ)
+
+  // named alternatives are told apart by name, such that the generated code does not
+  // depend on their order:
+  named, unnamed := new(bytes.Buffer), new(bytes.Buffer)
+  for index, template := range templates {
+    args := []string{ "trace.Syn.Ambit" }
+    for _, placeholder := range this.placeholders(template) {
+      args = append(args, placeholder.expr)
+    }
+    if template.alt != "" {
+      fmt.Fprintf(named, "case %q:\nreturn New%s(%s)\n", template.alt, alts[index], strings.Join(args, ", "))
+    } else {
+      fmt.Fprintf(unnamed, "case %d:\nreturn New%s(%s)\n", index, alts[index], strings.Join(args, ", "))
+    }
+  }
+  this.printf("func build%s(trace *dusl.Trace) %s {\n", typ, typ)
+  if named.Len() > 0 {
+    this.printf("switch trace.Alt {\n%s}\n", named.String())
+  }
+  if unnamed.Len() > 0 {
+    this.printf("switch trace.Idx {\n%s}\n", unnamed.String())
+  }
+  this.printf("panic(%q + trace.Lbl)\n}\n\n", "trace of another grammar: ") // <-- traces without errors have one of the alternatives
+}
+
+// placeholders returns the placeholders of the given top level template, in order of
+// Trace.Subs followed by the placeholders in order of Trace.Cats.
+func (this *goGenT) placeholders(template *templateT) []*goPlaceholderT {
+  var subs, cats []*goPlaceholderT
+  var rec func(template *templateT)
+  rec = func(template *templateT) {
+    if template == nil {
+      return
+    }
+    if template.lbl != "" {
+      subs = append(subs, this.sub(template, len(subs)))
+      return
+    }
+    if template.left != nil {
+      if template.matchLit && template.litSet != nil {
+        cats = append(cats, this.cat(template, "Op", len(cats)))
+      }
+      rec(template.left)
+      for _, mid := range template.mid {
+        rec(mid)
+      }
+      rec(template.right)
+      return
+    }
+    if !(template.matchLit && template.litSet == nil) && template.matchCat && template.cat != "" {
+      cats = append(cats, this.cat(template, goName(template.cat), len(cats)))
+    }
+  }
+  rec(template)
+  return append(subs, cats...)
+}
+
+func (this *goGenT) sub(template *templateT, index int) *goPlaceholderT {
+  expr := fmt.Sprintf("trace.Subs[%d]", index)
+  kind, elem, _ := this.lang.sugarOf(template.lbl)
+  placeholder := &goPlaceholderT{}
+  switch kind {
+  case '?':
+    this.optHelpers[elem] = true
+    placeholder.typ = goName(elem)
+    placeholder.expr = fmt.Sprintf("parse%sOpt(%s)", goName(elem), expr)
+  case '+', '*':
+    this.listHelpers[elem] = true
+    placeholder.typ = "[]" + goName(elem)
+    placeholder.expr = fmt.Sprintf("parse%sList(%s)", goName(elem), expr)
+  default:
+    elem = template.lbl
+    placeholder.typ = goName(elem)
+    placeholder.expr = fmt.Sprintf("build%s(%s)", goName(elem), expr)
+  }
+  placeholder.field = fmt.Sprintf("%s%d", goName(elem), index)
+  if template.name != "" {
+    placeholder.field = goName(template.name)
+  }
+  return placeholder
+}
+
+func (this *goGenT) cat(template *templateT, prfx string, index int) *goPlaceholderT {
+  field := fmt.Sprintf("%s%d", prfx, index)
+  if template.name != "" {
+    field = goName(template.name)
+  }
+  return &goPlaceholderT{ field: field, typ: "*dusl.Syntax", expr: fmt.Sprintf("trace.Cats[%d]", index) }
+}
+
+// goParam returns the name of the constructor parameter for the given field: the
+// field name with its leading capital(s) in lower case, such as "num0" for "NUM0" and
+// "idList" for "IDList".
+func goParam(field string) string {
+  upper := 0
+  for upper < len(field) && field[upper] >= 'A' && field[upper] <= 'Z' {
+    upper++
+  }
+  if upper > 1 && upper < len(field) && field[upper] >= 'a' && field[upper] <= 'z' {
+    upper--
+  }
+  param := strings.ToLower(field[:upper]) + field[upper:]
+  if token.Lookup(param).IsKeyword() {
+    param += "_"
+  }
+  return param
+}
+
+// goPunctuation names the punctuation characters that occur in the labels of macro
+// instances, such as: List(expr, ",").
+var goPunctuation = map[rune]string{
+  ',': "Comma", ';': "Semicolon", ':': "Colon", '.': "Dot", '+': "Plus", '-': "Minus",
+  '*': "Star", '/': "Slash", '%': "Percent", '=': "Eq", '<': "Lt", '>': "Gt", '!': "Bang",
+  '&': "Amp", '|': "Bar", '^': "Caret", '~': "Tilde", '?': "Question", '@': "At",
+  '#': "Hash", '$': "Dollar", '(': "LParen", ')': "RParen", '[': "LBrack", ']': "RBrack",
+  '{': "LBrace", '}': "RBrace", '\\': "Backslash", '\'': "Quote",
+}
+
+// goName returns an exported Go identifier for the given label, alternative or
+// placeholder name. The structure of macro instance labels is dropped, except for the
+// punctuation inside string arguments which is spelled out, for example: the label
+// List(expr, ",") becomes ListExprComma.
+func goName(name string) string {
+  buf := new(bytes.Buffer)
+  upper, quoted := true, false
+  for _, c := range name {
+    switch {
+    case c == '"':
+      quoted = !quoted
+      upper = true
+    case c == '_' || (c >= '0' && c <= '9' && buf.Len() > 0):
+      buf.WriteRune(c)
+    case (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z'):
+      if upper && c >= 'a' {
+        c -= 'a'-'A'
+      }
+      buf.WriteRune(c)
+      upper = false
+    case quoted && goPunctuation[c] != "":
+      buf.WriteString(goPunctuation[c])
+      upper = true
+    case quoted && c != ' ':
+      fmt.Fprintf(buf, "U%04X", c)
+      upper = true
+    default:
+      upper = true
+    }
+  }
+  return buf.String()
+}
@@ -0,0 +1,146 @@
+package dusl
+
+import (
+  "bytes"
+  "go/ast"
+  "go/importer"
+  "go/parser"
+  "go/token"
+  "go/types"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+func TestGenerateGo(t *testing.T) {
+  lang, err := LoadSpec(SourceFromString(`lexical default
+category ID "identifier"
+category NUM "number"
+application ( )
+operator BFA + -
+operator BFA ,
+brackets ( )
+shorthand ~add~ + -
+label X "expression"
+macro List "list of {x} separated by {sep}" x sep
+grammar
+X is> [call] fun:ID(args:X,*) or> (X) or> X ~add~ X or> NUM or> [tuple] (items:List(X, ","))
+List(x, sep) is> List(x, sep) sep x or> x
+`))
+
+  if err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+
+  buf := new(bytes.Buffer)
+  if err := lang.GenerateGo(buf, "ast", "dusl"); err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+  res := buf.String()
+
+  for _, tgt := range []string{
+    "package ast\n",
+    "type X interface {\n\tAmbit() *dusl.Ambit\n\tisX()\n}",
+    "func NewXCall(ambit *dusl.Ambit, args []X, fun *dusl.Syntax) *XCall {",
+    "func NewXAlt2(ambit *dusl.Ambit, x0 X, x1 X, op0 *dusl.Syntax) *XAlt2 {",
+    "func NewXAlt3(ambit *dusl.Ambit, num0 *dusl.Syntax) *XAlt3 {",
+    "func ParseX(trace *dusl.Trace) (X, error) {\n\tif err := trace.ErrorN(20); err != nil {\n\t\treturn nil, err\n\t}\n\treturn buildX(trace), nil\n}",
+    "\tswitch trace.Alt {\n\tcase \"call\":\n\t\treturn NewXCall(trace.Syn.Ambit, parseXList(trace.Subs[0]), trace.Cats[0])\n",
+    "\tcase \"tuple\":\n\t\treturn NewXTuple(trace.Syn.Ambit, buildListXComma(trace.Subs[0]))\n",
+    "\tswitch trace.Idx {\n\tcase 1:\n\t\treturn NewXAlt1(trace.Syn.Ambit, buildX(trace.Subs[0]))\n",
+    "type ListXComma interface {",
+    "func parseXList(trace *dusl.Trace) []X {",
+  } {
+    if !strings.Contains(res, tgt) {
+      t.Logf("missing: %s", tgt)
+      t.Fail()
+    }
+  }
+  if err := typeCheckGo(res); err != nil {
+    t.Log(err)
+    t.Fail()
+  }
+  if t.Failed() {
+    t.Log(res)
+  }
+
+  for _, tst := range []struct{ grammar string; err string }{
+    { "X is> [alt1] ID or> NUM or> X + X",
+      "str:8:20:23: double declaration of generated Go identifier for alternative 1 of label 'X': 'XAlt1' (first declared for alternative 0 (alt1) of label 'X')\n" +
+      "str:8:20:23: double declaration of generated Go identifier for the constructor of alternative 1 of label 'X': 'NewXAlt1' (first declared for the constructor of alternative 0 (alt1) of label 'X')\n" },
+    { "X is> ambit:ID + X or> NUM",
+      "str:8:6:18: generated Go field of alternative 0 of label 'X' conflicts with the Ambit method: 'Ambit'\n" },
+    { "X is> a:X + b:X or> NUM or> Ambit:ID",
+      "str:8:28:36: generated Go field of alternative 2 of label 'X' conflicts with the Ambit method: 'Ambit'\n" },
+    { "X is> IDList:X + idList:X or> NUM",
+      "str:8:6:25: generated Go constructor parameter of alternative 0 of label 'X' conflicts with another parameter: 'idList'\n" },
+    { "X is> [call] ID or> NUM\nXCall is> X",
+      "str:9:10:11: double declaration of generated Go identifier for label 'XCall': 'XCall' (first declared for alternative 0 (call) of label 'X')\n" },
+  } {
+    lang, err := LoadSpec(SourceFromString(`lexical default
+category ID "identifier"
+category NUM "number"
+operator BFA +
+label X "expression"
+label XCall "call expression"
+grammar
+` + tst.grammar + "\n"))
+    if err != nil {
+      t.Log(err)
+      t.Fail()
+      continue
+    }
+    if err := lang.GenerateGo(new(bytes.Buffer), "ast", "dusl"); err == nil || err.Error() != tst.err {
+      t.Log(tst.grammar, err)
+      t.Fail()
+    }
+  }
+}
+
+// typeCheckGo type checks the given generated source, against the sources of this
+// package for its import of the dusl package.
+func typeCheckGo(src string) error {
+  fset := token.NewFileSet()
+  paths, err := filepath.Glob("*.go")
+  if err != nil {
+    return err
+  }
+  var files []*ast.File
+  for _, path := range paths {
+    if strings.HasSuffix(path, "_test.go") {
+      continue
+    }
+    file, err := parser.ParseFile(fset, path, nil, 0)
+    if err != nil {
+      return err
+    }
+    files = append(files, file)
+  }
+  std := importer.Default()
+  dusl, err := (&types.Config{ Importer: std }).Check("dusl", fset, files, nil)
+  if err != nil {
+    return err
+  }
+  gen, err := parser.ParseFile(fset, "ast_gen.go", src, 0)
+  if err != nil {
+    return err
+  }
+  imports := importerFunc(func(path string) (*types.Package, error) {
+    if path == "dusl" {
+      return dusl, nil
+    }
+    return std.Import(path)
+  })
+  _, err = (&types.Config{ Importer: imports }).Check("ast", fset, []*ast.File{ gen }, nil)
+  return err
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (this importerFunc) Import(path string) (*types.Package, error) {
+  return this(path)
+}
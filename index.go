@@ -0,0 +1,87 @@
+package dusl
+
+// templateIndexT dispatches the syntax nodes that are traced with a label to the
+// alternatives of the label that can match them, based on the category and literal of
+// the node. Every candidate list is in ascending order of alternative, such that
+// trying the candidates in order preserves the first-match semantics of the tracer.
+type templateIndexT struct {
+  lits map[string]map[string][]int // candidates by category and literal
+  cats map[string][]int // candidates by category, for the literals without an entry in lits
+  any []int // candidates for the categories without an entry in cats
+}
+
+// newTemplateIndex indexes the given alternatives of a label by the category and
+// literal that their root requires, if any.
+func newTemplateIndex(templates []*templateT) *templateIndexT {
+  index := &templateIndexT{ lits: make(map[string]map[string][]int), cats: make(map[string][]int) }
+  for _, template := range templates {
+    if template.lbl == "" && template.matchCat {
+      index.cats[template.cat] = nil
+      if template.matchLit {
+        if index.lits[template.cat] == nil {
+          index.lits[template.cat] = make(map[string][]int)
+        }
+        for _, lit := range template.rootLits() {
+          index.lits[template.cat][lit] = nil
+        }
+      }
+    }
+  }
+  for idx, template := range templates {
+    switch {
+    case template.lbl != "" || !template.matchCat:
+      index.any = append(index.any, idx)
+      for cat, candidates := range index.cats {
+        index.cats[cat] = append(candidates, idx)
+      }
+      for _, lits := range index.lits {
+        for lit, candidates := range lits {
+          lits[lit] = append(candidates, idx)
+        }
+      }
+    case !template.matchLit:
+      index.cats[template.cat] = append(index.cats[template.cat], idx)
+      for lit, candidates := range index.lits[template.cat] {
+        index.lits[template.cat][lit] = append(candidates, idx)
+      }
+    default:
+      for _, lit := range template.rootLits() {
+        index.lits[template.cat][lit] = append(index.lits[template.cat][lit], idx)
+      }
+    }
+  }
+  return index
+}
+
+// rootLits returns the literals that the root of this template matches, provided it
+// matches literals.
+func (this *templateT) rootLits() []string {
+  if this.litSet != nil {
+    return sortedKeys(this.litSet)
+  }
+  return []string{ this.lit }
+}
+
+// candidates returns the indices of the alternatives that can match the given node,
+// in ascending order.
+func (this *templateIndexT) candidates(node *Syntax) []int {
+  if node == nil {
+    return nil
+  }
+  if candidates, ok := this.lits[node.Cat][node.Lit]; ok {
+    return candidates
+  }
+  if candidates, ok := this.cats[node.Cat]; ok {
+    return candidates
+  }
+  return this.any
+}
+
+// candidates returns the indices of the alternatives of the given label that can
+// match the given node, see templateIndexT.
+func (this *tracer) candidates(node *Syntax, lbl string) []int {
+  if index := this.index[lbl]; index != nil {
+    return index.candidates(node)
+  }
+  return nil
+}
@@ -0,0 +1,213 @@
+package dusl
+
+import (
+  "strings"
+)
+
+// A CategoryInfo describes a declared lexical category, see Spec.Category.
+type CategoryInfo struct {
+  Cat string
+  Desc string
+}
+
+// A LayerInfo describes a declared layer of operators, brackets, applications,
+// juxtapositions or glue. The Pattern field contains the binding pattern as it is
+// shown by Lang.DumpPrecedence, for example: "BFA", "MIX" or "B". The Level field
+// contains the name of the layer, if it was given one by means of Spec.Level. The
+// Symbols field contains the operators, mixfix operators, bracket pairs or literals
+// of the layer as they were declared, for example: "+", "? :" or "( )".
+type LayerInfo struct {
+  Pattern string
+  Precedence int
+  Level string
+  Symbols []string
+}
+
+// A BracketsInfo describes a declared bracket pair. The Application field is true
+// iff the pair is also declared as an application, see Spec.Application.
+type BracketsInfo struct {
+  Open string
+  Close string
+  Application bool
+}
+
+// A LiteralInfo describes a declared literal with the lexical category that the
+// scanner assigns to it, see Spec.Literal.
+type LiteralInfo struct {
+  Lit string
+  Cat string
+}
+
+// A LabelInfo describes a label of the grammar. The Kind field is one of "label",
+// "sentence label", "sequence label", "macro instance" (see Spec.Macro) or "hidden
+// label" (the labels of list and optional placeholders, see Trace.List and
+// Trace.Opt). The Doc field contains the comment lines directly above the rules of
+// the label in the grammar, see Lang.DocMarkdown. The Error field contains the custom
+// error message of the label, see Spec.Grammar.
+type LabelInfo struct {
+  Lbl string
+  Kind string
+  Desc string
+  Doc string
+  Error string
+  Alts []*AltInfo
+}
+
+// An AltInfo describes a rule alternative of a label. The Idx and Alt fields
+// correspond to the same fields of a Trace to which the alternative is applied. The
+// Loc field contains the location of the alternative in the grammar and the Text
+// field contains the alternative in the notation of the grammar. The Error field
+// contains the custom error message of the alternative, the Reject field contains the
+// error message of an error production, see Spec.Grammar.
+type AltInfo struct {
+  Idx int
+  Alt string
+  Loc string
+  Text string
+  Error string
+  Reject string
+  Template *TemplateInfo
+}
+
+// A TemplateInfo is a node of the template of a rule alternative, it matches a node
+// of the syntax tree. A template with a non-empty Lbl field is a placeholder that
+// matches any node by tracing it with that label, its Name field contains the name of
+// the placeholder (see Trace.Sub). Otherwise the Cat field contains the category that
+// the node must have, the empty category standing for the absent node (<empty). If
+// the Lit field is not empty the node must have that literal, unless the Lits field
+// is not nil: then the node must have one of the literals of the shorthand operator
+// in the Lit field (see Spec.ShorthandOperator). The Captured field is true iff the
+// node is captured in Trace.Cats. The Left, Right and Mid fields contain the
+// templates of the children of the node, see Syntax.
+type TemplateInfo struct {
+  Lbl string
+  Name string
+  Cat string
+  Lit string
+  Lits []string
+  Captured bool
+  Left *TemplateInfo
+  Right *TemplateInfo
+  Mid []*TemplateInfo
+}
+
+func (this *lang) Categories() []*CategoryInfo {
+  infos := make([]*CategoryInfo, len(this.categories))
+  for index, cat := range this.categories {
+    infos[index] = &CategoryInfo{ Cat: cat, Desc: this.descriptions[cat] }
+  }
+  return infos
+}
+
+func (this *lang) Layers() []*LayerInfo {
+  var infos []*LayerInfo
+  for _, level := range this.levels {
+    for _, layer := range level.layers {
+      infos = append(infos, &LayerInfo{ Pattern: layer.pattern, Precedence: level.precedence, Level: layer.name,
+                                        Symbols: append([]string(nil), layer.args...) })
+    }
+  }
+  return infos
+}
+
+func (this *lang) Brackets() []*BracketsInfo {
+  var infos []*BracketsInfo
+  for _, layer := range this.Layers() {
+    if layer.Pattern != "B" {
+      continue
+    }
+    for _, pair := range layer.Symbols {
+      brackets := strings.SplitN(pair, " ", 2)
+      infos = append(infos, &BracketsInfo{ Open: brackets[0], Close: brackets[1],
+                                           Application: this.precedence.precedenceAPP[pair] != 0 })
+    }
+  }
+  return infos
+}
+
+func (this *lang) Literals() []*LiteralInfo {
+  var infos []*LiteralInfo
+  for _, symbol := range this.decls.symbols {
+    if symbol.typ == spec_Literal {
+      infos = append(infos, &LiteralInfo{ Lit: symbol.lit, Cat: symbol.cat })
+    }
+  }
+  return infos
+}
+
+func (this *lang) Labels() []*LabelInfo {
+  var infos []*LabelInfo
+  declared := make(map[string]bool, len(this.labels))
+  for _, lbl := range this.labels {
+    declared[lbl] = true
+    infos = append(infos, this.Label(lbl))
+  }
+  for _, lbl := range this.ruleLabels() {
+    if !declared[lbl] {
+      infos = append(infos, this.Label(lbl))
+    }
+  }
+  return infos
+}
+
+func (this *lang) Label(lbl string) *LabelInfo {
+  kind := ""
+  for _, symbol := range this.decls.symbols {
+    if symbol.symb == lbl {
+      switch symbol.typ {
+      case spec_Label, spec_SentenceLabel, spec_SequenceLabel:
+        kind = symbol.typName()
+      }
+    }
+  }
+  templates := this.templates[lbl]
+  if kind == "" {
+    if len(templates) == 0 {
+      return nil
+    }
+    kind = "macro instance"
+    if sugar, _, _ := this.sugarOf(lbl); sugar != 0 {
+      kind = "hidden label"
+    }
+  }
+  info := &LabelInfo{ Lbl: lbl, Kind: kind, Desc: this.descriptions[lbl], Doc: this.docs[lbl],
+                      Error: this.messages[lbl], Alts: make([]*AltInfo, len(templates)) }
+  for index, template := range templates {
+    info.Alts[index] = &AltInfo{ Idx: index, Alt: template.alt, Loc: template.loc,
+                                 Text: railText(this.rail(template, nil), ""), Error: template.msg,
+                                 Reject: template.reject, Template: template.info() }
+  }
+  return info
+}
+
+// info returns the public description of this template, see TemplateInfo.
+func (this *templateT) info() *TemplateInfo {
+  if this == nil {
+    return nil
+  }
+  if this.lbl != "" {
+    return &TemplateInfo{ Lbl: this.lbl, Name: this.name }
+  }
+  info := &TemplateInfo{ Name: this.name, Left: this.left.info(), Right: this.right.info() }
+  if this.matchCat {
+    info.Cat = this.cat
+  }
+  if this.matchLit {
+    info.Lit = this.lit
+    if this.litSet != nil {
+      info.Lits = sortedKeys(this.litSet)
+    }
+  }
+  if this.left != nil {
+    info.Captured = this.matchLit && this.litSet != nil
+  } else {
+    info.Captured = !(this.matchLit && this.litSet == nil) && this.matchCat && this.cat != ""
+  }
+  if this.mid != nil {
+    info.Mid = make([]*TemplateInfo, len(this.mid))
+    for index, mid := range this.mid {
+      info.Mid[index] = mid.info()
+    }
+  }
+  return info
+}
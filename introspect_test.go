@@ -0,0 +1,105 @@
+package dusl
+
+import (
+  "testing"
+)
+
+func TestIntrospection(t *testing.T) {
+  lang, err := LoadSpec(SourceFromString(`lexical default
+category ID "identifier"
+operator BFA + -
+level additive
+mixfix ? :
+brackets ( ) [ ]
+application ( )
+operator BFA ,
+shorthand ~add~ + -
+juxtaposition LWA if
+label X "expression"
+macro List "list of {x}" x sep
+grammar
+# An expression.
+X is> [call] f:ID(args:List(X, ",")) or> X ~add~ X or> if X or> ID ? X : X or> [list] [X,*] or> ID
+List(x, sep) is> List(x, sep) sep x or> x
+`))
+
+  if err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+
+  cats := lang.Categories()
+  if len(cats) != 1 || *cats[0] != (CategoryInfo{ Cat: "ID", Desc: "identifier" }) {
+    t.Logf("categories: %v", cats)
+    t.Fail()
+  }
+
+  layers := lang.Layers()
+  if len(layers) != 6 || layers[0].Pattern != "BFA" || layers[0].Level != "additive" ||
+      layers[0].Precedence <= layers[1].Precedence || layers[1].Symbols[0] != "? :" {
+    t.Log("unexpected layers")
+    t.Fail()
+  }
+
+  brackets := lang.Brackets()
+  if len(brackets) != 2 || *brackets[0] != (BracketsInfo{ Open: "(", Close: ")", Application: true }) ||
+      *brackets[1] != (BracketsInfo{ Open: "[", Close: "]" }) {
+    t.Log("unexpected brackets")
+    t.Fail()
+  }
+
+  lits := lang.Literals()
+  if len(lits) != 1 || *lits[0] != (LiteralInfo{ Lit: "if", Cat: "ID" }) {
+    t.Log("unexpected literals")
+    t.Fail()
+  }
+
+  lbls := lang.Labels()
+  if len(lbls) != 2 || lbls[0].Lbl != "X" || lbls[0].Kind != "label" || lbls[0].Doc != "An expression." ||
+      lbls[1].Lbl != `List(X, ",")` || lbls[1].Kind != "macro instance" || lbls[1].Desc != `list of expression` {
+    for _, lbl := range lbls {
+      t.Logf("label: %v", lbl)
+    }
+    t.Fail()
+    return
+  }
+
+  alts := lbls[0].Alts
+  texts := []string{ `ID ( List(X, ",") )`, "X (+ | -) X", "if X", "ID ? X : X", "[ X,* ]", "ID" }
+  for index, alt := range alts {
+    if alt.Idx != index || alt.Text != texts[index] || alt.Loc == "" {
+      t.Logf("alternative %d: %v", index, alt)
+      t.Fail()
+    }
+  }
+
+  call := alts[0].Template
+  if alts[0].Alt != "call" || call.Cat != "APP" || call.Lit != "( )" ||
+      call.Left.Cat != "ID" || call.Left.Name != "f" || !call.Left.Captured ||
+      call.Right.Lbl != `List(X, ",")` || call.Right.Name != "args" {
+    t.Log("unexpected call template")
+    t.Fail()
+  }
+
+  add := alts[1].Template
+  if add.Cat != "OP" || add.Lit != "~add~" || len(add.Lits) != 2 || add.Lits[0] != "+" || !add.Captured || add.Left.Lbl != "X" {
+    t.Log("unexpected shorthand template")
+    t.Fail()
+  }
+
+  if mix := alts[3].Template; len(mix.Mid) != 1 || mix.Mid[0].Lbl != "X" || mix.Captured {
+    t.Log("unexpected mixfix template")
+    t.Fail()
+  }
+
+  if hidden := lang.Label(alts[4].Template.Left.Lbl); hidden == nil || hidden.Kind != "hidden label" {
+    t.Log("unexpected hidden label")
+    t.Fail()
+  }
+
+  if lang.Label("Y") != nil || lang.Label("ID") != nil {
+    t.Log("unexpected label info for non-label")
+    t.Fail()
+  }
+}
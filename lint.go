@@ -0,0 +1,204 @@
+package dusl
+
+import (
+  "errors"
+  "fmt"
+)
+
+// Lint analyzes the grammar of this Lang, see the Lang interface.
+func (this *lang) Lint(roots ...string) []error {
+  var errs []error
+
+  for _, lbl := range this.labels {
+    templates := this.templates[lbl]
+    for j, later := range templates {
+      for i, earlier := range templates[:j] {
+        if earlier.subsumes(later) {
+          errs = append(errs, templateError(later, fmt.Sprintf("alternative %d of '%s' is subsumed by alternative %d at: %s, only a backtracking tracer applies it",
+                                                               j, lbl, i, earlier.loc)))
+          break
+        }
+      }
+    }
+  }
+
+  declared := make(map[string]bool, len(this.labels))
+  for _, lbl := range this.labels {
+    declared[lbl] = true
+    if len(this.templates[lbl]) == 0 {
+      errs = append(errs, fmt.Errorf("label without rules: '%s'", lbl))
+    }
+  }
+
+  // the hidden labels of list and optional placeholders take part in the analysis
+  // of references, but are not reported themselves:
+  all := sortedKeys(this.templates)
+
+  refs := make(map[string]map[string]bool, len(all))
+  for _, lbl := range all {
+    refs[lbl] = make(map[string]bool)
+    for _, template := range this.templates[lbl] {
+      template.collectLabels(refs[lbl])
+    }
+  }
+
+  if len(roots) == 0 {
+    referenced := make(map[string]bool, len(this.labels))
+    for lbl, lblRefs := range refs {
+      for ref, _ := range lblRefs {
+        if ref != lbl {
+          referenced[ref] = true
+        }
+      }
+    }
+    for _, lbl := range this.labels {
+      if !referenced[lbl] {
+        roots = append(roots, lbl)
+      }
+    }
+  }
+  reachable := make(map[string]bool, len(this.labels))
+  pending := make([]string, 0, len(this.labels))
+  for _, root := range roots {
+    if !declared[root] {
+      errs = append(errs, fmt.Errorf("undeclared root label: '%s'", root))
+      continue
+    }
+    reachable[root] = true
+    pending = append(pending, root)
+  }
+  for len(pending) > 0 {
+    lbl := pending[len(pending)-1]
+    pending = pending[:len(pending)-1]
+    for ref, _ := range refs[lbl] {
+      if !reachable[ref] {
+        reachable[ref] = true
+        pending = append(pending, ref)
+      }
+    }
+  }
+  for _, lbl := range this.labels {
+    if !reachable[lbl] {
+      errs = append(errs, this.labelError(lbl, fmt.Sprintf("unreachable label: '%s'", lbl)))
+    }
+  }
+
+  // a label is productive if it has an alternative that only refers to productive
+  // labels, iterate until the fixpoint is reached:
+  productive := make(map[string]bool, len(all))
+  for changed := true; changed; {
+    changed = false
+    for _, lbl := range all {
+      if productive[lbl] {
+        continue
+      }
+      for _, template := range this.templates[lbl] {
+        if template.productive(productive) {
+          productive[lbl] = true
+          changed = true
+          break
+        }
+      }
+    }
+  }
+  for _, lbl := range this.labels {
+    if !productive[lbl] && len(this.templates[lbl]) > 0 {
+      errs = append(errs, this.labelError(lbl, fmt.Sprintf("unproductive label, none of its rules can be traced to completion: '%s'", lbl)))
+    }
+  }
+
+  if produced, ok := reportCategories(this.scanner); ok {
+    for _, cat := range this.categories {
+      if !produced[cat] {
+        errs = append(errs, fmt.Errorf("category never produced by the scanner: '%s'", cat))
+      }
+    }
+  }
+
+  return errs
+}
+
+// labelError returns an error located at the first rule of the given label, or an
+// error without location if the label has no rules.
+func (this *lang) labelError(lbl string, msg string) error {
+  if templates := this.templates[lbl]; len(templates) > 0 {
+    return templateError(templates[0], msg)
+  }
+  return errors.New(msg)
+}
+
+// subsumes reports whether this template matches every node that the given template
+// matches, in which case a tracer that commits to the first matching alternative
+// never applies the given template after this one.
+func (this *templateT) subsumes(that *templateT) bool {
+  if this == nil || that == nil {
+    return this == nil && that == nil
+  }
+  if this.matchCat && !(that.matchCat && that.cat == this.cat) {
+    return false
+  }
+  if this.matchLit {
+    if !that.matchLit {
+      return false
+    }
+    if that.litSet != nil {
+      for lit, _ := range that.litSet {
+        if !this.matchesLit(lit) {
+          return false
+        }
+      }
+    } else if !this.matchesLit(that.lit) {
+      return false
+    }
+  }
+  if this.left == nil {
+    return true
+  }
+  if that.left == nil || len(this.mid) != len(that.mid) {
+    return false
+  }
+  for index, mid := range this.mid {
+    if !mid.subsumes(that.mid[index]) {
+      return false
+    }
+  }
+  return this.left.subsumes(that.left) && this.right.subsumes(that.right)
+}
+
+func (this *templateT) matchesLit(lit string) bool {
+  if this.litSet != nil {
+    return this.litSet[lit]
+  }
+  return this.lit == lit
+}
+
+// collectLabels adds the labels this template refers to to the given set.
+func (this *templateT) collectLabels(lbls map[string]bool) {
+  if this == nil {
+    return
+  }
+  if this.lbl != "" {
+    lbls[this.lbl] = true
+  }
+  this.left.collectLabels(lbls)
+  for _, mid := range this.mid {
+    mid.collectLabels(lbls)
+  }
+  this.right.collectLabels(lbls)
+}
+
+// productive reports whether all the labels this template refers to are productive.
+func (this *templateT) productive(productive map[string]bool) bool {
+  if this == nil {
+    return true
+  }
+  if this.lbl != "" {
+    return productive[this.lbl]
+  }
+  for _, mid := range this.mid {
+    if !mid.productive(productive) {
+      return false
+    }
+  }
+  return this.left.productive(productive) && this.right.productive(productive)
+}
@@ -0,0 +1,77 @@
+package dusl
+
+import (
+  "testing"
+)
+
+func TestLint(t *testing.T) {
+  lang, err := LoadSpec(SourceFromString(`lexical default
+category ID "identifier"
+category NUM "number"
+category FLOAT "floating point number"
+operator BFA + -
+shorthand ~op~ + -
+label X "expression"
+label Y "other expression"
+label Z "cyclic expression"
+label W "unruled expression"
+grammar
+X is> ID or> X ~op~ X or> X + X or> NUM or> ID
+Y is> Y + ID or> W
+Z is> Z + Z
+`))
+
+  if err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+
+  errs := lang.Lint("X", "Y")
+  tgts := []string{
+    "str:12:26:31: alternative 2 of 'X' is subsumed by alternative 1 at: str:12:13:21, only a backtracking tracer applies it",
+    "str:12:44:46: alternative 4 of 'X' is subsumed by alternative 0 at: str:12:6:8, only a backtracking tracer applies it",
+    "label without rules: 'W'",
+    "str:14:6:11: unreachable label: 'Z'",
+    "str:13:6:12: unproductive label, none of its rules can be traced to completion: 'Y'",
+    "str:14:6:11: unproductive label, none of its rules can be traced to completion: 'Z'",
+    "category never produced by the scanner: 'FLOAT'",
+  }
+  if len(errs) != len(tgts) {
+    t.Log(errs)
+    t.Fail()
+    return
+  }
+  for index, err := range errs {
+    if err.Error() != tgts[index] {
+      t.Log(err)
+      t.Fail()
+    }
+  }
+  if located, ok := errs[3].(LocatedError); !ok || located.Message() != "unreachable label: 'Z'" ||
+      located.Ambit().ToString() != "Z + Z" {
+    t.Log(errs[3])
+    t.Fail()
+  }
+
+  errs = lang.Lint()
+  tgts = []string{
+    "str:12:26:31: alternative 2 of 'X' is subsumed by alternative 1 at: str:12:13:21, only a backtracking tracer applies it",
+    "str:12:44:46: alternative 4 of 'X' is subsumed by alternative 0 at: str:12:6:8, only a backtracking tracer applies it",
+    "label without rules: 'W'",
+    "str:13:6:12: unproductive label, none of its rules can be traced to completion: 'Y'",
+    "str:14:6:11: unproductive label, none of its rules can be traced to completion: 'Z'",
+    "category never produced by the scanner: 'FLOAT'",
+  }
+  if len(errs) != len(tgts) {
+    t.Log(errs)
+    t.Fail()
+    return
+  }
+  for index, err := range errs {
+    if err.Error() != tgts[index] {
+      t.Log(err)
+      t.Fail()
+    }
+  }
+}
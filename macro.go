@@ -0,0 +1,463 @@
+package dusl
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+)
+
+// maxMacroInstances bounds the number of distinct instances of macro labels in a
+// grammar, such that macros that instantiate ever larger instances of themselves
+// are reported rather than expanded forever.
+const maxMacroInstances = 1000
+
+// macroRuleT is the source range of a rule of a macro label, from the start of its
+// head line up to the start of the line of the next rule.
+type macroRuleT struct {
+  start int
+  end int
+}
+
+// macroArgT is an argument of a macro instance.
+type macroArgT struct {
+  canon string // the argument as it appears in the label of the instance
+  text string // the grammar text the parameter is replaced by
+  desc string // the description of the argument, used in generated descriptions
+  instance string // the label of the instance, if the argument is a nested instance
+}
+
+// macroInstanceT is an instance of a macro label, such as: List(expr, ",").
+type macroInstanceT struct {
+  lbl string
+  macro *specSymbol
+  args map[string]*macroArgT
+  ambit *Ambit // the first instantiation
+}
+
+// macroExpanderT expands the instances of macro labels in a grammar source into
+// ordinary labels. The rules of a macro are cut out of the grammar source and, for
+// every distinct instance, a copy of them is made in which the parameters are
+// replaced by the arguments. Instantiations are blanked out like the other
+// annotations of grammar sources and recorded by the byte offset of their macro name,
+// such that the template parser can substitute the label of the instance (see tpT).
+type macroExpanderT struct {
+  src *Source
+  symbolTable map[string]*specSymbol
+  inherited map[string][]*templateT
+  inheritedMacros map[string]bool
+  inWS func(int) bool
+  rules map[string][]*macroRuleT
+  instances map[string]*macroInstanceT
+  pending []*macroInstanceT
+  descs map[string]string
+  errs []error
+}
+
+func newMacroExpander(src *Source, symbolTable map[string]*specSymbol, inherited map[string][]*templateT,
+                       inheritedMacros map[string]bool, inWS func(int) bool) *macroExpanderT {
+  return &macroExpanderT{ src: src, symbolTable: symbolTable, inherited: inherited, inheritedMacros: inheritedMacros, inWS: inWS,
+                          rules: make(map[string][]*macroRuleT), instances: make(map[string]*macroInstanceT),
+                          descs: make(map[string]string) }
+}
+
+// main returns the grammar source without the macro rules and with all instantiations
+// blanked out, together with the labels of the instances by byte offset.
+func (this *macroExpanderT) main() (*Source, map[int]string) {
+  text := append([]byte(nil), this.src.Text...)
+  for _, macro := range this.findRules() {
+    for _, rule := range this.rules[macro] {
+      for index := rule.start; index < rule.end; index++ {
+        if text[index] != '\n' {
+          text[index] = ' '
+        }
+      }
+    }
+  }
+  positions := make(map[int]string)
+  out := newExpansion(this.src, len(text))
+  this.expand(text, 0, len(text), out, nil, positions)
+  return out.source(), positions
+}
+
+// next returns the source of the rules of the next pending instance, together with
+// the labels of the instances by byte offset, or nil if there are no pending
+// instances. Lines are preserved and the source maps its offsets back to the grammar
+// source, such that errors are located at the parameters in the macro rules.
+func (this *macroExpanderT) next() (*macroInstanceT, *Source, map[int]string) {
+  for len(this.pending) > 0 {
+    instance := this.pending[0]
+    this.pending = this.pending[1:]
+    rules := this.rules[instance.macro.symb]
+    if len(rules) == 0 {
+      if len(this.inherited[instance.lbl]) > 0 {
+        continue
+      }
+      if this.inheritedMacros[instance.macro.symb] {
+        this.errs = append(this.errs, AmbitError(instance.ambit, fmt.Sprintf("inherited macro without rules, repeat its rules to instantiate it with new arguments: '%s'", instance.macro.symb)))
+      } else {
+        this.errs = append(this.errs, AmbitError(instance.ambit, fmt.Sprintf("macro without rules: '%s'", instance.macro.symb)))
+      }
+      continue
+    }
+    text := this.src.Text
+    out := newExpansion(this.src, len(text))
+    positions := make(map[int]string)
+    offset := 0
+    for _, rule := range rules {
+      for ; offset < rule.start; offset++ {
+        if text[offset] == '\n' {
+          out.copy(text, offset, offset+1)
+        }
+      }
+      this.expand(text, rule.start, rule.end, out, instance.args, positions)
+      offset = rule.end
+    }
+    return instance, out.source(), positions
+  }
+  return nil, nil, nil
+}
+
+// findRules records the rules of the macro labels, and returns the names of the
+// macros that have rules in order of their first rule. The head of a macro rule must
+// list the declared parameters, for example: "List(x, sep) is> ...". A rule extends up
+// to the next line that is indented no further than its head, except for lines that
+// start with or> (continuations of multi-sentence rules).
+func (this *macroExpanderT) findRules() []string {
+  text := this.src.Text
+  var macros []string
+  var current *macroRuleT
+  indent := 0
+  for lineStart := 0; lineStart < len(text); {
+    lineEnd := len(text)
+    if index := strings.IndexByte(string(text[lineStart:]), '\n'); index >= 0 {
+      lineEnd = lineStart+index+1
+    }
+    pos := lineStart
+    for pos < lineEnd && (text[pos] == ' ' || text[pos] == '\t') {
+      pos++
+    }
+    blank := pos == lineEnd || text[pos] == '\n' || text[pos] == '\r' || this.inWS(pos)
+    if current != nil && !blank && pos-lineStart <= indent && !strings.HasPrefix(string(text[pos:lineEnd]), "or>") {
+      current.end = lineStart
+      current = nil
+    }
+    if current == nil && !blank {
+      if macro := this.ruleHead(text, pos); macro != "" {
+        if len(this.rules[macro]) == 0 {
+          macros = append(macros, macro)
+        }
+        current = &macroRuleT{ start: lineStart, end: len(text) }
+        this.rules[macro] = append(this.rules[macro], current)
+        indent = pos-lineStart
+      }
+    }
+    lineStart = lineEnd
+  }
+  return macros
+}
+
+// ruleHead returns the name of the macro if the given offset starts the head of a
+// macro rule.
+func (this *macroExpanderT) ruleHead(text []byte, pos int) string {
+  end := identifierEnd(text, pos)
+  symbol := this.symbolTable[string(text[pos:end])]
+  if end == pos || symbol == nil || symbol.typ != spec_Macro {
+    return ""
+  }
+  argsEnd, args := macroArgs(text, end)
+  if args == nil {
+    return ""
+  }
+  rest := strings.TrimLeft(string(text[argsEnd:]), " \t")
+  if !strings.HasPrefix(rest, "is>") {
+    return ""
+  }
+  params := make([]string, len(args))
+  for index, arg := range args {
+    params[index] = strings.TrimSpace(string(text[arg[0]:arg[1]]))
+  }
+  if strings.Join(params, ", ") != strings.Join(symbol.params, ", ") {
+    this.errs = append(this.errs, AmbitError(&Ambit{ Source: this.src, Start: pos, End: argsEnd },
+                                             fmt.Sprintf("expected the declared parameters in the head of the macro rule: '%s(%s)'",
+                                                         symbol.symb, strings.Join(symbol.params, ", "))))
+  }
+  return symbol.symb
+}
+
+// expand appends the given range of the given text to out, replacing parameters by
+// their arguments and instantiations by their macro name padded with blanks. The
+// labels of the instances are recorded in positions.
+func (this *macroExpanderT) expand(text []byte, start int, end int, out *expansionT, args map[string]*macroArgT, positions map[int]string) {
+  for pos := start; pos < end; {
+    identEnd := identifierEnd(text, pos)
+    if identEnd == pos || (pos > 0 && isIdentifierChar(text[pos-1])) || this.inWS(pos) {
+      out.copy(text, pos, pos+1)
+      pos++
+      continue
+    }
+    ident := string(text[pos:identEnd])
+    if symbol := this.symbolTable[ident]; symbol != nil && symbol.typ == spec_Macro {
+      if instEnd, lbl := this.instantiate(text, pos, args); lbl != "" {
+        positions[len(out.text)] = lbl
+        out.copy(text, pos, identEnd)
+        for index := identEnd; index < instEnd; index++ {
+          if text[index] == '\n' {
+            out.copy(text, index, index+1)
+          } else {
+            out.put(" ", index, index+1)
+          }
+        }
+        pos = instEnd
+        continue
+      }
+    } else if arg := args[ident]; arg != nil {
+      if arg.instance != "" {
+        positions[len(out.text)] = arg.instance
+      }
+      out.put(arg.text, pos, identEnd)
+      pos = identEnd
+      continue
+    }
+    out.copy(text, pos, identEnd)
+    pos = identEnd
+  }
+}
+
+// instantiate registers the instance of the macro instantiation at the given offset
+// and returns the end of the instantiation and the label of the instance. It returns
+// the empty label if there is no argument list or if the instantiation is erroneous,
+// the latter is reported.
+func (this *macroExpanderT) instantiate(text []byte, pos int, params map[string]*macroArgT) (int, string) {
+  identEnd := identifierEnd(text, pos)
+  symbol := this.symbolTable[string(text[pos:identEnd])]
+  end, ranges := macroArgs(text, identEnd)
+  if ranges == nil {
+    return pos, ""
+  }
+  ambit := &Ambit{ Source: this.src, Start: pos, End: end }
+  if len(ranges) != len(symbol.params) {
+    this.errs = append(this.errs, AmbitError(ambit, fmt.Sprintf("expected %d argument(s) for macro: '%s'", len(symbol.params), symbol.symb)))
+    return pos, ""
+  }
+  args := make(map[string]*macroArgT, len(ranges))
+  canons := make([]string, len(ranges))
+  for index, rng := range ranges {
+    arg := this.arg(text, rng[0], rng[1], params)
+    if arg == nil {
+      return pos, ""
+    }
+    args[symbol.params[index]] = arg
+    canons[index] = arg.canon
+  }
+  lbl := symbol.symb + "(" + strings.Join(canons, ", ") + ")"
+  if this.instances[lbl] == nil {
+    if len(this.instances) == maxMacroInstances {
+      this.errs = append(this.errs, AmbitError(ambit, fmt.Sprintf("too many macro instances, the expansion of the macro does not terminate: '%s'", symbol.symb)))
+      return pos, ""
+    }
+    instance := &macroInstanceT{ lbl: lbl, macro: symbol, args: args, ambit: ambit }
+    this.instances[lbl] = instance
+    this.pending = append(this.pending, instance)
+    this.descs[lbl] = macroDescription(symbol, args)
+  }
+  return end, lbl
+}
+
+// arg resolves the macro argument in the given range: a parameter of the enclosing
+// macro rule, a quoted string (the grammar text it stands for), a declared symbol
+// or a nested instantiation.
+func (this *macroExpanderT) arg(text []byte, start int, end int, params map[string]*macroArgT) *macroArgT {
+  for start < end && isBlank(text[start]) {
+    start++
+  }
+  for end > start && isBlank(text[end-1]) {
+    end--
+  }
+  ambit := &Ambit{ Source: this.src, Start: start, End: end }
+  lit := string(text[start:end])
+  if strings.HasPrefix(lit, "\"") || strings.HasPrefix(lit, "`") {
+    unquoted, err := strconv.Unquote(lit)
+    if err != nil {
+      this.errs = append(this.errs, AmbitError(ambit, fmt.Sprintf("malformed string in macro argument: %s", lit)))
+      return nil
+    }
+    quoted := strconv.Quote(unquoted)
+    return &macroArgT{ canon: quoted, text: unquoted, desc: quoted }
+  }
+  if arg := params[lit]; arg != nil {
+    return arg
+  }
+  identEnd := identifierEnd(text, start)
+  symbol := this.symbolTable[string(text[start:identEnd])]
+  if identEnd == start || symbol == nil {
+    this.errs = append(this.errs, AmbitError(ambit, fmt.Sprintf("undeclared symbol in macro argument: '%s'", lit)))
+    return nil
+  }
+  if symbol.typ == spec_Macro {
+    instEnd, lbl := this.instantiate(text, start, params)
+    if lbl == "" || instEnd != end {
+      if lbl != "" || len(this.errs) == 0 {
+        this.errs = append(this.errs, AmbitError(ambit, fmt.Sprintf("malformed macro argument: '%s'", lit)))
+      }
+      return nil
+    }
+    return &macroArgT{ canon: lbl, text: symbol.symb, desc: this.descs[lbl], instance: lbl }
+  }
+  if identEnd != end {
+    this.errs = append(this.errs, AmbitError(ambit, fmt.Sprintf("malformed macro argument: '%s'", lit)))
+    return nil
+  }
+  return &macroArgT{ canon: lit, text: lit, desc: symbol.desc }
+}
+
+// macroArgs returns the end of the parenthesized argument list that starts at the
+// given offset (possibly after blanks) and the ranges of its comma separated
+// arguments. It returns nil if there is no (complete) argument list.
+func macroArgs(text []byte, pos int) (int, [][2]int) {
+  for pos < len(text) && (text[pos] == ' ' || text[pos] == '\t') {
+    pos++
+  }
+  if pos == len(text) || text[pos] != '(' {
+    return pos, nil
+  }
+  var ranges [][2]int
+  depth, start := 0, pos+1
+  for index := pos+1; index < len(text); index++ {
+    switch c := text[index]; c {
+    case '"', '`':
+      for index++; index < len(text) && text[index] != c && text[index] != '\n'; index++ {
+        if c == '"' && text[index] == '\\' {
+          index++
+        }
+      }
+    case '(':
+      depth++
+    case ',':
+      if depth == 0 {
+        ranges = append(ranges, [2]int{ start, index })
+        start = index+1
+      }
+    case ')':
+      if depth == 0 {
+        return index+1, append(ranges, [2]int{ start, index })
+      }
+      depth--
+    case '\n':
+      return pos, nil
+    }
+  }
+  return pos, nil
+}
+
+// expansionT is the text of an expanded grammar source, together with the offsets
+// into the grammar source that every byte of the text stems from. A parameter is
+// replaced by its argument as a whole, the bytes of the argument stem from the
+// entire parameter.
+type expansionT struct {
+  src *Source
+  text []byte
+  starts []int // the start offsets into src, by offset into text
+  ends []int // the end offsets into src, by offset into text
+}
+
+func newExpansion(src *Source, capacity int) *expansionT {
+  return &expansionT{ src: src, text: make([]byte, 0, capacity), starts: make([]int, 0, capacity), ends: make([]int, 0, capacity) }
+}
+
+// copy appends the given range of the given text, which has the offsets of the
+// grammar source.
+func (this *expansionT) copy(text []byte, start int, end int) {
+  for index := start; index < end; index++ {
+    this.text = append(this.text, text[index])
+    this.starts = append(this.starts, index)
+    this.ends = append(this.ends, index+1)
+  }
+}
+
+// put appends the given text, which stands for the given range of the grammar source.
+func (this *expansionT) put(text string, start int, end int) {
+  for index := 0; index < len(text); index++ {
+    this.text = append(this.text, text[index])
+    this.starts = append(this.starts, start)
+    this.ends = append(this.ends, end)
+  }
+}
+
+func (this *expansionT) source() *Source {
+  return &Source{ Path: this.src.Path, LineOffset: this.src.LineOffset, Text: this.text, expansion: this }
+}
+
+// original returns the ambit of the grammar source that the given ambit of the
+// expanded text stems from.
+func (this *expansionT) original(ambit *Ambit) *Ambit {
+  if this == nil {
+    return ambit
+  }
+  start := len(this.src.Text)
+  if ambit.Start < len(this.starts) {
+    start = this.starts[ambit.Start]
+  } else if len(this.ends) > 0 {
+    start = this.ends[len(this.ends)-1]
+  }
+  end := start
+  if ambit.End > ambit.Start {
+    end = max(start, this.ends[ambit.End-1])
+  }
+  return &Ambit{ Source: this.src, Start: start, End: end }
+}
+
+// original returns the ambit of the grammar source that this ambit stems from if its
+// source is an expanded macro instance, otherwise it returns this ambit.
+func (this *Ambit) original() *Ambit {
+  return this.Source.expansion.original(this)
+}
+
+// macroDescription returns the description of a macro instance: the description of
+// the macro in which every "{param}" is replaced by the description of its argument.
+func macroDescription(macro *specSymbol, args map[string]*macroArgT) string {
+  desc := macro.desc
+  for _, param := range macro.params {
+    desc = strings.Replace(desc, "{" + param + "}", args[param].desc, -1)
+  }
+  return desc
+}
+
+// checkMacroParams reports macros without parameters and parameters that are not
+// identifiers, that are declared twice or that conflict with declared symbols.
+func checkMacroParams(symbol *specSymbol, symbolTable map[string]*specSymbol) error {
+  if len(symbol.params) == 0 {
+    return symbol.at.errorf("macro without parameters: '%s'", symbol.symb)
+  }
+  declared := make(map[string]bool, len(symbol.params))
+  for _, param := range symbol.params {
+    if param == "" || identifierEnd([]byte(param), 0) != len(param) {
+      return symbol.at.errorf("expected identifier as parameter of macro '%s': '%s'", symbol.symb, param)
+    }
+    if declared[param] {
+      return symbol.at.errorf("double declaration of parameter of macro '%s': '%s'", symbol.symb, param)
+    }
+    if existing := symbolTable[param]; existing != nil {
+      return symbol.at.errorf("parameter of macro '%s' conflicts with %s: '%s'", symbol.symb, existing.typName(), param)
+    }
+    declared[param] = true
+  }
+  return nil
+}
+
+func identifierEnd(text []byte, pos int) int {
+  if pos >= len(text) || !isIdentifierChar(text[pos]) || (text[pos] >= '0' && text[pos] <= '9') {
+    return pos
+  }
+  for pos < len(text) && isIdentifierChar(text[pos]) {
+    pos++
+  }
+  return pos
+}
+
+func isIdentifierChar(c byte) bool {
+  return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_'
+}
+
+func isBlank(c byte) bool {
+  return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
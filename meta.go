@@ -0,0 +1,114 @@
+package dusl
+
+import (
+  "fmt"
+  "sort"
+)
+
+// metaTokenizerT is the tokenizer of grammar sources. The annotations of the
+// meta-language, such as alternative names, are scanned as tokens of their own by
+// scanners that precede the meta scanner. An annotation that is in its place is
+// recorded and turned into whitespace, such that the sparser only sees the
+// templates, otherwise it is tokenized as ordinary grammar text. The annotations are
+// recorded by byte offset, the template parser looks them up when it meets the
+// syntax they apply to (see tpT).
+type metaTokenizerT struct {
+  annotated Tokenizer // the annotation scanners followed by the meta scanner
+  plain Tokenizer // the meta scanner
+  symbolTable map[string]*specSymbol
+  prfx *prfxTree // the operators of the dialect
+  precedence *precedenceLevels
+  alts map[int]*altNameT // by the end offset of the meta operator they follow
+  names map[int]*placeholderNameT // by the offset of the placeholder they name
+  sugars map[int]*sugarT // by the offset of the label they follow
+}
+
+func newMetaTokenizer(metaScanner Scanner, words map[string]bool, symbolTable map[string]*specSymbol,
+                      prfx *prfxTree, precedence *precedenceLevels) *metaTokenizerT {
+  annotationScanner := sequenceScanners(&altNameScanner{},
+                                       &placeholderNameScanner{ symbolTable: symbolTable },
+                                       &sugarScanner{})
+  return &metaTokenizerT{ annotated: newTokenizer(sequenceScanners(annotationScanner, metaScanner), words),
+                          plain: newTokenizer(metaScanner, words), symbolTable: symbolTable,
+                          prfx: prfx, precedence: precedence }
+}
+
+// reset clears the recorded annotations, before the next grammar source is parsed.
+func (this *metaTokenizerT) reset() {
+  this.alts = make(map[int]*altNameT)
+  this.names = make(map[int]*placeholderNameT)
+  this.sugars = make(map[int]*sugarT)
+}
+
+func (this *metaTokenizerT) Tokenize(ambit *Ambit) []*Token {
+  tokens := this.annotated.Tokenize(ambit)
+  out := make([]*Token, 0, len(tokens))
+  var prev *Token // the previous token that is not whitespace
+  for index, token := range tokens {
+    var next *Token
+    if index+1 < len(tokens) {
+      next = tokens[index+1]
+    }
+    annotated := false
+    switch token.Cat {
+    case meta_AltName:
+      annotated = this.altName(prev, token)
+    case meta_PlaceholderName:
+      annotated = this.placeholderName(token, next)
+    case meta_Sugar:
+      annotated = this.sugar(prev, token, next)
+    default:
+      out = append(out, token)
+      if token.Cat != "WS" {
+        prev = token
+      }
+      continue
+    }
+    if annotated {
+      out = append(out, &Token{ Cat: "WS", Lit: token.Lit, Ambit: token.Ambit })
+      if token.Cat == meta_AltName {
+        prev = token // <-- a bracketed identifier after an alternative name is part of the template
+      }
+      continue
+    }
+    for _, plain := range this.plain.Tokenize(token.Ambit) {
+      out = append(out, plain)
+      if plain.Cat != "WS" {
+        prev = plain
+      }
+    }
+  }
+  return out
+}
+
+func (this *metaTokenizerT) TokenizeUndent(src *Source) *Syntax {
+  return Undent(src).mapUnparsedAmbits(func(a *Ambit)string { return fmt.Sprintf("%v", this.Tokenize(a)) })
+}
+
+// whitespaceIndex returns a function that reports whether a given byte offset into
+// the given source lies within whitespace (including comments) according to the given
+// tokenizer.
+func whitespaceIndex(src *Source, tokenizer Tokenizer) func(int) bool {
+  var ends []int // end offsets of the whitespace tokens, in order
+  var starts []int
+  for _, token := range tokenizer.Tokenize(src.FullAmbit()) {
+    if token.Cat == "WS" {
+      starts = append(starts, token.Ambit.Start)
+      ends = append(ends, token.Ambit.End)
+    }
+  }
+  return func(pos int) bool {
+    index := sort.SearchInts(ends, pos+1)
+    return index < len(ends) && starts[index] <= pos
+  }
+}
+
+// isIdentifierRune reports whether the given rune can occur in an identifier of the
+// meta-language, the first rune must also satisfy isIdentifierStart.
+func isIdentifierRune(r rune) bool {
+  return isIdentifierStart(r) || (r >= '0' && r <= '9')
+}
+
+func isIdentifierStart(r rune) bool {
+  return (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || r == '_'
+}
@@ -0,0 +1,225 @@
+package dusl
+
+import (
+  "fmt"
+  "strings"
+)
+
+// structuralDescriptions describes the categories of the syntax nodes that are
+// constructed by the sparser, in error messages.
+var structuralDescriptions = map[string]string{
+  "SQ": "sequence of sentences",
+  "SN": "sentence",
+  "OP": "operator expression",
+  "BB": "brackets",
+  "APP": "application",
+  "JUXT": "juxtaposition",
+  "GLUE": "glued expression",
+}
+
+// mismatchT describes where a template fails to match a syntax node: the number of
+// template nodes that did match before the mismatch, the node that did not match (nil
+// if it is missing), the node of which it is a child (nil at the root), the context
+// of the node in its parent and what was expected instead.
+type mismatchT struct {
+  score int
+  node *Syntax
+  parent *Syntax
+  context string
+  expected []string
+  msg string // the custom error message of the rule alternative, if any
+}
+
+// fail turns the given trace of the given node into an error trace. The error
+// message lists what the closest alternatives of the label expected, and the error
+// is narrowed down to the node at which they fail to match. Without a partial match
+// the error covers the whole node and refers to the description of the label. Custom
+// error messages of the closest alternative or of the label take precedence.
+func (this *tracer) fail(trace *Trace, node *Syntax) {
+  lbl := trace.Lbl
+  trace.Lbl, trace.Err = "ERR", fmt.Sprintf("expected: %s", this.descriptions[lbl])
+  if msg := this.messages[lbl]; msg != "" {
+    trace.Err = msg
+  }
+  var best *mismatchT
+  for _, template := range this.templates[lbl] {
+    if template.reject != "" {
+      continue // <-- error productions are not expected
+    }
+    score := 0
+    mismatch := template.mismatch(node, nil, "", &score, this.descriptions)
+    if mismatch != nil {
+      mismatch.msg = template.msg
+    }
+    switch {
+    case mismatch == nil:
+      return // <-- defensive, the template matches
+    case best == nil || mismatch.score > best.score || (mismatch.score == best.score && mismatch.pos() > best.pos()):
+      best = mismatch
+    case mismatch.score == best.score && mismatch.node == best.node && mismatch.parent == best.parent:
+      for _, expected := range mismatch.expected {
+        if !containsString(best.expected, expected) {
+          best.expected = append(best.expected, expected)
+        }
+      }
+    }
+  }
+  if best == nil || best.score == 0 || (best.node == nil && len(best.expected) == 0) {
+    return
+  }
+  switch {
+  case best.node == nil:
+    trace.ambit = best.parent.Ambit
+    if best.parent.OpAmbit != nil {
+      trace.ambit = best.parent.OpAmbit
+    }
+  default:
+    trace.ambit = best.node.Ambit
+  }
+  switch {
+  case best.msg != "":
+    trace.Err = best.msg
+    return
+  case len(best.expected) > 0:
+    trace.Err = fmt.Sprintf("expected: %s", strings.Join(best.expected, " or "))
+  case best.parent != nil && best.parent.Cat == "SN" && best.node == best.parent.Right:
+    trace.Err = "unexpected: indented body"
+    return
+  default:
+    trace.Err = fmt.Sprintf("unexpected: %s", nodeDescription(best.node, this.descriptions))
+  }
+  if best.context != "" {
+    trace.Err += " " + best.context
+  }
+}
+
+// pos returns the position of the mismatch in the source, a mismatch further on in
+// the source is closer to a match.
+func (this *mismatchT) pos() int {
+  if this.node != nil {
+    return this.node.Ambit.Start
+  }
+  if this.parent != nil {
+    return this.parent.Ambit.End
+  }
+  return -1 // <-- defensive
+}
+
+// mismatch returns the first mismatch of this template with the given node, in the
+// order of the source, or nil if the template matches the node (see checkMatch). The
+// score counts the template nodes that match, apart from placeholders.
+func (this *templateT) mismatch(node *Syntax, parent *Syntax, context string, score *int, descriptions map[string]string) *mismatchT {
+  if node == nil ||
+       (this.matchCat && this.cat != node.Cat) ||
+       (this.matchLit &&
+         ((this.litSet != nil && !this.litSet[node.Lit]) || (this.litSet == nil && this.lit != node.Lit))) {
+    return &mismatchT{ score: *score, node: node, parent: parent, context: context, expected: this.expected(descriptions) }
+  }
+  if this.lbl == "" {
+    *score++ // <-- placeholders match any node
+  }
+  if this.left == nil {
+    return nil
+  }
+  if len(this.mid) != len(node.Mid) {
+    return &mismatchT{ score: *score, node: node, parent: parent, context: context, expected: this.expected(descriptions) }
+  }
+  if mismatch := this.left.mismatch(node.Left, node, childContext(node, -1), score, descriptions); mismatch != nil {
+    return mismatch
+  }
+  for index, mid := range this.mid {
+    if mismatch := mid.mismatch(node.Mid[index], node, childContext(node, index), score, descriptions); mismatch != nil {
+      return mismatch
+    }
+  }
+  return this.right.mismatch(node.Right, node, childContext(node, len(this.mid)), score, descriptions)
+}
+
+// expected describes what this template matches, for use in error messages. The empty
+// template (matching nothing) is described by no descriptions at all.
+func (this *templateT) expected(descriptions map[string]string) []string {
+  switch {
+  case this.lbl != "":
+    return []string{ descriptions[this.lbl] }
+  case this.matchCat && this.cat == "":
+    return nil
+  case this.matchCat && (this.cat == "JUXT" || this.cat == "GLUE"):
+    parts := append(this.left.expected(descriptions), this.right.expected(descriptions)...)
+    return []string{ strings.Join(parts, " ") }
+  case this.matchLit && this.litSet != nil:
+    lits := sortedKeys(this.litSet)
+    for index, lit := range lits {
+      lits[index] = "'" + lit + "'"
+    }
+    return lits
+  case this.matchLit && (this.cat == "BB" || this.cat == "APP"):
+    brackets := strings.SplitN(this.lit, " ", 2)
+    return []string{ fmt.Sprintf("'%s' ... '%s'", brackets[0], brackets[len(brackets)-1]) }
+  case this.matchLit:
+    return []string{ "'" + this.lit + "'" }
+  case this.matchCat:
+    return []string{ categoryDescription(this.cat, descriptions) }
+  }
+  return nil // <-- defensive
+}
+
+// childContext describes the position of the given child of the given node, such as
+// "after '+'", for use in error messages. The index of the child is -1 for the left
+// child, the index in Mid for the children in between the functors of a mixfix
+// operator, and the number of such children for the right child.
+func childContext(node *Syntax, index int) string {
+  switch node.Cat {
+  case "OP":
+    functors := strings.Split(node.Lit, " ")
+    if index < 0 {
+      return fmt.Sprintf("before '%s'", functors[0])
+    }
+    return fmt.Sprintf("after '%s'", functors[min(index, len(functors)-1)])
+  case "BB":
+    return fmt.Sprintf("in '%s'", node.Lit)
+  case "APP":
+    if index < 0 {
+      return fmt.Sprintf("before '%s'", strings.SplitN(node.Lit, " ", 2)[0])
+    }
+    return fmt.Sprintf("in '%s'", node.Lit)
+  case "JUXT", "GLUE":
+    if index >= 0 && node.Left != nil && node.Left.Left == nil && node.Left.Lit != "" {
+      return fmt.Sprintf("after '%s'", node.Left.Lit)
+    }
+  case "SN":
+    if index >= 0 {
+      return "in the body of the sentence"
+    }
+  }
+  return ""
+}
+
+// categoryDescription returns the description of the given lexical or structural
+// category.
+func categoryDescription(cat string, descriptions map[string]string) string {
+  if desc := descriptions[cat]; desc != "" {
+    return desc
+  }
+  if desc := structuralDescriptions[cat]; desc != "" {
+    return desc
+  }
+  return cat
+}
+
+// nodeDescription describes the given node in error messages: by its literal if it
+// has one, by its category otherwise.
+func nodeDescription(node *Syntax, descriptions map[string]string) string {
+  if node.Left == nil && node.Lit != "" {
+    return "'" + node.Lit + "'"
+  }
+  return categoryDescription(node.Cat, descriptions)
+}
+
+func containsString(list []string, s string) bool {
+  for _, elem := range list {
+    if elem == s {
+      return true
+    }
+  }
+  return false
+}
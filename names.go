@@ -0,0 +1,230 @@
+package dusl
+
+import (
+  "fmt"
+  "sort"
+)
+
+// meta_PlaceholderName is the category of the tokens of the placeholderNameScanner.
+const meta_PlaceholderName = "$NAME"
+
+// The placeholderNameScanner scans the name part of a named placeholder such as
+// "cond:expr" or "name:ID": an identifier followed by a colon. Identifiers that are
+// declared symbols are left alone, such that "X:X" remains an ordinary template in
+// dialects with a colon operator.
+type placeholderNameScanner struct {
+  symbolTable map[string]*specSymbol
+}
+
+func (this *placeholderNameScanner) Scan() Scan {
+  return &placeholderNameScan{ symbolTable: this.symbolTable }
+}
+
+type placeholderNameScan struct {
+  symbolTable map[string]*specSymbol
+  state int
+  name []rune
+}
+
+func (this *placeholderNameScan) Consume(r rune) (string, bool) {
+  const (
+    INIT = iota // convention requires: INIT == 0
+    NAME
+    NOMORE
+  )
+  switch this.state {
+  case INIT:
+    if isIdentifierStart(r) {
+      this.state = NAME
+      this.name = append(this.name, r)
+      return "", true
+    }
+  case NAME:
+    if isIdentifierRune(r) {
+      this.name = append(this.name, r)
+      return "", true
+    }
+    if r == ':' && this.symbolTable[string(this.name)] == nil {
+      this.state = NOMORE
+      return meta_PlaceholderName, false
+    }
+  }
+  this.state = NOMORE
+  return "", false
+}
+
+func (this *placeholderNameScan) Reset() {
+  this.state = 0
+  this.name = this.name[:0]
+}
+
+// placeholderNameT is a placeholder name of the grammar source.
+type placeholderNameT struct {
+  name string
+  ambit *Ambit
+  used bool
+}
+
+// placeholderName records the given placeholder name token if it is directly followed
+// by a declared symbol (the given next token), and reports whether it is. The name is
+// recorded by the offset of the symbol.
+func (this *metaTokenizerT) placeholderName(token *Token, next *Token) bool {
+  if next == nil || next.Ambit.Start != token.Ambit.End || this.symbolTable[next.Lit] == nil {
+    return false
+  }
+  ambit := &Ambit{ Source: token.Ambit.Source, Start: token.Ambit.Start, End: token.Ambit.End-1 }
+  this.names[next.Ambit.Start] = &placeholderNameT{ name: ambit.ToString(), ambit: ambit }
+  return true
+}
+
+// named attaches the placeholder name at the given position, if any, to the given
+// placeholder template.
+func (this *tpT) named(pos int, template *templateT) *templateT {
+  name := this.metaTokenizer.names[pos]
+  if name == nil || (template.lbl == "" && template.matchLit && template.litSet == nil) {
+    return template // <-- literals capture nothing, reported by checkPlaceholderNames
+  }
+  name.used = true
+  template.name = name.name
+  return template
+}
+
+// ruleAmbit returns the given ambit of an alternative, extended to the placeholder
+// name of its leading placeholder, if any, which is whitespace to the sparser.
+func (this *tpT) ruleAmbit(ambit *Ambit) *Ambit {
+  if name := this.metaTokenizer.names[ambit.Start]; name != nil {
+    return name.ambit.Merge(ambit)
+  }
+  return ambit
+}
+
+// indexNames indexes the names of the placeholders of the given top level template.
+func (this *tpT) indexNames(template *templateT) {
+  if dup := template.indexNames(); dup != "" {
+    this.errs = append(this.errs, templateError(template, fmt.Sprintf("double declaration of placeholder name: '%s'", dup)))
+  }
+}
+
+// indexNames computes, for this top level template, the indices into Trace.Subs and
+// Trace.Cats of its named placeholders. It returns a name that is declared more than
+// once, if any.
+func (this *templateT) indexNames() string {
+  subNames := make(map[string]int)
+  catNames := make(map[string]int)
+  _, _, dup := this.indexNamesRec(0, 0, subNames, catNames)
+  if len(subNames) > 0 {
+    this.subNames = subNames
+  }
+  if len(catNames) > 0 {
+    this.catNames = catNames
+  }
+  return dup
+}
+
+// indexNamesRec follows the traversal order of performMatch.
+func (this *templateT) indexNamesRec(subi int, cati int, subNames map[string]int, catNames map[string]int) (int, int, string) {
+  if this == nil {
+    return subi, cati, ""
+  }
+  dup := ""
+  index := func(names map[string]int, i int) {
+    if this.name == "" {
+      return
+    }
+    _, isSub := subNames[this.name]
+    _, isCat := catNames[this.name]
+    if (isSub || isCat) && dup == "" {
+      dup = this.name
+    }
+    names[this.name] = i
+  }
+  if this.lbl != "" {
+    index(subNames, subi)
+    return subi+1, cati, dup
+  }
+  if this.left != nil { // implies this.right != nil
+    if this.matchLit && this.litSet != nil {
+      index(catNames, cati)
+      cati++
+    }
+    var subDup string
+    subi, cati, subDup = this.left.indexNamesRec(subi, cati, subNames, catNames)
+    if dup == "" {
+      dup = subDup
+    }
+    for _, mid := range this.mid {
+      subi, cati, subDup = mid.indexNamesRec(subi, cati, subNames, catNames)
+      if dup == "" {
+        dup = subDup
+      }
+    }
+    subi, cati, subDup = this.right.indexNamesRec(subi, cati, subNames, catNames)
+    if dup == "" {
+      dup = subDup
+    }
+    return subi, cati, dup
+  }
+  if !(this.matchLit && this.litSet == nil) && this.matchCat && this.cat != "" {
+    index(catNames, cati)
+    cati++
+  }
+  return subi, cati, dup
+}
+
+// checkPlaceholderNames reports placeholder names that are not attached to a label,
+// category or shorthand operator placeholder.
+func checkPlaceholderNames(names map[int]*placeholderNameT) []error {
+  var errs []error
+  for _, pos := range sortedNamePositions(names) {
+    if name := names[pos]; !name.used {
+      errs = append(errs, AmbitError(name.ambit, fmt.Sprintf("placeholder name not attached to a label, category or shorthand operator: '%s'", name.name)))
+    }
+  }
+  return errs
+}
+
+func sortedNamePositions(names map[int]*placeholderNameT) []int {
+  positions := make([]int, 0, len(names))
+  for pos, _ := range names {
+    positions = append(positions, pos)
+  }
+  sort.Ints(positions)
+  return positions
+}
+
+// Sub returns the sub-trace of the placeholder with the given name in the rule
+// alternative that was applied, for example: Sub("cond") for the rule alternative
+// "if cond:expr". It panics, naming the rule alternative, if there is no such
+// placeholder.
+func (this *Trace) Sub(name string) *Trace {
+  if this.tmpl != nil {
+    if index, ok := this.tmpl.subNames[name]; ok {
+      return this.Subs[index]
+    }
+  }
+  panic(this.missingName("sub-trace", name))
+}
+
+// Cat returns the captured category node of the placeholder with the given name in
+// the rule alternative that was applied, for example: Cat("name") for the rule
+// alternative "name:ID = expr". It panics, naming the rule alternative, if there is
+// no such placeholder.
+func (this *Trace) Cat(name string) *Syntax {
+  if this.tmpl != nil {
+    if index, ok := this.tmpl.catNames[name]; ok {
+      return this.Cats[index]
+    }
+  }
+  panic(this.missingName("category", name))
+}
+
+func (this *Trace) missingName(kind string, name string) string {
+  if this.tmpl == nil {
+    return fmt.Sprintf("no %s named '%s' in failed trace: %s", kind, name, this.Err)
+  }
+  alt := ""
+  if this.Alt != "" {
+    alt = fmt.Sprintf(" [%s]", this.Alt)
+  }
+  return fmt.Sprintf("no %s named '%s' in alternative %d%s of '%s' at: %s", kind, name, this.Idx, alt, this.Lbl, this.tmpl.loc)
+}
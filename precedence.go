@@ -0,0 +1,170 @@
+package dusl
+
+import (
+  "io"
+  "fmt"
+  "strings"
+)
+
+// A precedence level groups all the layers that share one precedence.
+type precedenceLevelT struct {
+  precedence int
+  layers []*specLayer
+}
+
+// resolvePrecedenceLevels orders the given layers into precedence levels, from the
+// highest to the lowest precedence. Layers that are not explicitly positioned get
+// their own level below all previous levels, in order of declaration. Positioned
+// layers are then inserted, in order of declaration, relative to the level of their
+// reference.
+func resolvePrecedenceLevels(layers []*specLayer) ([]*precedenceLevelT, error) {
+  names := make(map[string]bool, len(layers))
+  for _, layer := range layers {
+    if layer.name == "" {
+      continue
+    }
+    if names[layer.name] {
+      return nil, layer.nameAt.errorf("double declaration of level: '%s'", layer.name)
+    }
+    names[layer.name] = true
+  }
+  levels := make([]*precedenceLevelT, 0, len(layers))
+  var pending []*specLayer
+  for _, layer := range layers {
+    if layer.rel == "" {
+      levels = append(levels, &precedenceLevelT{ layers: []*specLayer{ layer } })
+    } else {
+      pending = append(pending, layer)
+    }
+  }
+  for len(pending) > 0 {
+    var unresolved []*specLayer
+    for _, layer := range pending {
+      index, err := findPrecedenceLevel(levels, layer)
+      if err != nil {
+        return nil, err
+      }
+      if index < 0 {
+        unresolved = append(unresolved, layer)
+        continue
+      }
+      switch layer.rel {
+      case "above":
+        levels = insertPrecedenceLevel(levels, index, layer)
+      case "below":
+        levels = insertPrecedenceLevel(levels, index+1, layer)
+      default: // "same as"
+        levels[index].layers = append(levels[index].layers, layer)
+      }
+    }
+    if len(unresolved) == len(pending) {
+      layer := unresolved[0]
+      return nil, layer.relAt.errorf("undeclared level or literal: %s '%s'", layer.rel, layer.ref)
+    }
+    pending = unresolved
+  }
+  l := len(levels)-1
+  for i, level := range levels {
+    level.precedence = 10 + (l-i)
+  }
+  return levels, nil
+}
+
+// findPrecedenceLevel returns the index of the level that holds the layer named by
+// the reference of the given layer, or else the level of the referenced literal,
+// which is an operator, bracket pair, identifier, mixfix operator or any of its
+// functors.
+func findPrecedenceLevel(levels []*precedenceLevelT, positioned *specLayer) (int, error) {
+  ref := positioned.ref
+  found := -1
+  for index, level := range levels {
+    for _, layer := range level.layers {
+      if layer.name == ref {
+        return index, nil
+      }
+      for _, arg := range layer.args {
+        if arg == ref || layer.pattern == "MIX" && isFunctor(arg, ref) {
+          if found >= 0 && found != index {
+            return -1, positioned.relAt.errorf("ambiguous reference to literal declared at more than one level: '%s': use a level name instead", ref)
+          }
+          found = index
+        }
+      }
+    }
+  }
+  return found, nil
+}
+
+func isFunctor(ops string, ref string) bool {
+  for _, functor := range strings.Split(ops, " ") {
+    if functor == ref {
+      return true
+    }
+  }
+  return false
+}
+
+func insertPrecedenceLevel(levels []*precedenceLevelT, index int, layer *specLayer) []*precedenceLevelT {
+  levels = append(levels, nil)
+  copy(levels[index+1:], levels[index:])
+  levels[index] = &precedenceLevelT{ layers: []*specLayer{ layer } }
+  return levels
+}
+
+// precedenceFrom returns the precedence of the literal in the given table of the
+// binding pattern that applies where the minimal precedence is as given: the
+// lowest precedence of the literal at or above the minimum if it is shared across
+// levels, or else the precedence in the table.
+func (this *precedenceLevels) precedenceFrom(precedence map[string]int, pattern string, lit string, minPrecedence int) int {
+  prec := precedence[lit]
+  if prec < minPrecedence && prec > 0 {
+    for _, shared := range this.shared[pattern][lit] {
+      if shared >= minPrecedence {
+        return shared
+      }
+    }
+  }
+  return prec
+}
+
+// eachPrecedence calls do for every precedence of the literal in the given table
+// of the binding pattern, in ascending order.
+func (this *precedenceLevels) eachPrecedence(precedence map[string]int, pattern string, lit string, do func(prec int)) {
+  if precs := this.shared[pattern][lit]; precs != nil {
+    for _, prec := range precs {
+      do(prec)
+    }
+  } else if prec := precedence[lit]; prec > 0 {
+    do(prec)
+  }
+}
+
+var precedencePatterns = []string{ "EFE", "EFA", "AFE", "AFB", "BFA", "BFB", "MIX", "B", "APP", "LWA", "AWL", "LA", "AL" }
+
+// DumpPrecedence writes the final precedence table, from the highest to the lowest
+// precedence level, grouped per binding pattern.
+func (this *lang) DumpPrecedence(out io.Writer, prfx string) {
+  dumpPrecedenceLevels(out, prfx, this.levels)
+}
+
+func dumpPrecedenceLevels(out io.Writer, prfx string, levels []*precedenceLevelT) {
+  for _, pattern := range precedencePatterns {
+    header := false
+    for _, level := range levels {
+      for _, layer := range level.layers {
+        if layer.pattern != pattern {
+          continue
+        }
+        if !header {
+          fmt.Fprintf(out, "%s%s:\n", prfx, pattern)
+          header = true
+        }
+        name := ""
+        if layer.name != "" {
+          name = fmt.Sprintf(" (%s)", layer.name)
+        }
+        fmt.Fprintf(out, "%s  %d%s: %s\n", prfx, level.precedence, name, strings.Join(layer.args, "  "))
+      }
+    }
+  }
+}
@@ -0,0 +1,180 @@
+package dusl
+
+import (
+  "testing"
+  "bytes"
+)
+
+func TestPrecedence(t *testing.T) {
+  lang, err := NewSpec().
+    Lexical(DefaultScanner).
+    OperatorBFA("*", "/").Level("multiplicative").
+    OperatorBFA("+", "-").Level("additive").
+    OperatorBFA("==", "<").
+    OperatorBFA("<<", ">>").Above("additive").
+    OperatorEFA("-").SameAs("*").
+    OperatorAFE("!").Below("==").
+    Brackets("( )").
+    Grammar("")
+
+  if err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+
+  buf := new(bytes.Buffer)
+  lang.DumpPrecedence(buf, "> ")
+  res := buf.String()
+  tgt := `> EFA:
+>   15: -
+> AFE:
+>   11: !
+> BFA:
+>   15 (multiplicative): *  /
+>   14: <<  >>
+>   13 (additive): +  -
+>   12: ==  <
+> B:
+>   10: ( )
+`
+  if res != tgt {
+    t.Log(res)
+    t.Fail()
+  }
+
+  res = lang.Sparser().Sparse(AmbitFromString("-a << b + c == d!")).DumpToString(true)
+  tgt = `OP:!
+  OP:==
+    OP:+
+      OP:<<
+        OP:-
+          :
+          ID:a
+        ID:b
+      ID:c
+    ID:d
+  :
+`
+  if res != tgt {
+    t.Log(res)
+    t.Fail()
+  }
+
+  _, err = NewSpec().
+    OperatorEFA("-").
+    OperatorBFA("-").
+    OperatorBFA("+").SameAs("-").
+    Grammar("")
+  if err == nil || locatedMessage(err) != "ambiguous reference to literal declared at more than one level: '-': use a level name instead" {
+    t.Log(err)
+    t.Fail()
+  }
+
+  _, err = NewSpec().
+    OperatorBFA("*", "/").Level("multiplicative").
+    OperatorBFA("+", "-").
+    OperatorBFA("/").SameAs("*").
+    Grammar("")
+  if err == nil || locatedMessage(err) != "double declaration of BFA identifier/operator/bracket: '/'" {
+    t.Log(err)
+    t.Fail()
+  }
+
+  _, err = NewSpec().
+    OperatorBFA("+").Below("additive").
+    Grammar("")
+  if err == nil || locatedMessage(err) != "undeclared level or literal: below 'additive'" {
+    t.Log(err)
+    t.Fail()
+  }
+
+  lang, err = NewSpec().
+    Lexical(DefaultScanner).
+    OperatorBFA("+").
+    OperatorMixfix("? :").
+    OperatorBFA("||").SameAs("?").
+    Brackets("( )").
+    Grammar("")
+  if err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+  buf.Reset()
+  lang.DumpPrecedence(buf, "> ")
+  res = buf.String()
+  tgt = `> BFA:
+>   12: +
+>   11: ||
+> MIX:
+>   11: ? :
+> B:
+>   10: ( )
+`
+  if res != tgt {
+    t.Log(res)
+    t.Fail()
+  }
+
+  _, err = NewSpec().
+    Lexical(DefaultScanner).
+    OperatorBFA(":").
+    OperatorMixfix("? :").
+    Grammar("")
+  if err == nil || locatedMessage(err) != "MIX functor conflicts with BFA operator: ':'" {
+    t.Log(err)
+    t.Fail()
+  }
+
+  lang, err = NewSpec().
+    Lexical(DefaultScanner).
+    OperatorBFA("*").Level("multiplicative").
+    OperatorBFA("+").Level("additive").
+    OperatorBFA(",").
+    Brackets("( )").
+    Brackets("[ ]").SameAs("additive").
+    OperatorBFA(",").Above("additive").
+    Grammar("")
+  if err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+  buf.Reset()
+  lang.DumpPrecedence(buf, "> ")
+  res = buf.String()
+  tgt = `> BFA:
+>   14 (multiplicative): *
+>   13: ,
+>   12 (additive): +
+>   11: ,
+> B:
+>   12: [ ]
+>   10: ( )
+`
+  if res != tgt {
+    t.Log(res)
+    t.Fail()
+  }
+
+  res = lang.Sparser().Sparse(AmbitFromString("a, b + c, [a, b + c]")).DumpToString(true)
+  tgt = `OP:,
+  OP:,
+    ID:a
+    OP:+
+      ID:b
+      ID:c
+  BB:[ ]
+    OP:+
+      OP:,
+        ID:a
+        ID:b
+      ID:c
+    :
+`
+  if res != tgt {
+    t.Log(res)
+    t.Fail()
+  }
+}
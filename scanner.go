@@ -13,6 +13,36 @@ type Scan interface {
   Reset()
 }
 
+// A CategoryReporter is a Scanner that can report all the lexical categories it may
+// produce by adding them to the given set. Scanners that implement this interface
+// allow Lang.Lint to detect declared categories that are never produced.
+type CategoryReporter interface {
+  Report(categories map[string]bool)
+}
+
+// reportCategories returns the set of lexical categories the given scanner may
+// produce, the second return value is false if the scanner (or one of the
+// scanners it is composed of) does not report its categories.
+func reportCategories(scanner Scanner) (map[string]bool, bool) {
+  categories := make(map[string]bool)
+  return categories, reportCategoriesRec(scanner, categories)
+}
+
+func reportCategoriesRec(scanner Scanner, categories map[string]bool) bool {
+  switch scanner := scanner.(type) {
+  case nil:
+    return true
+  case *seqScanner:
+    return reportCategoriesRec(scanner.master, categories) && reportCategoriesRec(scanner.slave, categories)
+  case *compScanner:
+    return reportCategoriesRec(scanner.scannerA, categories) && reportCategoriesRec(scanner.scannerB, categories)
+  case CategoryReporter:
+    scanner.Report(categories)
+    return true
+  }
+  return false
+}
+
 type emptyScanner struct {}
 
 func (this *emptyScanner) Scan() Scan {
@@ -133,6 +163,10 @@ func (this *simpleStringScanner) Scan() Scan {
   return &simpleStringScan{}
 }
 
+func (this *simpleStringScanner) Report(categories map[string]bool) {
+  categories["STR"] = true
+}
+
 type simpleStringScan struct {
   state int  
 }
@@ -212,6 +246,10 @@ func (this *simpleDecimalNumScanner) Scan() Scan {
   return &simpleDecimalNumScan{}
 }
 
+func (this *simpleDecimalNumScanner) Report(categories map[string]bool) {
+  categories["NUM"] = true
+}
+
 type simpleDecimalNumScan struct {
   state int
 }
@@ -260,6 +298,10 @@ func (this *simpleIdentifierScanner) Scan() Scan {
   return &simpleIdentifierScan{}
 }
 
+func (this *simpleIdentifierScanner) Report(categories map[string]bool) {
+  categories["ID"] = true
+}
+
 type simpleIdentifierScan struct {
   state int
 }  
@@ -305,6 +347,10 @@ func (this *simpleBaseScanner) Scan() Scan {
   return &simpleBaseScan{}
 }
 
+func (this *simpleBaseScanner) Report(categories map[string]bool) {
+  categories["WS"] = true
+}
+
 type simpleBaseScan struct {
   state int
 }  
@@ -380,6 +426,16 @@ func PrefixScanner(descs ...string) Scanner {
 // As such it reports the lexical categories: "WS", "STR", "ID", and "NUM".
 var DefaultScanner Scanner
 
+var scannerRegistry = make(map[string]Scanner)
+
+// RegisterScanner makes the given scanner available under the given name to the
+// lexical declarations of spec files (see LoadSpec) and to encoded Langs (see
+// DecodeLang). The scanners of this package
+// are registered as: "default", "base", "string", "identifier" and "decimal".
+func RegisterScanner(name string, scanner Scanner) {
+  scannerRegistry[name] = scanner
+}
+
 func init() {
   SimpleStringScanner = &simpleStringScanner{} 
   SimpleDecimalNumScanner = &simpleDecimalNumScanner{}
@@ -389,6 +445,11 @@ func init() {
                                    SimpleStringScanner, 
                                    SimpleIdentifierScanner,
                                    SimpleDecimalNumScanner)
+  RegisterScanner("default", DefaultScanner)
+  RegisterScanner("base", SimpleBaseScanner)
+  RegisterScanner("string", SimpleStringScanner)
+  RegisterScanner("identifier", SimpleIdentifierScanner)
+  RegisterScanner("decimal", SimpleDecimalNumScanner)
 }
 
 type metaSymbolScannerT struct {}
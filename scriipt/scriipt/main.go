@@ -17,7 +17,7 @@ func main() {
 
 func doIt(args []string) error {
   if len(args) < 2 || len(args) > 2 {
-    return fmt.Errorf("usage: scriipt <verb> <filepath>\nverbs: undent, undent-raw, tokenize, tokenize-raw, sparse, sparse-raw, trace, trace-raw, parse, parse-raw, run")
+    return fmt.Errorf("usage: scriipt <verb> <filepath>\nverbs: undent, undent-raw, tokenize, tokenize-raw, sparse, sparse-raw, explain, trace, trace-raw, parse, parse-raw, run")
   }
   verb, path := args[0], args[1]
   text, err := ioutil.ReadFile(path)
@@ -32,6 +32,8 @@ func doIt(args []string) error {
     tokenize(src, true)
   case "sparse", "sparse-pretty":
     sparse(src, true)
+  case "explain":
+    explain(dusl.Undent(src))
   case "trace", "trace-pretty":
     trace(src, true)
   case "undent-raw":
@@ -79,6 +81,17 @@ func sparse(src *dusl.Source, pretty bool) {
   syn.Dump(os.Stdout, "", pretty)
 }
 
+func explain(node *dusl.Syntax) {
+  if node.Cat == "SQ" {
+    explain(node.Left)
+    explain(node.Right)
+  } else if node.Cat == "SN" {
+    _, explanation := scriipt.Lang.Sparser().Explain(node.Left.Ambit)
+    explanation.Dump(os.Stdout, "")
+    explain(node.Right)
+  }
+}
+
 func trace(src *dusl.Source, pretty bool) {
   trace := scriipt.Lang.Tracer().TraceUndent(src, "Stmt")
   trace.Dump(os.Stdout, "", pretty)
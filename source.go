@@ -12,6 +12,7 @@ type Source struct {
   Path string
   LineOffset int
   Text []byte
+  expansion *expansionT // the origin of the text of an expanded macro instance, see macroExpanderT
 }
 
 // SourceFromString creates a source object from a given string. Useful for unit testing.
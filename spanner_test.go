@@ -13,7 +13,7 @@ func TestSpanner(t *testing.T) {
     &seqScanner{master: PrefixScanner("OP += ===", "OB <% ( [ {", "CB } ] ) %>"),
                 slave: DefaultScanner}
 
-  spanner := newSpanner(newTokenizer(scanner), map[string]int{ "<% %>":1, "( )":1, "[ }":1 })
+  spanner := newSpanner(newTokenizer(scanner, nil), map[string]int{ "<% %>":1, "( )":1, "[ }":1 })
   spans := spanner.span(ambit)
   if len(spans) != 3 {
     t.Log("len(spans) ==", len(spans))
@@ -2,19 +2,25 @@ package dusl
 
 import (
   "fmt"
+  "sort"
+  "strings"
 )
 
 // Sparser stands for Superpermissive-Parser.
 // The Sparse method converts an ambit into a syntax tree,
 // the SparseUndent method converts an entire source into a syntax tree.
+// The Explain method converts an ambit into a syntax tree just like Sparse does,
+// and additionally explains every decision taken along the way.
 type Sparser interface {
   Sparse(ambit *Ambit) *Syntax
   SparseUndent(src *Source) *Syntax
+  Explain(ambit *Ambit) (*Syntax, *Explanation)
 }
 
 type sparser struct {
   precedenceLevels
   spanner spannerI
+  explanation *Explanation
 }
 
 func newSparser(spanner spannerI, precedence *precedenceLevels) Sparser {
@@ -44,12 +50,21 @@ func (this *sparser) sparseSQ(node *Syntax) {
 
 // Sparse returns the syntax tree constructed for the given ambit.
 func (this *sparser) Sparse(ambit *Ambit) *Syntax {
-  return this.sparse(ambit, this.spanner.span(ambit), 1)
+  spans := this.spanner.span(ambit)
+  return this.sparse(ambit, newSpanList(spans), 0, len(spans), 1)
 }
 
-func (this *sparser) sparse(ambit *Ambit, spans []*spanT, minPrecedence int) *Syntax {
-  ambit, spans = trimSpans(ambit, spans)
+func (this *sparser) sparse(ambit *Ambit, list *spanListT, lo int, hi int, minPrecedence int) *Syntax {
+  ambit, lo, hi = trimSpans(ambit, list.spans, lo, hi)
+  if this.explanation != nil {
+    parent := this.explanation
+    this.explanation = &Explanation{ Ambit: ambit, MinPrecedence: minPrecedence }
+    parent.Subs = append(parent.Subs, this.explanation)
+    defer func() { this.explanation = parent }()
+  }
+  spans := list.spans[lo:hi]
   if len(spans) == 0 {
+    this.explain("empty")
     return &Syntax{ Ambit: ambit }
   }
   if len(spans) == 1 {
@@ -57,50 +72,92 @@ func (this *sparser) sparse(ambit *Ambit, spans []*spanT, minPrecedence int) *Sy
     lit := span.Lit
     if span.Children == nil {
       if span.Cat == "OP" {
-        if this.precedenceEFE[lit] < minPrecedence {
+        precedence := this.precedenceFrom(this.precedenceEFE, "EFE", lit, minPrecedence)
+        if precedence < minPrecedence {
+          if this.precedenceMIX[lit] > 0 {
+            this.explain("operator without operands: '%s' is the functor of an incomplete mixfix operator", lit)
+            return &Syntax{ Cat: "ERR", Err: fmt.Sprintf("unexpected: %s: incomplete mixfix operator", lit), Ambit: ambit }
+          }
+          this.explain("operator without operands: '%s' is no EFE operator at precedence %d or above", lit, minPrecedence)
           return &Syntax{ Cat: "ERR", Err: fmt.Sprintf("unexpected: %s", lit), Ambit: ambit }
         }
+        this.explain("EFE '%s' at precedence %d", lit, precedence)
         return &Syntax{ Cat: "OP", Lit: lit, Ambit: ambit, OpAmbit: span.Ambit,
                         Left: &Syntax{ Ambit: span.Ambit.CollapseLeft() },
                         Right: &Syntax{ Ambit: span.Ambit.CollapseRight() } }
       }
+      this.explain("single %s '%s'", span.Cat, lit)
       return &Syntax{ Cat: span.Cat, Lit: lit, Err: span.Err, Ambit: ambit, OpAmbit: span.Ambit }
     }
     // span.Cat == "BB"
     precedence, recognized := this.precedenceB[lit]
     if !recognized {
+      this.explain("undeclared brackets: '%s'", lit)
       return &Syntax{ Cat: "ERR", Err: fmt.Sprintf("unexpected: %s", lit), Ambit: ambit }
     }
+    this.explain("brackets '%s' at precedence %d", lit, precedence)
     return &Syntax{ Cat: span.Cat, Lit: lit, Ambit: span.Ambit,
-                    Left: this.sparse(span.SubAmbit, span.Children, precedence),
+                    Left: this.sparse(span.SubAmbit, newSpanList(span.Children), 0, len(span.Children), precedence),
                     Right: &Syntax{ Ambit: span.Ambit.CollapseRight() } }
   }
-  l, splitPrecedence, splitLoc, splitPrecLeft, splitPrecRight := len(spans)-1, maxPrecedence+1, -1, -1, -1
+  var reasons *splitReasonsT
+  if this.explanation != nil {
+    reasons = &splitReasonsT{ mixfix: this.mixfix }
+  }
+  split := this.findSplit(list, lo, hi, minPrecedence, reasons)
+  if reasons != nil {
+    this.explainSplit(list.spans[lo:hi], minPrecedence, split, reasons)
+  }
+  return this.splitSyntax(ambit, list, lo, hi, split)
+}
+
+// A splitT describes where the sparser splits a range of spans: the location of
+// the span to split on (relative to the range), the binding pattern by which the
+// split was found, its precedence and the minimal precedences of the operands.
+// If no split is found the pattern is JUXT or GLUE, splitting off the first span.
+type splitT struct {
+  loc int
+  pattern string
+  prec int
+  precLeft int
+  precRight int
+  candidate *infixCandidateT
+}
+
+// findSplit returns the split for a range of at least two spans. Unless reasons is
+// nil, every candidate that is considered is recorded in reasons, together with the
+// reason why it is rejected.
+func (this *sparser) findSplit(list *spanListT, lo int, hi int, minPrecedence int, reasons *splitReasonsT) splitT {
+  spans := list.spans[lo:hi]
+  l := len(spans)-1
+  split := splitT{ loc: -1, prec: maxPrecedence+1, precLeft: -1, precRight: -1 }
   if span  := spans[0]; span.Cat != "OP" {
     if ws := spans[1]; ws.Cat == "WS" { // implies: len(spans) >= 3
       lit := span.Lit
-      prec := this.precedenceLWA[lit]
-      if prec >= minPrecedence && prec < splitPrecedence {
-        if this.checkRightwardJuxtapositionCandidate(spans, 1, prec) {
-          if prec == minPrecedence {
-            return &Syntax{ Cat: "JUXT", Lit: " ", Ambit: ambit, OpAmbit: ws.Ambit,
-                            Left: this.sparse(ambit.SubtractRight(ws.Ambit), spans[:1], prec),
-                            Right: this.sparse(ambit.SubtractLeft(ws.Ambit), spans[2:], prec) }
-          }
-          splitLoc, splitPrecedence, splitPrecLeft, splitPrecRight = 1, prec, prec, prec
+      prec := this.precedenceFrom(this.precedenceLWA, "LWA", lit, minPrecedence)
+      if prec < minPrecedence {
+        reasons.reject("LWA", span, prec, "precedence below minimum %d", minPrecedence)
+      } else if !this.checkRightwardJuxtapositionCandidate(spans, 1, prec) {
+        reasons.reject("LWA", span, prec, "juxtaposed to an operator", 0)
+      } else {
+        split = splitT{ loc: 1, pattern: "LWA", prec: prec, precLeft: prec, precRight: prec }
+        reasons.propose("LWA", span, prec)
+        if prec == minPrecedence {
+          return split
         }
       }
     } else {
       lit := span.Lit
-      prec := this.precedenceLA[lit]
-      if prec >= minPrecedence && prec < splitPrecedence {
-        if this.checkRightwardJuxtapositionCandidate(spans, 0, prec) {
-          if prec == minPrecedence {
-            return &Syntax{ Cat: "GLUE", Lit: "", Ambit: ambit, OpAmbit: span.Ambit.CollapseRight(),
-                            Left: this.sparse(span.Ambit, spans[:1], prec),
-                            Right: this.sparse(ambit.SubtractLeft(span.Ambit), spans[1:], prec) }
-          }
-          splitLoc, splitPrecedence, splitPrecLeft, splitPrecRight = 0, prec, prec, prec
+      prec := this.precedenceFrom(this.precedenceLA, "LA", lit, minPrecedence)
+      if prec < minPrecedence {
+        reasons.reject("LA", span, prec, "precedence below minimum %d", minPrecedence)
+      } else if !this.checkRightwardJuxtapositionCandidate(spans, 0, prec) {
+        reasons.reject("LA", span, prec, "juxtaposed to an operator", 0)
+      } else {
+        split = splitT{ loc: 0, pattern: "LA", prec: prec, precLeft: prec, precRight: prec }
+        reasons.propose("LA", span, prec)
+        if prec == minPrecedence {
+          return split
         }
       }
     }
@@ -108,128 +165,158 @@ func (this *sparser) sparse(ambit *Ambit, spans []*spanT, minPrecedence int) *Sy
   if span := spans[l]; span.Cat != "OP" {
     if ws := spans[l-1]; ws.Cat == "WS" { // implies: len(spans) >= 3
       lit := span.Lit
-      prec := this.precedenceAWL[lit]
-      if prec >= minPrecedence && prec < splitPrecedence {
-        if this.checkLeftwardJuxtapositionCandidate(spans, l-1, prec) {
-          if prec == minPrecedence {
-            return &Syntax{ Cat: "JUXT", Lit: " ", Ambit: ambit, OpAmbit: ws.Ambit,
-                            Left: this.sparse(ambit.SubtractRight(ws.Ambit), spans[:l-1], prec),
-                            Right: this.sparse(ambit.SubtractLeft(ws.Ambit), spans[l:], prec) }
-          }
-          splitLoc, splitPrecedence, splitPrecLeft, splitPrecRight = l-1, prec, prec, prec
+      prec := this.precedenceFrom(this.precedenceAWL, "AWL", lit, minPrecedence)
+      if prec < minPrecedence {
+        reasons.reject("AWL", span, prec, "precedence below minimum %d", minPrecedence)
+      } else if prec >= split.prec {
+        reasons.outrank("AWL", span, prec, split.prec)
+      } else if !this.checkLeftwardJuxtapositionCandidate(spans, l-1, prec) {
+        reasons.reject("AWL", span, prec, "juxtaposed to an operator", 0)
+      } else {
+        split = splitT{ loc: l-1, pattern: "AWL", prec: prec, precLeft: prec, precRight: prec }
+        reasons.propose("AWL", span, prec)
+        if prec == minPrecedence {
+          return split
         }
       }
     } else {
       lit := span.Lit
-      prec := this.precedenceAL[lit]
-      if prec >= minPrecedence && prec < splitPrecedence {
-        if this.checkLeftwardJuxtapositionCandidate(spans, l, prec) {
-          if prec == minPrecedence {
-            return &Syntax{ Cat: "GLUE", Lit: "", Ambit: ambit, OpAmbit: span.Ambit.CollapseLeft(),
-                            Left: this.sparse(ambit.SubtractRight(span.Ambit), spans[:l], prec),
-                            Right: this.sparse(span.Ambit, spans[l:], prec) }
-          }
-          splitLoc, splitPrecedence, splitPrecLeft, splitPrecRight = l, prec, prec, prec
+      prec := this.precedenceFrom(this.precedenceAL, "AL", lit, minPrecedence)
+      if prec < minPrecedence {
+        reasons.reject("AL", span, prec, "precedence below minimum %d", minPrecedence)
+      } else if prec >= split.prec {
+        reasons.outrank("AL", span, prec, split.prec)
+      } else if !this.checkLeftwardJuxtapositionCandidate(spans, l, prec) {
+        reasons.reject("AL", span, prec, "juxtaposed to an operator", 0)
+      } else {
+        split = splitT{ loc: l, pattern: "AL", prec: prec, precLeft: prec, precRight: prec }
+        reasons.propose("AL", span, prec)
+        if prec == minPrecedence {
+          return split
         }
       }
     }
   }
   if span := spans[0]; span.Cat == "OP" {
     lit := span.Lit
-    prec := this.precedenceEFA[lit]
-    if prec == minPrecedence {
-      return &Syntax{ Cat: span.Cat, Lit: lit, Ambit: ambit, OpAmbit: span.Ambit,
-                      Left: &Syntax{ Ambit: span.Ambit.CollapseLeft() },
-                      Right: this.sparse(ambit.SubtractLeft(span.Ambit), spans[1:], prec) }
+    prec := this.precedenceFrom(this.precedenceEFA, "EFA", lit, minPrecedence)
+    if prec < minPrecedence {
+      reasons.reject("EFA", span, prec, "precedence below minimum %d", minPrecedence)
+    } else if prec == minPrecedence {
+      reasons.propose("EFA", span, prec)
+      return splitT{ loc: 0, pattern: "EFA", prec: prec, precLeft: split.precLeft, precRight: prec }
+    } else if prec >= split.prec {
+      reasons.outrank("EFA", span, prec, split.prec)
+    } else {
+      split = splitT{ loc: 0, pattern: "EFA", prec: prec, precLeft: split.precLeft, precRight: prec }
+      reasons.propose("EFA", span, prec)
     }
-    if prec > minPrecedence && prec < splitPrecedence {
-      splitLoc, splitPrecedence, splitPrecRight = 0, prec, prec
-    } 
   }
   if span := spans[l]; span.Cat == "OP" {
     lit := span.Lit
-    prec := this.precedenceAFE[lit]
-    if prec == minPrecedence {
-      return &Syntax{ Cat: span.Cat, Lit: lit, Ambit: ambit, OpAmbit: span.Ambit,
-                      Left: this.sparse(ambit.SubtractRight(span.Ambit), spans[:l], prec),
-                      Right: &Syntax{ Ambit: span.Ambit.CollapseRight() } }
-    }
-    if prec >= minPrecedence && prec < splitPrecedence {
-      splitLoc, splitPrecedence, splitPrecLeft = l, prec, prec
+    prec := this.precedenceFrom(this.precedenceAFE, "AFE", lit, minPrecedence)
+    if prec < minPrecedence {
+      reasons.reject("AFE", span, prec, "precedence below minimum %d", minPrecedence)
+    } else if prec == minPrecedence {
+      reasons.propose("AFE", span, prec)
+      return splitT{ loc: l, pattern: "AFE", prec: prec, precLeft: prec, precRight: split.precRight }
+    } else if prec >= split.prec {
+      reasons.outrank("AFE", span, prec, split.prec)
+    } else {
+      split = splitT{ loc: l, pattern: "AFE", prec: prec, precLeft: prec, precRight: split.precRight }
+      reasons.propose("AFE", span, prec)
     }
   }
-  for indexLR := 1; indexLR < l; indexLR++ {
-    if span := spans[indexLR]; span.Cat == "OP" {
-      lit := span.Lit
-      prec := this.precedenceAFB[lit]
-      if prec >= minPrecedence && prec < splitPrecedence {
-        if this.checkInfixCandidate(spans, indexLR, prec, prec+1) {
-          if prec == minPrecedence {
-            return &Syntax{ Cat: span.Cat, Lit: lit, Ambit: ambit, OpAmbit: span.Ambit, 
-                            Left: this.sparse(ambit.SubtractRight(span.Ambit), spans[:indexLR], prec+1),
-                            Right: this.sparse(ambit.SubtractLeft(span.Ambit), spans[indexLR+1:], prec) }
-          }
-          splitLoc, splitPrecedence, splitPrecLeft, splitPrecRight = indexLR, prec, prec+1, prec
-        }
-      }
-    }
-    indexRL := l - indexLR
-    if span := spans[indexRL]; span.Cat == "OP" {
-      lit := span.Lit
-      prec := this.precedenceBFA[lit]
-      if prec >= minPrecedence && prec < splitPrecedence {
-        if this.checkInfixCandidate(spans, indexRL, prec+1, prec) {
-          if prec == minPrecedence {
-            return &Syntax{ Cat: span.Cat, Lit: lit, Ambit: ambit, OpAmbit: span.Ambit, 
-                            Left: this.sparse(ambit.SubtractRight(span.Ambit), spans[:indexRL], prec),
-                            Right: this.sparse(ambit.SubtractLeft(span.Ambit), spans[indexRL+1:], prec+1) }
-          }
-          splitLoc, splitPrecedence, splitPrecLeft, splitPrecRight = indexRL, prec, prec, prec+1
-        }
+  if span := spans[l]; span.Cat == "BB" {
+    lit := span.Lit
+    prec := this.precedenceFrom(this.precedenceAPP, "APP", lit, minPrecedence)
+    if prec < minPrecedence {
+      reasons.reject("APP", span, prec, "precedence below minimum %d", minPrecedence)
+    } else if prec >= split.prec {
+      reasons.outrank("APP", span, prec, split.prec)
+    } else if this.leftOperandStop(spans, 0, l, prec) < 0 {
+      reasons.reject("APP", span, prec, "no left operand at precedence %d", prec)
+    } else {
+      split = splitT{ loc: l, pattern: "APP", prec: prec, precLeft: prec, precRight: this.precedenceB[lit] }
+      reasons.propose("APP", span, prec)
+      if prec == minPrecedence {
+        return split
       }
     }
   }
-  if splitLoc >= 0 {
-    splitSpan := spans[splitLoc]
-    cat, lit := splitSpan.Cat, splitSpan.Lit
-    if cat == "WS" {
-      cat, lit = "JUXT", " "
+  if candidate, prec := this.findInfixCandidate(list, lo, hi, minPrecedence, split.prec, reasons); candidate != nil {
+    precLeft, precRight := prec+1, prec
+    switch candidate.order {
+    case orderBFB:
+      precRight = prec+1
+    case orderBFA:
+      precLeft, precRight = prec, prec+1
     }
-    if splitLoc == 0 {
-      if cat == "OP" {
-        return &Syntax{ Cat: cat, Lit: lit, Ambit: ambit, OpAmbit: splitSpan.Ambit,
-                        Left: &Syntax{ Ambit: splitSpan.Ambit.CollapseLeft() },
-                        Right: this.sparse(ambit.SubtractLeft(splitSpan.Ambit), spans[1:], splitPrecRight) }
-      } else {
-        return &Syntax{ Cat: "GLUE", Lit: "", Ambit: ambit, OpAmbit: splitSpan.Ambit.CollapseRight(),
-                        Left: this.sparse(splitSpan.Ambit, spans[:1], splitPrecLeft),
-                        Right: this.sparse(ambit.SubtractLeft(splitSpan.Ambit), spans[1:], splitPrecRight) }
-      }
-    } 
-    if splitLoc == l {
-      if cat == "OP" {
-        return &Syntax{ Cat: cat, Lit: lit, Ambit: ambit, OpAmbit: splitSpan.Ambit,
-                        Left: this.sparse(ambit.SubtractRight(splitSpan.Ambit), spans[:l], splitPrecLeft),
-                        Right: &Syntax{ Ambit: splitSpan.Ambit.CollapseRight() } }
-      } else {
-        return &Syntax{ Cat: "GLUE", Lit: "", Ambit: ambit, OpAmbit: splitSpan.Ambit.CollapseLeft(),
-                        Left: this.sparse(ambit.SubtractRight(splitSpan.Ambit), spans[:l], splitPrecLeft),
-                        Right: this.sparse(splitSpan.Ambit, spans[l:], splitPrecRight) }
-      }
+    reasons.propose(infixPatterns[candidate.order], list.spans[candidate.loc], prec)
+    return splitT{ loc: candidate.loc-lo, pattern: infixPatterns[candidate.order], prec: prec,
+                   precLeft: precLeft, precRight: precRight, candidate: candidate }
+  }
+  if split.loc < 0 {
+    if spans[1].Cat == "WS" {
+      return splitT{ loc: 1, pattern: "JUXT", prec: minPrecedence, precLeft: minPrecedence, precRight: minPrecedence }
     }
-    return &Syntax{ Cat: cat, Lit: lit, Ambit: ambit, OpAmbit: splitSpan.Ambit, 
-                    Left: this.sparse(ambit.SubtractRight(splitSpan.Ambit), spans[:splitLoc], splitPrecLeft),
-                    Right: this.sparse(ambit.SubtractLeft(splitSpan.Ambit), spans[splitLoc+1:], splitPrecRight) }
+    return splitT{ loc: 1, pattern: "GLUE", prec: minPrecedence, precLeft: minPrecedence, precRight: minPrecedence }
   }
-  firstSpan, secondSpan := spans[0], spans[1]
-  if secondSpan.Cat == "WS" {
+  return split
+}
+
+func (this *sparser) splitSyntax(ambit *Ambit, list *spanListT, lo int, hi int, split splitT) *Syntax {
+  spans := list.spans[lo:hi]
+  l, splitLoc, splitPrecLeft, splitPrecRight := len(spans)-1, split.loc, split.precLeft, split.precRight
+  switch split.pattern {
+  case "AFB", "BFB", "MIX", "BFA":
+    return this.infixSyntax(ambit, list, lo, hi, split.candidate, split.prec)
+  case "APP":
+    span := spans[l]
+    return &Syntax{ Cat: "APP", Lit: span.Lit, Ambit: ambit, OpAmbit: span.Ambit,
+                    Left: this.sparse(ambit.SubtractRight(span.Ambit), list, lo, hi-1, splitPrecLeft),
+                    Right: this.sparse(span.SubAmbit, newSpanList(span.Children), 0, len(span.Children), splitPrecRight) }
+  case "JUXT":
+    secondSpan := spans[1]
     return &Syntax{ Cat: "JUXT", Lit: " ", Ambit: ambit, OpAmbit: secondSpan.Ambit,
-                    Left: this.sparse(ambit.SubtractRight(secondSpan.Ambit), spans[:1], minPrecedence),
-                    Right: this.sparse(ambit.SubtractLeft(secondSpan.Ambit), spans[2:], minPrecedence) }
+                    Left: this.sparse(ambit.SubtractRight(secondSpan.Ambit), list, lo, lo+1, splitPrecLeft),
+                    Right: this.sparse(ambit.SubtractLeft(secondSpan.Ambit), list, lo+2, hi, splitPrecRight) }
+  case "GLUE":
+    firstSpan, secondSpan := spans[0], spans[1]
+    return &Syntax{ Cat: "GLUE", Lit: "", Ambit: ambit, OpAmbit: secondSpan.Ambit.CollapseLeft(),
+                    Left: this.sparse(ambit.SubtractRight(secondSpan.Ambit), list, lo, lo+1, splitPrecLeft),
+                    Right: this.sparse(ambit.SubtractLeft(firstSpan.Ambit), list, lo+1, hi, splitPrecRight) }
+  }
+  splitSpan := spans[splitLoc]
+  cat, lit := splitSpan.Cat, splitSpan.Lit
+  if cat == "WS" {
+    cat, lit = "JUXT", " "
   }
-  return &Syntax{ Cat: "GLUE", Lit: "", Ambit: ambit, OpAmbit: secondSpan.Ambit.CollapseLeft(),
-                  Left: this.sparse(ambit.SubtractRight(secondSpan.Ambit), spans[:1], minPrecedence),
-                  Right: this.sparse(ambit.SubtractLeft(firstSpan.Ambit), spans[1:], minPrecedence) }
+  if splitLoc == 0 {
+    if cat == "OP" {
+      return &Syntax{ Cat: cat, Lit: lit, Ambit: ambit, OpAmbit: splitSpan.Ambit,
+                      Left: &Syntax{ Ambit: splitSpan.Ambit.CollapseLeft() },
+                      Right: this.sparse(ambit.SubtractLeft(splitSpan.Ambit), list, lo+1, hi, splitPrecRight) }
+    } else {
+      return &Syntax{ Cat: "GLUE", Lit: "", Ambit: ambit, OpAmbit: splitSpan.Ambit.CollapseRight(),
+                      Left: this.sparse(splitSpan.Ambit, list, lo, lo+1, splitPrecLeft),
+                      Right: this.sparse(ambit.SubtractLeft(splitSpan.Ambit), list, lo+1, hi, splitPrecRight) }
+    }
+  } 
+  if splitLoc == l {
+    if cat == "OP" {
+      return &Syntax{ Cat: cat, Lit: lit, Ambit: ambit, OpAmbit: splitSpan.Ambit,
+                      Left: this.sparse(ambit.SubtractRight(splitSpan.Ambit), list, lo, hi-1, splitPrecLeft),
+                      Right: &Syntax{ Ambit: splitSpan.Ambit.CollapseRight() } }
+    } else {
+      return &Syntax{ Cat: "GLUE", Lit: "", Ambit: ambit, OpAmbit: splitSpan.Ambit.CollapseLeft(),
+                      Left: this.sparse(ambit.SubtractRight(splitSpan.Ambit), list, lo, hi-1, splitPrecLeft),
+                      Right: this.sparse(splitSpan.Ambit, list, hi-1, hi, splitPrecRight) }
+    }
+  }
+  return &Syntax{ Cat: cat, Lit: lit, Ambit: ambit, OpAmbit: splitSpan.Ambit, 
+                  Left: this.sparse(ambit.SubtractRight(splitSpan.Ambit), list, lo, lo+splitLoc, splitPrecLeft),
+                  Right: this.sparse(ambit.SubtractLeft(splitSpan.Ambit), list, lo+splitLoc+1, hi, splitPrecRight) }
 }
 
 func (this *sparser) checkLeftwardJuxtapositionCandidate(spans []*spanT, index int, minPrecLeft int) bool {
@@ -241,9 +328,11 @@ func (this *sparser) checkLeftwardJuxtapositionCandidate(spans []*spanT, index i
         return true
       }
       lit := span.Lit
-      if this.precedenceAFB[lit] >= minPrecLeft ||
-         this.precedenceBFA[lit] >= minPrecLeft ||
-         this.precedenceEFA[lit] >= minPrecLeft {
+      if this.precedenceFrom(this.precedenceAFB, "AFB", lit, minPrecLeft) >= minPrecLeft ||
+         this.precedenceFrom(this.precedenceBFA, "BFA", lit, minPrecLeft) >= minPrecLeft ||
+         this.precedenceFrom(this.precedenceBFB, "BFB", lit, minPrecLeft) >= minPrecLeft ||
+         this.precedenceFrom(this.precedenceEFA, "EFA", lit, minPrecLeft) >= minPrecLeft ||
+         this.precedenceFrom(this.precedenceMIX, "MIX", lit, minPrecLeft) >= minPrecLeft {
         return false
       }
       return true
@@ -263,9 +352,11 @@ func (this *sparser) checkRightwardJuxtapositionCandidate(spans []*spanT, index
         return true
       }
       lit := span.Lit
-      if this.precedenceAFB[lit] >= minPrecRight ||
-         this.precedenceBFA[lit] >= minPrecRight ||
-         this.precedenceAFE[lit] >= minPrecRight {
+      if this.precedenceFrom(this.precedenceAFB, "AFB", lit, minPrecRight) >= minPrecRight ||
+         this.precedenceFrom(this.precedenceBFA, "BFA", lit, minPrecRight) >= minPrecRight ||
+         this.precedenceFrom(this.precedenceBFB, "BFB", lit, minPrecRight) >= minPrecRight ||
+         this.precedenceFrom(this.precedenceAFE, "AFE", lit, minPrecRight) >= minPrecRight ||
+         this.precedenceFrom(this.precedenceMIX, "MIX", lit, minPrecRight) >= minPrecRight {
         return false
       }
       return true
@@ -275,80 +366,384 @@ func (this *sparser) checkRightwardJuxtapositionCandidate(spans []*spanT, index
   return false
 }
 
-func (this *sparser) checkInfixCandidate(spans []*spanT, index int, minPrecLeft int, minPrecRight int) bool {  
+// A spanListT holds a list of sibling spans: the spans of an ambit or the children
+// of a bracket span. Subranges of the list are sparsed in place, the index of
+// infix candidates in the list is built on first use and shared between them.
+type spanListT struct {
+  spans []*spanT
+  index *spanIndexT
+}
+
+func newSpanList(spans []*spanT) *spanListT {
+  return &spanListT{ spans: spans }
+}
+
+// The spanIndexT holds the infix and mixfix candidates of a span list by
+// precedence. The leftward candidates (AFB, BFB and MIX) are ordered by location
+// and by the order in which they are tried at the same location, the rightward
+// candidates (BFA) are ordered by location. When the sparser explains its
+// decisions the operators that are no valid split for the full list are kept
+// as rejected candidates.
+type spanIndexT struct {
+  precs []int
+  leftward map[int][]*infixCandidateT
+  rightward map[int][]*infixCandidateT
+  rejected []*infixCandidateT
+}
+
+const (
+  orderAFB = iota
+  orderBFB
+  orderMIX
+  orderBFA
+)
+
+var infixPatterns = []string{ "AFB", "BFB", "MIX", "BFA" }
+
+const unresolved = -2
+
+// An infixCandidateT is an operator span that is a valid split for the full list,
+// at the given precedence of its binding pattern. The left and right fields hold the locations at which the checks of the left
+// and right operand succeed, the candidate is valid for a subrange of the list
+// iff the subrange contains both these locations. For mixfix candidates the right
+// operand is only checked once the remaining functors have been located.
+type infixCandidateT struct {
+  loc int
+  order int
+  prec int
+  left int
+  right int
+  locs []int
+}
+
+func (this *sparser) spanIndex(list *spanListT) *spanIndexT {
+  if list.index == nil {
+    list.index = this.indexSpans(list.spans)
+  }
+  return list.index
+}
+
+func (this *sparser) indexSpans(spans []*spanT) *spanIndexT {
+  index := &spanIndexT{ leftward: map[int][]*infixCandidateT{}, rightward: map[int][]*infixCandidateT{} }
+  add := func(candidates map[int][]*infixCandidateT, prec int, loc int, order int) {
+    minPrecLeft, minPrecRight := infixOperandChecks(order, prec)
+    left := this.leftOperandStop(spans, 0, loc, minPrecLeft)
+    right := unresolved
+    if left >= 0 && order != orderMIX {
+      right = this.rightOperandStop(spans, loc, len(spans), minPrecRight)
+    }
+    if left < 0 || right == -1 {
+      if this.explanation != nil {
+        index.rejected = append(index.rejected, &infixCandidateT{ loc: loc, order: order, prec: prec, left: left, right: right })
+      }
+      return
+    }
+    if index.leftward[prec] == nil && index.rightward[prec] == nil {
+      index.precs = append(index.precs, prec)
+    }
+    candidates[prec] = append(candidates[prec], &infixCandidateT{ loc: loc, order: order, prec: prec, left: left, right: right })
+  }
+  for loc, span := range spans {
+    if span.Cat != "OP" {
+      continue
+    }
+    lit := span.Lit
+    this.eachPrecedence(this.precedenceAFB, "AFB", lit, func(prec int) {
+      add(index.leftward, prec, loc, orderAFB)
+    })
+    this.eachPrecedence(this.precedenceBFB, "BFB", lit, func(prec int) {
+      add(index.leftward, prec, loc, orderBFB)
+    })
+    if this.mixfix[lit] != nil {
+      this.eachPrecedence(this.precedenceMIX, "MIX", lit, func(prec int) {
+        add(index.leftward, prec, loc, orderMIX)
+      })
+    }
+    this.eachPrecedence(this.precedenceBFA, "BFA", lit, func(prec int) {
+      add(index.rightward, prec, loc, orderBFA)
+    })
+  }
+  sort.Ints(index.precs)
+  return index
+}
+
+// infixOperandChecks returns the minimal precedences at which the left and right
+// operand of an infix or mixfix operator of the given order are checked.
+func infixOperandChecks(order int, prec int) (int, int) {
+  switch order {
+  case orderAFB:
+    return prec, prec+1
+  case orderBFB:
+    return prec+1, prec+1
+  }
+  return prec+1, prec
+}
+
+// validInfixCandidate reports whether the candidate is a valid split for the
+// given subrange of the list.
+func (this *sparser) validInfixCandidate(list *spanListT, candidate *infixCandidateT, lo int, hi int) bool {
+  if candidate.left < lo {
+    return false
+  }
+  if candidate.right == unresolved {
+    spans := list.spans
+    span := spans[candidate.loc]
+    candidate.locs = this.matchMixfix(spans, candidate.loc, this.mixfix[span.Lit])
+    candidate.right = -1
+    if candidate.locs != nil {
+      candidate.right = this.rightOperandStop(spans, candidate.locs[len(candidate.locs)-1], len(spans), candidate.prec)
+    }
+  }
+  return candidate.right >= 0 && candidate.right < hi
+}
+
+// findInfixCandidate returns the infix or mixfix operator to split the given
+// subrange of the list on: the valid candidate with the lowest precedence below
+// maxPrecedence, and among those the one closest to either end of the subrange.
+// On equal distance, or on equal location, the leftward candidates are preferred
+// in the order AFB, BFB, MIX. Unless reasons is nil all the candidates in the
+// subrange are checked and recorded in reasons.
+func (this *sparser) findInfixCandidate(list *spanListT, lo int, hi int, minPrecedence int, maxPrecedence int, reasons *splitReasonsT) (*infixCandidateT, int) {
+  index := this.spanIndex(list)
+  if reasons != nil {
+    for _, candidate := range index.rejected {
+      if candidate.loc > lo && candidate.loc < hi-1 {
+        reasons.rejectInfix(list, candidate, lo, minPrecedence)
+      }
+    }
+  }
+  var found *infixCandidateT
+  foundPrec := maxPrecedence
+  for _, prec := range index.precs {
+    if prec < minPrecedence || prec >= maxPrecedence || found != nil {
+      if reasons != nil {
+        this.rejectInfixCandidates(list, lo, hi, minPrecedence, prec, foundPrec, reasons)
+      } else if prec >= maxPrecedence {
+        break
+      }
+      continue
+    }
+    var leftward, rightward *infixCandidateT
+    candidates := index.leftward[prec]
+    for k := sort.Search(len(candidates), func(k int) bool { return candidates[k].loc > lo }); k < len(candidates) && candidates[k].loc < hi-1; k++ {
+      if candidate := candidates[k]; !this.validInfixCandidate(list, candidate, lo, hi) {
+        reasons.rejectInfix(list, candidate, lo, minPrecedence)
+      } else if leftward == nil {
+        leftward = candidate
+        if reasons == nil {
+          break
+        }
+      } else {
+        reasons.outrank(infixPatterns[candidate.order], list.spans[candidate.loc], prec, prec)
+      }
+    }
+    candidates = index.rightward[prec]
+    for k := sort.Search(len(candidates), func(k int) bool { return candidates[k].loc >= hi-1 })-1; k >= 0 && candidates[k].loc > lo; k-- {
+      if candidate := candidates[k]; !this.validInfixCandidate(list, candidate, lo, hi) {
+        reasons.rejectInfix(list, candidate, lo, minPrecedence)
+      } else if rightward == nil {
+        rightward = candidate
+        if reasons == nil {
+          break
+        }
+      } else {
+        reasons.outrank(infixPatterns[candidate.order], list.spans[candidate.loc], prec, prec)
+      }
+    }
+    passed := rightward
+    if leftward != nil && (rightward == nil || leftward.loc-lo <= hi-1-rightward.loc) {
+      found, passed = leftward, rightward
+    } else if rightward != nil {
+      found, passed = rightward, leftward
+    }
+    if found == nil {
+      continue
+    }
+    foundPrec = prec
+    if reasons == nil {
+      return found, prec
+    }
+    if passed != nil {
+      reasons.outrank(infixPatterns[passed.order], list.spans[passed.loc], prec, prec)
+    }
+  }
+  if found == nil {
+    return nil, 0
+  }
+  return found, foundPrec
+}
+
+// rejectInfixCandidates records all the candidates at the given precedence in the
+// subrange of the list, which are either below the minimal precedence or outranked
+// by the split at the precedence of the split.
+func (this *sparser) rejectInfixCandidates(list *spanListT, lo int, hi int, minPrecedence int, prec int, splitPrec int, reasons *splitReasonsT) {
+  index := this.spanIndex(list)
+  for _, candidates := range [][]*infixCandidateT{ index.leftward[prec], index.rightward[prec] } {
+    for _, candidate := range candidates {
+      if candidate.loc <= lo || candidate.loc >= hi-1 {
+        continue
+      }
+      if prec < minPrecedence || !this.validInfixCandidate(list, candidate, lo, hi) {
+        reasons.rejectInfix(list, candidate, lo, minPrecedence)
+      } else {
+        reasons.outrank(infixPatterns[candidate.order], list.spans[candidate.loc], prec, splitPrec)
+      }
+    }
+  }
+}
+
+func (this *sparser) infixSyntax(ambit *Ambit, list *spanListT, lo int, hi int, candidate *infixCandidateT, prec int) *Syntax {
+  switch candidate.order {
+  case orderBFB:
+    return this.nonAssocSyntax(ambit, list, lo, hi, candidate, prec)
+  case orderMIX:
+    return this.mixfixSyntax(ambit, list, lo, hi, candidate.locs, prec+1, prec)
+  }
+  precLeft, precRight := prec+1, prec
+  if candidate.order == orderBFA {
+    precLeft, precRight = prec, prec+1
+  }
+  index := candidate.loc
+  span := list.spans[index]
+  return &Syntax{ Cat: span.Cat, Lit: span.Lit, Ambit: ambit, OpAmbit: span.Ambit, 
+                  Left: this.sparse(ambit.SubtractRight(span.Ambit), list, lo, index, precLeft),
+                  Right: this.sparse(ambit.SubtractLeft(span.Ambit), list, index+1, hi, precRight) }
+}
+
+// leftOperandStop returns the location at which the check for a left operand of
+// the operator at the given index succeeds, or -1 if there is no such operand
+// in between lo and the operator.
+func (this *sparser) leftOperandStop(spans []*spanT, lo int, index int, minPrecLeft int) int {
   indexRL := index-1
-  for indexRL >= 0 {
+  for indexRL >= lo {
     span := spans[indexRL]
     if span.Cat != "WS" {
       if span.Cat != "OP" {
-        break
+        return indexRL
       }
       lit := span.Lit
-      prec := this.precedenceEFE[lit]
+      prec := this.precedenceFrom(this.precedenceEFE, "EFE", lit, minPrecLeft)
       if prec >= minPrecLeft {
-        break
+        return indexRL
       }
-      prec = this.precedenceAFE[lit]
+      prec = this.precedenceFrom(this.precedenceAFE, "AFE", lit, minPrecLeft)
       if prec < minPrecLeft {
-        return false
+        return -1
       }
       minPrecLeft = prec
     }
     indexRL--
   }
-  if indexRL < 0 {
-    return false
-  }
-  l := len(spans)-1
+  return -1
+}
+
+// rightOperandStop returns the location at which the check for a right operand of
+// the operator at the given index succeeds, or -1 if there is no such operand
+// in between the operator and hi.
+func (this *sparser) rightOperandStop(spans []*spanT, index int, hi int, minPrecRight int) int {
   indexLR := index+1 
-  for indexLR <= l {
+  for indexLR < hi {
     span := spans[indexLR]
     if span.Cat != "WS" {
       if span.Cat != "OP" {
-        break
+        return indexLR
       }
       lit := span.Lit
-      prec := this.precedenceEFE[lit]
+      prec := this.precedenceFrom(this.precedenceEFE, "EFE", lit, minPrecRight)
       if prec >= minPrecRight {
-        break
+        return indexLR
       }
-      prec = this.precedenceEFA[lit]
+      prec = this.precedenceFrom(this.precedenceEFA, "EFA", lit, minPrecRight)
       if prec < minPrecRight {
-        return false
+        return -1
       }
       minPrecRight = prec
     }
     indexLR++
   }
-  if indexLR > l {
-    return false
-  }
-  return true
+  return -1
 }
 
-
-
-func trimSpans(ambit *Ambit, spans []*spanT) (*Ambit, []*spanT) {
-  return trimSpansLeft(trimSpansRight(ambit, spans))
+// nonAssocSyntax returns the syntax tree for the non-associative operator of the
+// given candidate. If the operator is chained with the next operator of the same
+// layer the right operand, which holds the rest of the chain, is an error.
+func (this *sparser) nonAssocSyntax(ambit *Ambit, list *spanListT, lo int, hi int, candidate *infixCandidateT, prec int) *Syntax {
+  index := candidate.loc
+  span := list.spans[index]
+  left := this.sparse(ambit.SubtractRight(span.Ambit), list, lo, index, prec+1)
+  candidates := this.spanIndex(list).leftward[prec]
+  for k := sort.Search(len(candidates), func(k int) bool { return candidates[k].loc > index }); k < len(candidates) && candidates[k].loc < hi; k++ {
+    if next := candidates[k]; next.order == orderBFB && this.validInfixCandidate(list, next, lo, hi) {
+      nextSpan := list.spans[next.loc]
+      rightAmbit, _, _ := trimSpans(ambit.SubtractLeft(span.Ambit), list.spans, index+1, hi)
+      return &Syntax{ Cat: span.Cat, Lit: span.Lit, Ambit: ambit, OpAmbit: span.Ambit, Left: left,
+                      Right: &Syntax{ Cat: "ERR", Err: fmt.Sprintf("non-associative operators cannot be chained: '%s' after '%s': use parentheses", nextSpan.Lit, span.Lit),
+                                      Ambit: rightAmbit, OpAmbit: nextSpan.Ambit } }
+    }
+  }
+  return &Syntax{ Cat: span.Cat, Lit: span.Lit, Ambit: ambit, OpAmbit: span.Ambit, Left: left,
+                  Right: this.sparse(ambit.SubtractLeft(span.Ambit), list, index+1, hi, prec+1) }
 }
 
-func trimSpansLeft(ambit *Ambit, spans []*spanT) (*Ambit, []*spanT) {
-  for index, span := range spans {
-    if span.Cat != "WS" {
-      return ambit, spans[index:]
+// matchMixfix returns the locations of all the functors of the mixfix operator
+// whose first functor is located at the given index, or nil if some functor is
+// missing. Nested occurrences of the same operator are skipped over.
+func (this *sparser) matchMixfix(spans []*spanT, index int, functors []string) []int {
+  locs := make([]int, 1, len(functors))
+  locs[0] = index
+  first, last := functors[0], functors[len(functors)-1]
+  depth := 0
+  for indexLR := index+1; indexLR < len(spans); indexLR++ {
+    span := spans[indexLR]
+    if span.Cat != "OP" {
+      continue
+    }
+    lit := span.Lit
+    if lit == first {
+      depth++
+    } else if depth > 0 {
+      if lit == last {
+        depth--
+      }
+    } else if lit == functors[len(locs)] {
+      locs = append(locs, indexLR)
+      if len(locs) == len(functors) {
+        return locs
+      }
     }
-    ambit = ambit.SubtractLeft(span.Ambit)
   }
-  return ambit, nil
+  return nil
 }
 
-func trimSpansRight(ambit *Ambit, spans []*spanT) (*Ambit, []*spanT) {
-  for index := len(spans)-1; index >= 0; index-- {
-    span := spans[index]
-    if span.Cat != "WS" {
-      return ambit, spans[:index+1]
+func (this *sparser) mixfixSyntax(ambit *Ambit, list *spanListT, lo int, hi int, locs []int, precLeft int, precRight int) *Syntax {
+  spans := list.spans
+  firstSpan, lastSpan := spans[locs[0]], spans[locs[len(locs)-1]]
+  lits := make([]string, len(locs))
+  mid := make([]*Syntax, len(locs)-1)
+  for index, loc := range locs {
+    lits[index] = spans[loc].Lit
+    if index > 0 {
+      prevSpan, span := spans[locs[index-1]], spans[loc]
+      mid[index-1] = this.sparse(ambit.SubtractLeft(prevSpan.Ambit).SubtractRight(span.Ambit),
+                                 list, locs[index-1]+1, loc, precRight)
     }
-    ambit = ambit.SubtractRight(span.Ambit)
   }
-  return ambit, nil
+  return &Syntax{ Cat: "OP", Lit: strings.Join(lits, " "), Ambit: ambit, OpAmbit: firstSpan.Ambit,
+                  Left: this.sparse(ambit.SubtractRight(firstSpan.Ambit), list, lo, locs[0], precLeft),
+                  Mid: mid,
+                  Right: this.sparse(ambit.SubtractLeft(lastSpan.Ambit), list, locs[len(locs)-1]+1, hi, precRight) }
+}
+
+// trimSpans removes the whitespace at both ends of the given subrange of spans
+// from the subrange and from the ambit.
+func trimSpans(ambit *Ambit, spans []*spanT, lo int, hi int) (*Ambit, int, int) {
+  for hi > lo && spans[hi-1].Cat == "WS" {
+    ambit = ambit.SubtractRight(spans[hi-1].Ambit)
+    hi--
+  }
+  for lo < hi && spans[lo].Cat == "WS" {
+    ambit = ambit.SubtractLeft(spans[lo].Ambit)
+    lo++
+  }
+  return ambit, lo, hi
 }
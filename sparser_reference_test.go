@@ -0,0 +1,577 @@
+package dusl
+
+import (
+  "bytes"
+  "fmt"
+  "math/rand"
+  "strings"
+  "testing"
+)
+
+// The referenceSparser is the original scanning implementation of the sparser
+// algorithm: at every level of recursion it scans all the spans for the split with
+// the lowest precedence. It serves as the specification against which the indexed
+// sparser is verified.
+type referenceSparser struct {
+  precedenceLevels
+}
+
+func (this *referenceSparser) sparse(ambit *Ambit, spans []*spanT, minPrecedence int) *Syntax {
+  ambit, spans = referenceTrimSpans(ambit, spans)
+  if len(spans) == 0 {
+    return &Syntax{ Ambit: ambit }
+  }
+  if len(spans) == 1 {
+    span := spans[0]
+    lit := span.Lit
+    if span.Children == nil {
+      if span.Cat == "OP" {
+        if this.precedenceFrom(this.precedenceEFE, "EFE", lit, minPrecedence) < minPrecedence {
+          if this.precedenceMIX[lit] > 0 {
+            return &Syntax{ Cat: "ERR", Err: fmt.Sprintf("unexpected: %s: incomplete mixfix operator", lit), Ambit: ambit }
+          }
+          return &Syntax{ Cat: "ERR", Err: fmt.Sprintf("unexpected: %s", lit), Ambit: ambit }
+        }
+        return &Syntax{ Cat: "OP", Lit: lit, Ambit: ambit, OpAmbit: span.Ambit,
+                        Left: &Syntax{ Ambit: span.Ambit.CollapseLeft() },
+                        Right: &Syntax{ Ambit: span.Ambit.CollapseRight() } }
+      }
+      return &Syntax{ Cat: span.Cat, Lit: lit, Err: span.Err, Ambit: ambit, OpAmbit: span.Ambit }
+    }
+    // span.Cat == "BB"
+    precedence, recognized := this.precedenceB[lit]
+    if !recognized {
+      return &Syntax{ Cat: "ERR", Err: fmt.Sprintf("unexpected: %s", lit), Ambit: ambit }
+    }
+    return &Syntax{ Cat: span.Cat, Lit: lit, Ambit: span.Ambit,
+                    Left: this.sparse(span.SubAmbit, span.Children, precedence),
+                    Right: &Syntax{ Ambit: span.Ambit.CollapseRight() } }
+  }
+  l, splitPrecedence, splitLoc, splitPrecLeft, splitPrecRight := len(spans)-1, maxPrecedence+1, -1, -1, -1
+  var splitMix []int
+  splitNonAssoc := false
+  if span  := spans[0]; span.Cat != "OP" {
+    if ws := spans[1]; ws.Cat == "WS" { // implies: len(spans) >= 3
+      lit := span.Lit
+      prec := this.precedenceFrom(this.precedenceLWA, "LWA", lit, minPrecedence)
+      if prec >= minPrecedence && prec < splitPrecedence {
+        if this.checkRightwardJuxtapositionCandidate(spans, 1, prec) {
+          if prec == minPrecedence {
+            return &Syntax{ Cat: "JUXT", Lit: " ", Ambit: ambit, OpAmbit: ws.Ambit,
+                            Left: this.sparse(ambit.SubtractRight(ws.Ambit), spans[:1], prec),
+                            Right: this.sparse(ambit.SubtractLeft(ws.Ambit), spans[2:], prec) }
+          }
+          splitLoc, splitPrecedence, splitPrecLeft, splitPrecRight = 1, prec, prec, prec
+        }
+      }
+    } else {
+      lit := span.Lit
+      prec := this.precedenceFrom(this.precedenceLA, "LA", lit, minPrecedence)
+      if prec >= minPrecedence && prec < splitPrecedence {
+        if this.checkRightwardJuxtapositionCandidate(spans, 0, prec) {
+          if prec == minPrecedence {
+            return &Syntax{ Cat: "GLUE", Lit: "", Ambit: ambit, OpAmbit: span.Ambit.CollapseRight(),
+                            Left: this.sparse(span.Ambit, spans[:1], prec),
+                            Right: this.sparse(ambit.SubtractLeft(span.Ambit), spans[1:], prec) }
+          }
+          splitLoc, splitPrecedence, splitPrecLeft, splitPrecRight = 0, prec, prec, prec
+        }
+      }
+    }
+  }
+  if span := spans[l]; span.Cat != "OP" {
+    if ws := spans[l-1]; ws.Cat == "WS" { // implies: len(spans) >= 3
+      lit := span.Lit
+      prec := this.precedenceFrom(this.precedenceAWL, "AWL", lit, minPrecedence)
+      if prec >= minPrecedence && prec < splitPrecedence {
+        if this.checkLeftwardJuxtapositionCandidate(spans, l-1, prec) {
+          if prec == minPrecedence {
+            return &Syntax{ Cat: "JUXT", Lit: " ", Ambit: ambit, OpAmbit: ws.Ambit,
+                            Left: this.sparse(ambit.SubtractRight(ws.Ambit), spans[:l-1], prec),
+                            Right: this.sparse(ambit.SubtractLeft(ws.Ambit), spans[l:], prec) }
+          }
+          splitLoc, splitPrecedence, splitPrecLeft, splitPrecRight = l-1, prec, prec, prec
+        }
+      }
+    } else {
+      lit := span.Lit
+      prec := this.precedenceFrom(this.precedenceAL, "AL", lit, minPrecedence)
+      if prec >= minPrecedence && prec < splitPrecedence {
+        if this.checkLeftwardJuxtapositionCandidate(spans, l, prec) {
+          if prec == minPrecedence {
+            return &Syntax{ Cat: "GLUE", Lit: "", Ambit: ambit, OpAmbit: span.Ambit.CollapseLeft(),
+                            Left: this.sparse(ambit.SubtractRight(span.Ambit), spans[:l], prec),
+                            Right: this.sparse(span.Ambit, spans[l:], prec) }
+          }
+          splitLoc, splitPrecedence, splitPrecLeft, splitPrecRight = l, prec, prec, prec
+        }
+      }
+    }
+  }
+  if span := spans[0]; span.Cat == "OP" {
+    lit := span.Lit
+    prec := this.precedenceFrom(this.precedenceEFA, "EFA", lit, minPrecedence)
+    if prec == minPrecedence {
+      return &Syntax{ Cat: span.Cat, Lit: lit, Ambit: ambit, OpAmbit: span.Ambit,
+                      Left: &Syntax{ Ambit: span.Ambit.CollapseLeft() },
+                      Right: this.sparse(ambit.SubtractLeft(span.Ambit), spans[1:], prec) }
+    }
+    if prec > minPrecedence && prec < splitPrecedence {
+      splitLoc, splitPrecedence, splitPrecRight = 0, prec, prec
+    } 
+  }
+  if span := spans[l]; span.Cat == "OP" {
+    lit := span.Lit
+    prec := this.precedenceFrom(this.precedenceAFE, "AFE", lit, minPrecedence)
+    if prec == minPrecedence {
+      return &Syntax{ Cat: span.Cat, Lit: lit, Ambit: ambit, OpAmbit: span.Ambit,
+                      Left: this.sparse(ambit.SubtractRight(span.Ambit), spans[:l], prec),
+                      Right: &Syntax{ Ambit: span.Ambit.CollapseRight() } }
+    }
+    if prec >= minPrecedence && prec < splitPrecedence {
+      splitLoc, splitPrecedence, splitPrecLeft = l, prec, prec
+    }
+  }
+  splitApp := false
+  if span := spans[l]; span.Cat == "BB" {
+    lit := span.Lit
+    prec := this.precedenceFrom(this.precedenceAPP, "APP", lit, minPrecedence)
+    if prec >= minPrecedence && prec < splitPrecedence && this.checkLeftOperand(spans, l, prec) {
+      if prec == minPrecedence {
+        return this.applicationSyntax(ambit, spans, prec)
+      }
+      splitLoc, splitPrecedence, splitPrecLeft, splitApp = l, prec, prec, true
+    }
+  }
+  for indexLR := 1; indexLR < l; indexLR++ {
+    if span := spans[indexLR]; span.Cat == "OP" {
+      lit := span.Lit
+      prec := this.precedenceFrom(this.precedenceAFB, "AFB", lit, minPrecedence)
+      if prec >= minPrecedence && prec < splitPrecedence {
+        if this.checkInfixCandidate(spans, indexLR, prec, prec+1) {
+          if prec == minPrecedence {
+            return &Syntax{ Cat: span.Cat, Lit: lit, Ambit: ambit, OpAmbit: span.Ambit, 
+                            Left: this.sparse(ambit.SubtractRight(span.Ambit), spans[:indexLR], prec+1),
+                            Right: this.sparse(ambit.SubtractLeft(span.Ambit), spans[indexLR+1:], prec) }
+          }
+          splitLoc, splitPrecedence, splitPrecLeft, splitPrecRight = indexLR, prec, prec+1, prec
+          splitMix, splitNonAssoc, splitApp = nil, false, false
+        }
+      }
+      prec = this.precedenceFrom(this.precedenceBFB, "BFB", lit, minPrecedence)
+      if prec >= minPrecedence && prec < splitPrecedence {
+        if this.checkInfixCandidate(spans, indexLR, prec+1, prec+1) {
+          if prec == minPrecedence {
+            return this.nonAssocSyntax(ambit, spans, indexLR, prec)
+          }
+          splitLoc, splitPrecedence, splitPrecLeft, splitPrecRight = indexLR, prec, prec+1, prec+1
+          splitMix, splitNonAssoc, splitApp = nil, true, false
+        }
+      }
+      if functors := this.mixfix[lit]; functors != nil {
+        prec := this.precedenceFrom(this.precedenceMIX, "MIX", lit, minPrecedence)
+        if prec >= minPrecedence && prec < splitPrecedence {
+          if locs := this.matchMixfix(spans, indexLR, functors); locs != nil &&
+               this.checkLeftOperand(spans, indexLR, prec+1) &&
+               this.checkRightOperand(spans, locs[len(locs)-1], prec) {
+            if prec == minPrecedence {
+              return this.mixfixSyntax(ambit, spans, locs, prec+1, prec)
+            }
+            splitLoc, splitPrecedence, splitPrecLeft, splitPrecRight = indexLR, prec, prec+1, prec
+            splitMix, splitNonAssoc, splitApp = locs, false, false
+          }
+        }
+      }
+    }
+    indexRL := l - indexLR
+    if span := spans[indexRL]; span.Cat == "OP" {
+      lit := span.Lit
+      prec := this.precedenceFrom(this.precedenceBFA, "BFA", lit, minPrecedence)
+      if prec >= minPrecedence && prec < splitPrecedence {
+        if this.checkInfixCandidate(spans, indexRL, prec+1, prec) {
+          if prec == minPrecedence {
+            return &Syntax{ Cat: span.Cat, Lit: lit, Ambit: ambit, OpAmbit: span.Ambit, 
+                            Left: this.sparse(ambit.SubtractRight(span.Ambit), spans[:indexRL], prec),
+                            Right: this.sparse(ambit.SubtractLeft(span.Ambit), spans[indexRL+1:], prec+1) }
+          }
+          splitLoc, splitPrecedence, splitPrecLeft, splitPrecRight = indexRL, prec, prec, prec+1
+          splitMix, splitNonAssoc, splitApp = nil, false, false
+        }
+      }
+    }
+  }
+  if splitApp {
+    return this.applicationSyntax(ambit, spans, splitPrecLeft)
+  }
+  if splitMix != nil {
+    return this.mixfixSyntax(ambit, spans, splitMix, splitPrecLeft, splitPrecRight)
+  }
+  if splitNonAssoc {
+    return this.nonAssocSyntax(ambit, spans, splitLoc, splitPrecedence)
+  }
+  if splitLoc >= 0 {
+    splitSpan := spans[splitLoc]
+    cat, lit := splitSpan.Cat, splitSpan.Lit
+    if cat == "WS" {
+      cat, lit = "JUXT", " "
+    }
+    if splitLoc == 0 {
+      if cat == "OP" {
+        return &Syntax{ Cat: cat, Lit: lit, Ambit: ambit, OpAmbit: splitSpan.Ambit,
+                        Left: &Syntax{ Ambit: splitSpan.Ambit.CollapseLeft() },
+                        Right: this.sparse(ambit.SubtractLeft(splitSpan.Ambit), spans[1:], splitPrecRight) }
+      } else {
+        return &Syntax{ Cat: "GLUE", Lit: "", Ambit: ambit, OpAmbit: splitSpan.Ambit.CollapseRight(),
+                        Left: this.sparse(splitSpan.Ambit, spans[:1], splitPrecLeft),
+                        Right: this.sparse(ambit.SubtractLeft(splitSpan.Ambit), spans[1:], splitPrecRight) }
+      }
+    } 
+    if splitLoc == l {
+      if cat == "OP" {
+        return &Syntax{ Cat: cat, Lit: lit, Ambit: ambit, OpAmbit: splitSpan.Ambit,
+                        Left: this.sparse(ambit.SubtractRight(splitSpan.Ambit), spans[:l], splitPrecLeft),
+                        Right: &Syntax{ Ambit: splitSpan.Ambit.CollapseRight() } }
+      } else {
+        return &Syntax{ Cat: "GLUE", Lit: "", Ambit: ambit, OpAmbit: splitSpan.Ambit.CollapseLeft(),
+                        Left: this.sparse(ambit.SubtractRight(splitSpan.Ambit), spans[:l], splitPrecLeft),
+                        Right: this.sparse(splitSpan.Ambit, spans[l:], splitPrecRight) }
+      }
+    }
+    return &Syntax{ Cat: cat, Lit: lit, Ambit: ambit, OpAmbit: splitSpan.Ambit, 
+                    Left: this.sparse(ambit.SubtractRight(splitSpan.Ambit), spans[:splitLoc], splitPrecLeft),
+                    Right: this.sparse(ambit.SubtractLeft(splitSpan.Ambit), spans[splitLoc+1:], splitPrecRight) }
+  }
+  firstSpan, secondSpan := spans[0], spans[1]
+  if secondSpan.Cat == "WS" {
+    return &Syntax{ Cat: "JUXT", Lit: " ", Ambit: ambit, OpAmbit: secondSpan.Ambit,
+                    Left: this.sparse(ambit.SubtractRight(secondSpan.Ambit), spans[:1], minPrecedence),
+                    Right: this.sparse(ambit.SubtractLeft(secondSpan.Ambit), spans[2:], minPrecedence) }
+  }
+  return &Syntax{ Cat: "GLUE", Lit: "", Ambit: ambit, OpAmbit: secondSpan.Ambit.CollapseLeft(),
+                  Left: this.sparse(ambit.SubtractRight(secondSpan.Ambit), spans[:1], minPrecedence),
+                  Right: this.sparse(ambit.SubtractLeft(firstSpan.Ambit), spans[1:], minPrecedence) }
+}
+
+func (this *referenceSparser) checkLeftwardJuxtapositionCandidate(spans []*spanT, index int, minPrecLeft int) bool {
+  indexRL := index-1
+  for indexRL >= 0 {
+    span := spans[indexRL]
+    if span.Cat != "WS" {
+      if span.Cat != "OP" {
+        return true
+      }
+      lit := span.Lit
+      if this.precedenceFrom(this.precedenceAFB, "AFB", lit, minPrecLeft) >= minPrecLeft ||
+         this.precedenceFrom(this.precedenceBFA, "BFA", lit, minPrecLeft) >= minPrecLeft ||
+         this.precedenceFrom(this.precedenceBFB, "BFB", lit, minPrecLeft) >= minPrecLeft ||
+         this.precedenceFrom(this.precedenceEFA, "EFA", lit, minPrecLeft) >= minPrecLeft ||
+         this.precedenceFrom(this.precedenceMIX, "MIX", lit, minPrecLeft) >= minPrecLeft {
+        return false
+      }
+      return true
+    }
+    indexRL--
+  }
+  return false
+}
+
+func (this *referenceSparser) checkRightwardJuxtapositionCandidate(spans []*spanT, index int, minPrecRight int) bool {
+  indexLR := index+1
+  l := len(spans)-1
+  for indexLR <= l {
+    span := spans[indexLR]
+    if span.Cat != "WS" {
+      if span.Cat != "OP" {
+        return true
+      }
+      lit := span.Lit
+      if this.precedenceFrom(this.precedenceAFB, "AFB", lit, minPrecRight) >= minPrecRight ||
+         this.precedenceFrom(this.precedenceBFA, "BFA", lit, minPrecRight) >= minPrecRight ||
+         this.precedenceFrom(this.precedenceBFB, "BFB", lit, minPrecRight) >= minPrecRight ||
+         this.precedenceFrom(this.precedenceAFE, "AFE", lit, minPrecRight) >= minPrecRight ||
+         this.precedenceFrom(this.precedenceMIX, "MIX", lit, minPrecRight) >= minPrecRight {
+        return false
+      }
+      return true
+    }
+    indexLR++
+  }
+  return false
+}
+
+func (this *referenceSparser) checkInfixCandidate(spans []*spanT, index int, minPrecLeft int, minPrecRight int) bool {  
+  return this.checkLeftOperand(spans, index, minPrecLeft) && this.checkRightOperand(spans, index, minPrecRight)
+}
+
+func (this *referenceSparser) checkLeftOperand(spans []*spanT, index int, minPrecLeft int) bool {
+  indexRL := index-1
+  for indexRL >= 0 {
+    span := spans[indexRL]
+    if span.Cat != "WS" {
+      if span.Cat != "OP" {
+        break
+      }
+      lit := span.Lit
+      prec := this.precedenceFrom(this.precedenceEFE, "EFE", lit, minPrecLeft)
+      if prec >= minPrecLeft {
+        break
+      }
+      prec = this.precedenceFrom(this.precedenceAFE, "AFE", lit, minPrecLeft)
+      if prec < minPrecLeft {
+        return false
+      }
+      minPrecLeft = prec
+    }
+    indexRL--
+  }
+  return indexRL >= 0
+}
+
+func (this *referenceSparser) checkRightOperand(spans []*spanT, index int, minPrecRight int) bool {
+  l := len(spans)-1
+  indexLR := index+1 
+  for indexLR <= l {
+    span := spans[indexLR]
+    if span.Cat != "WS" {
+      if span.Cat != "OP" {
+        break
+      }
+      lit := span.Lit
+      prec := this.precedenceFrom(this.precedenceEFE, "EFE", lit, minPrecRight)
+      if prec >= minPrecRight {
+        break
+      }
+      prec = this.precedenceFrom(this.precedenceEFA, "EFA", lit, minPrecRight)
+      if prec < minPrecRight {
+        return false
+      }
+      minPrecRight = prec
+    }
+    indexLR++
+  }
+  return indexLR <= l
+}
+
+// nonAssocSyntax returns the syntax tree for the non-associative operator located
+// at the given index, with an error for its right operand if the operator is
+// chained with the next operator of the same layer.
+func (this *referenceSparser) nonAssocSyntax(ambit *Ambit, spans []*spanT, index int, prec int) *Syntax {
+  span := spans[index]
+  left := this.sparse(ambit.SubtractRight(span.Ambit), spans[:index], prec+1)
+  for indexLR := index+1; indexLR < len(spans); indexLR++ {
+    next := spans[indexLR]
+    if next.Cat == "OP" && this.precedenceFrom(this.precedenceBFB, "BFB", next.Lit, prec) == prec &&
+         this.checkInfixCandidate(spans, indexLR, prec+1, prec+1) {
+      rightAmbit, _ := referenceTrimSpans(ambit.SubtractLeft(span.Ambit), spans[index+1:])
+      return &Syntax{ Cat: span.Cat, Lit: span.Lit, Ambit: ambit, OpAmbit: span.Ambit, Left: left,
+                      Right: &Syntax{ Cat: "ERR", Err: fmt.Sprintf("non-associative operators cannot be chained: '%s' after '%s': use parentheses", next.Lit, span.Lit),
+                                      Ambit: rightAmbit, OpAmbit: next.Ambit } }
+    }
+  }
+  return &Syntax{ Cat: span.Cat, Lit: span.Lit, Ambit: ambit, OpAmbit: span.Ambit, Left: left,
+                  Right: this.sparse(ambit.SubtractLeft(span.Ambit), spans[index+1:], prec+1) }
+}
+
+// matchMixfix returns the locations of all the functors of the mixfix operator
+// whose first functor is located at the given index, or nil if some functor is
+// missing. Nested occurrences of the same operator are skipped over.
+func (this *referenceSparser) matchMixfix(spans []*spanT, index int, functors []string) []int {
+  locs := make([]int, 1, len(functors))
+  locs[0] = index
+  first, last := functors[0], functors[len(functors)-1]
+  depth := 0
+  for indexLR := index+1; indexLR < len(spans); indexLR++ {
+    span := spans[indexLR]
+    if span.Cat != "OP" {
+      continue
+    }
+    lit := span.Lit
+    if lit == first {
+      depth++
+    } else if depth > 0 {
+      if lit == last {
+        depth--
+      }
+    } else if lit == functors[len(locs)] {
+      locs = append(locs, indexLR)
+      if len(locs) == len(functors) {
+        return locs
+      }
+    }
+  }
+  return nil
+}
+
+func (this *referenceSparser) mixfixSyntax(ambit *Ambit, spans []*spanT, locs []int, precLeft int, precRight int) *Syntax {
+  firstSpan, lastSpan := spans[locs[0]], spans[locs[len(locs)-1]]
+  lits := make([]string, len(locs))
+  mid := make([]*Syntax, len(locs)-1)
+  for index, loc := range locs {
+    lits[index] = spans[loc].Lit
+    if index > 0 {
+      prevSpan, span := spans[locs[index-1]], spans[loc]
+      mid[index-1] = this.sparse(ambit.SubtractLeft(prevSpan.Ambit).SubtractRight(span.Ambit),
+                                 spans[locs[index-1]+1:loc], precRight)
+    }
+  }
+  return &Syntax{ Cat: "OP", Lit: strings.Join(lits, " "), Ambit: ambit, OpAmbit: firstSpan.Ambit,
+                  Left: this.sparse(ambit.SubtractRight(firstSpan.Ambit), spans[:locs[0]], precLeft),
+                  Mid: mid,
+                  Right: this.sparse(ambit.SubtractLeft(lastSpan.Ambit), spans[locs[len(locs)-1]+1:], precRight) }
+}
+
+func (this *referenceSparser) applicationSyntax(ambit *Ambit, spans []*spanT, prec int) *Syntax {
+  l := len(spans)-1
+  span := spans[l]
+  return &Syntax{ Cat: "APP", Lit: span.Lit, Ambit: ambit, OpAmbit: span.Ambit,
+                  Left: this.sparse(ambit.SubtractRight(span.Ambit), spans[:l], prec),
+                  Right: this.sparse(span.SubAmbit, span.Children, this.precedenceB[span.Lit]) }
+}
+
+func referenceTrimSpans(ambit *Ambit, spans []*spanT) (*Ambit, []*spanT) {
+  return referenceTrimSpansLeft(referenceTrimSpansRight(ambit, spans))
+}
+
+func referenceTrimSpansLeft(ambit *Ambit, spans []*spanT) (*Ambit, []*spanT) {
+  for index, span := range spans {
+    if span.Cat != "WS" {
+      return ambit, spans[index:]
+    }
+    ambit = ambit.SubtractLeft(span.Ambit)
+  }
+  return ambit, nil
+}
+
+func referenceTrimSpansRight(ambit *Ambit, spans []*spanT) (*Ambit, []*spanT) {
+  for index := len(spans)-1; index >= 0; index-- {
+    span := spans[index]
+    if span.Cat != "WS" {
+      return ambit, spans[:index+1]
+    }
+    ambit = ambit.SubtractRight(span.Ambit)
+  }
+  return ambit, nil
+}
+
+func randomSparserSpec(rnd *rand.Rand) Spec {
+	spec := NewSpec().Lexical(DefaultScanner).Brackets("( )", "[ ]")
+	ops := []string{"+", "-", "*", "/", "^", "!", "~", "==", "<", ",", ";", "&", "|"}
+	patterns := []func(ops ...string) Spec{
+		spec.OperatorAFB, spec.OperatorBFA, spec.OperatorBFB,
+		spec.OperatorEFA, spec.OperatorAFE, spec.OperatorEFE,
+	}
+	rnd.Shuffle(len(ops), func(i, j int) { ops[i], ops[j] = ops[j], ops[i] })
+	layers := 0
+	var declared []func()
+	for len(ops) > 0 {
+		n := 1 + rnd.Intn(3)
+		if n > len(ops) {
+			n = len(ops)
+		}
+		layer := ops[:n]
+		ops = ops[n:]
+		for _, pattern := range rnd.Perm(len(patterns))[:1+rnd.Intn(2)] {
+			patterns[pattern](layer...).Level(fmt.Sprintf("L%d", layers))
+			if layers > 0 && rnd.Intn(4) == 0 {
+				spec.SameAs(fmt.Sprintf("L%d", rnd.Intn(layers)))
+			}
+			layers++
+			op, pattern := layer[rnd.Intn(len(layer))], patterns[pattern]
+			declared = append(declared, func() { pattern(op) })
+		}
+	}
+	for i := rnd.Intn(3); i > 0; i-- {
+		// share an operator across levels, at a new level above or below some level
+		declared[rnd.Intn(len(declared))]()
+		if ref := fmt.Sprintf("L%d", rnd.Intn(layers)); rnd.Intn(2) == 0 {
+			spec.Above(ref)
+		} else {
+			spec.Below(ref)
+		}
+	}
+	if rnd.Intn(2) == 0 {
+		spec.Application("( )")
+		if rnd.Intn(2) == 0 {
+			spec.SameAs(fmt.Sprintf("L%d", rnd.Intn(layers)))
+		}
+	}
+	if rnd.Intn(2) == 0 {
+		spec.OperatorMixfix("? :")
+	}
+	if rnd.Intn(2) == 0 {
+		spec.OperatorMixfix("@ %")
+	}
+	if rnd.Intn(2) == 0 {
+		spec.JuxtapositionLWA("f")
+	}
+	if rnd.Intn(2) == 0 {
+		spec.JuxtapositionAWL("g")
+	}
+	if rnd.Intn(2) == 0 {
+		spec.GlueLA("h")
+	}
+	if rnd.Intn(2) == 0 {
+		spec.GlueAL("k")
+	}
+	return spec
+}
+
+func randomSparserText(rnd *rand.Rand, buf *bytes.Buffer, depth int) {
+	tokens := []string{"a", "b", "f", "g", "h", "k", "1", "+", "-", "*", "/", "^", "!", "~", "==", "<",
+		",", ";", "&", "|", "?", ":", "@", "%"}
+	n := rnd.Intn(12)
+	for i := 0; i < n; i++ {
+		switch rnd.Intn(3) {
+		case 0:
+			buf.WriteString(" ")
+		case 1:
+			if i > 0 {
+				buf.WriteString("  ")
+			}
+		}
+		if depth < 3 && rnd.Intn(8) == 0 {
+			brackets := [][2]string{{"(", ")"}, {"[", "]"}}[rnd.Intn(2)]
+			buf.WriteString(brackets[0])
+			randomSparserText(rnd, buf, depth+1)
+			buf.WriteString(brackets[1])
+			continue
+		}
+		buf.WriteString(tokens[rnd.Intn(len(tokens))])
+	}
+}
+
+func TestSparserReference(t *testing.T) {
+
+	rnd := rand.New(rand.NewSource(1))
+
+	for specIndex := 0; specIndex < 100; specIndex++ {
+		lang, err := randomSparserSpec(rnd).Grammar("")
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		sparser := lang.Sparser().(*sparser)
+		reference := &referenceSparser{precedenceLevels: sparser.precedenceLevels}
+		for textIndex := 0; textIndex < 200; textIndex++ {
+			buf := new(bytes.Buffer)
+			randomSparserText(rnd, buf, 0)
+			source := &Source{Path: "tst", Text: buf.Bytes()}
+			ambit := source.FullAmbit()
+			res := sparser.Sparse(ambit).DumpToString(false)
+			tgt := reference.sparse(ambit, sparser.spanner.span(ambit), 1).DumpToString(false)
+			if textIndex%10 == 0 {
+				if explained, _ := sparser.Explain(ambit); explained.DumpToString(false) != res {
+					t.Logf("spec %d, text %q: explained:\n%s", specIndex, buf.String(), explained.DumpToString(false))
+					t.Fail()
+					return
+				}
+			}
+			if res != tgt {
+				t.Logf("spec %d, text %q:\n%s\nexpected:\n%s", specIndex, buf.String(), res, tgt)
+				t.Fail()
+				return
+			}
+		}
+	}
+}
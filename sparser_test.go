@@ -2,6 +2,7 @@ package dusl
 
 import (
 	"bytes"
+	"fmt"
 	"testing"
 )
 
@@ -109,3 +110,244 @@ func (1 + 2)
 		t.Fail()
 	}
 }
+
+func TestSparserMixfix(t *testing.T) {
+
+	lang, err := NewSpec().
+		Lexical(DefaultScanner).
+		OperatorBFA("+", "-").
+		OperatorBFA("<").
+		OperatorMixfix("? :").
+		Brackets("( )").
+		Grammar("")
+
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	sparser := lang.Sparser()
+
+	text := []byte(`a < b ? x + 1 : c ? d ? e : f : g`)
+	source := &Source{Path: "tst", Text: text}
+	ambit := source.FullAmbit()
+
+	tree := sparser.Sparse(ambit)
+
+	buf := new(bytes.Buffer)
+	tree.Dump(buf, "> ", false)
+	res := buf.String()
+	tgt := `> OP:? :::tst[0:33]
+>   OP:<::tst[0:5]
+>     ID:a::tst[0:1]
+>     ID:b::tst[4:5]
+>   OP:+::tst[8:13]
+>     ID:x::tst[8:9]
+>     NUM:1::tst[12:13]
+>   OP:? :::tst[16:33]
+>     ID:c::tst[16:17]
+>     OP:? :::tst[20:29]
+>       ID:d::tst[20:21]
+>       ID:e::tst[24:25]
+>       ID:f::tst[28:29]
+>     ID:g::tst[32:33]
+`
+	if res != tgt {
+		t.Log(res)
+		t.Fail()
+	}
+
+	text = []byte(`a ? b`)
+	source = &Source{Path: "tst", Text: text}
+	ambit = source.FullAmbit()
+
+	err = sparser.Sparse(ambit).ErrorN(-1)
+	if err == nil || err.Error() != "tst:1:2:3: unexpected: ?: incomplete mixfix operator\n" {
+		t.Log(err)
+		t.Fail()
+	}
+}
+
+func TestSparserNonAssociative(t *testing.T) {
+
+	lang, err := NewSpec().
+		Lexical(DefaultScanner).
+		OperatorBFA("+", "-").
+		OperatorBFB("<", "==").
+		OperatorBFA("&&").
+		Brackets("( )").
+		Grammar("")
+
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	sparser := lang.Sparser()
+
+	text := []byte(`a < b + 1 && (a < b) == c`)
+	source := &Source{Path: "tst", Text: text}
+
+	tree := sparser.Sparse(source.FullAmbit())
+
+	buf := new(bytes.Buffer)
+	tree.Dump(buf, "> ", false)
+	res := buf.String()
+	tgt := `> OP:&&::tst[0:25]
+>   OP:<::tst[0:9]
+>     ID:a::tst[0:1]
+>     OP:+::tst[4:9]
+>       ID:b::tst[4:5]
+>       NUM:1::tst[8:9]
+>   OP:==::tst[13:25]
+>     BB:( )::tst[13:20]
+>       OP:<::tst[14:19]
+>         ID:a::tst[14:15]
+>         ID:b::tst[18:19]
+>       :::tst[20:20]
+>     ID:c::tst[24:25]
+`
+	if res != tgt {
+		t.Log(res)
+		t.Fail()
+	}
+
+	text = []byte(`x && a < b == c`)
+	source = &Source{Path: "tst", Text: text}
+
+	tree = sparser.Sparse(source.FullAmbit())
+
+	buf.Reset()
+	tree.Dump(buf, "> ", false)
+	res = buf.String()
+	tgt = `> OP:&&::tst[0:15]
+>   ID:x::tst[0:1]
+>   OP:<::tst[5:15]
+>     ID:a::tst[5:6]
+>     ERR::non-associative operators cannot be chained: '==' after '<': use parentheses:tst[9:15]
+`
+	if res != tgt {
+		t.Log(res)
+		t.Fail()
+	}
+
+	err = tree.ErrorN(-1)
+	if err == nil || err.Error() != "tst:1:9:15: non-associative operators cannot be chained: '==' after '<': use parentheses\n" {
+		t.Log(err)
+		t.Fail()
+	}
+}
+
+func longListSparser(b testing.TB) (Sparser, *Ambit) {
+	lang, err := NewSpec().
+		Lexical(DefaultScanner).
+		OperatorBFA(";").
+		OperatorBFA(",").
+		OperatorBFA("+", "-").
+		OperatorEFA("-").
+		Brackets("( )").
+		Grammar("")
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString("(")
+	for index := 0; index < 2000; index++ {
+		if index > 0 {
+			buf.WriteString(", ")
+		}
+		fmt.Fprintf(buf, "x%d + -%d", index, index)
+	}
+	buf.WriteString(")")
+	for index := 0; index < 2000; index++ {
+		fmt.Fprintf(buf, " y%d", index)
+	}
+	source := &Source{Path: "tst", Text: buf.Bytes()}
+	return lang.Sparser(), source.FullAmbit()
+}
+
+func TestSparserLongList(t *testing.T) {
+	sparser, ambit := longListSparser(t)
+
+	tree := sparser.Sparse(ambit)
+
+	juxtapositions, node := 0, tree
+	for ; node.Cat == "JUXT"; node = node.Right {
+		juxtapositions++
+	}
+	commas, node := 0, tree.Left.Left
+	for ; node.Cat == "OP" && node.Lit == ","; node = node.Left {
+		commas++
+	}
+	if juxtapositions != 2000 || commas != 1999 {
+		t.Log(tree.DumpToString(false))
+		t.Fail()
+	}
+}
+
+func BenchmarkSparserLongList(b *testing.B) {
+	sparser, ambit := longListSparser(b)
+	b.ResetTimer()
+	for index := 0; index < b.N; index++ {
+		sparser.Sparse(ambit)
+	}
+}
+
+func TestSparserApplication(t *testing.T) {
+
+	lang, err := NewSpec().
+		Lexical(DefaultScanner).
+		Application("( )", "[ ]").
+		OperatorEFA("-").
+		OperatorBFA("+").
+		Brackets("( )", "[ ]").
+		Grammar("")
+
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	sparser := lang.Sparser()
+
+	tree := sparser.Sparse(AmbitFromString(`-f(x)(y)[z] + g (1) + (2)`))
+
+	res := tree.DumpToString(true)
+	tgt := `OP:+
+  OP:+
+    OP:-
+      :
+      APP:[ ]
+        APP:( )
+          APP:( )
+            ID:f
+            ID:x
+          ID:y
+        ID:z
+    APP:( )
+      ID:g
+      NUM:1
+  BB:( )
+    NUM:2
+    :
+`
+	if res != tgt {
+		t.Log(res)
+		t.Fail()
+	}
+
+	_, err = NewSpec().
+		Lexical(DefaultScanner).
+		Application("( )").
+		Grammar("")
+
+	if err == nil || locatedMessage(err) != "undeclared brackets in application layer: '( )'" {
+		t.Log(err)
+		t.Fail()
+	}
+}
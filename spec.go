@@ -1,9 +1,9 @@
 package dusl
 
 import (
+  "io"
   "strings"
-  "bytes"
-  "errors"
+  "reflect"
   "runtime"
   "fmt"
   //"os"
@@ -33,6 +33,13 @@ type Spec interface {
   // pattern leads to operators that behave as left associative operators. The new
   // operator layer will have lower precedence than all existing operator layers.
   OperatorBFA(ops ...string) Spec
+  // OperatorBFB adds an operator layer to the language consisting of a (number of)
+  // operator(s) with the brackets-functor-brackets binding pattern. This binding
+  // pattern leads to operators that behave as non-associative operators: chaining
+  // two operators of the same layer, as in: a < b < c, is reported as an error on
+  // the right operand of the first operator, which holds the rest of the chain. The
+  // new operator layer will have lower precedence than all existing operator layers.
+  OperatorBFB(ops ...string) Spec
   // OperatorEFA adds an operator layer to the language consisting of a (number of)
   // operator(s) with the empty-functor-argument binding pattern. This binding
   // pattern leads to operators that behave as prefix operators. The new
@@ -48,11 +55,30 @@ type Spec interface {
   // pattern leads to operators that behave as zero-ary operators. The new
   // operator layer will have lower precedence than all existing operator layers.
   OperatorEFE(ops ...string) Spec
+  // OperatorMixfix adds an operator layer to the language consisting of a (number of)
+  // mixfix operator(s). A mixfix operator is specified by writing its functor tokens
+  // separated by single blank spaces, for example: "? :". A mixfix operator with n
+  // functors takes n+1 arguments: one before the first functor, one in between each
+  // pair of consecutive functors and one after the last functor. The arguments in
+  // between the functors are delimited on both sides and may contain nested occurrences
+  // of the same operator. The outer arguments follow the argument-functor-brackets
+  // binding pattern which leads to operators that behave as right associative
+  // operators. The new operator layer will have lower precedence than all existing
+  // operator layers.
+  OperatorMixfix(ops ...string) Spec
   // Brackets adds a layer of explicit grouping to the language. The pairs should be
   // specified by writing the opening bracket token followed by a single blank space
   // followed by the closing bracket token. As a result, tokens that have spaces in
   // them are not specifyable as open or close brackets.
   Brackets(pairs ...string) Spec
+  // Application adds a layer of postfix brackets to the language, for call and index
+  // expressions such as f(x) and a[i]. Each pair must also be declared by means of
+  // Brackets. A bracket pair that directly follows an operand (possibly separated by
+  // whitespace) is parsed in the application (APP) category, the left child being the
+  // operand and the right child being the contents of the brackets. Applications
+  // behave as left associative postfix operators, such that f(x)(y)[z] chains as
+  // expected. The new layer will have lower precedence than all existing layers.
+  Application(pairs ...string) Spec
   // SequenceLabel introduces a label that can be used to label sequences, that is:
   // multi-sentence constituents in the grammar.
   SequenceLabel(lbl string, desc string) Spec
@@ -62,6 +88,17 @@ type Spec interface {
   // Label introduces an ordinary label that can be used to label intra-sentence
   // constituents.
   Label(lbl string, desc string) Spec
+  // Macro introduces a parameterised label, such as List(x, sep), that behaves as
+  // an ordinary label once it is instantiated with arguments in the grammar, for
+  // example: List(expr, ","). The rules of the macro are written with its declared
+  // parameters in the head: "List(x, sep) is> x or> x sep List(x, sep)". Every
+  // distinct instance is expanded by Grammar into an ordinary label (which is named
+  // after the instance) by replacing the parameters in the rules of the macro by the
+  // arguments. An argument is either a declared symbol, a nested instance or a
+  // string literal that stands for the grammar text it contains, such as an
+  // operator. The description of an instance is the given description in which every
+  // "{param}" is replaced by the description of the argument (or the quoted string).
+  Macro(lbl string, desc string, params ...string) Spec
   // Literal introduces a symbol that should be interpreted with its given lexical
   // category rather than being interpreted like a meta-symbol. For example: if we
   // want to use the identifier "myName" in the grammar as an
@@ -104,6 +141,47 @@ type Spec interface {
   // the latter will be more efficient for rules that have to match against a whole
   // bunch of operators.
   ShorthandOperator(op string, ops ...string) Spec
+  // Words flags the given operators as word operators, such as "and", "or" and "not".
+  // Word operators are not matched by prefix like other operators. Instead, a token
+  // that the lexical scanner scans as a whole (for example as an identifier) is
+  // reinterpreted as an operator iff its literal is a word operator. As a result word
+  // operators only match at token boundaries: "order" and "android" remain identifiers.
+  // Each word must be declared as an operator or mixfix functor in some layer.
+  Words(ops ...string) Spec
+  // Level names the most recently added layer. The name can be used to refer to the
+  // precedence level of this layer when positioning other layers by means of Above,
+  // Below or SameAs.
+  Level(name string) Spec
+  // Above moves the most recently added layer to a new precedence level directly
+  // above the precedence level of the given reference. The reference is either the
+  // name of a level or a literal (operator, bracket pair, identifier, mixfix
+  // operator or one of its functors) that has been declared in exactly one layer. This allows for inserting layers in between
+  // existing layers rather than only appending them with the lowest precedence.
+  Above(ref string) Spec
+  // Below moves the most recently added layer to a new precedence level directly
+  // below the precedence level of the given reference. See Above.
+  Below(ref string) Spec
+  // SameAs moves the most recently added layer to the precedence level of the given
+  // reference, such that the operators of both layers share one precedence level
+  // (possibly across different binding patterns). See Above. Note that a literal can
+  // be shared across precedence levels by declaring it in layers of the same binding
+  // pattern at different levels. The sparser then takes the literal at the lowest of
+  // its precedences that is allowed where it occurs: an operator declared at 12 and
+  // at 15 splits at 12 in general, and at 15 in the operand of an operator at 13 or
+  // 14. Brackets shared across levels sparse their contents at the lowest precedence.
+  SameAs(ref string) Spec
+  // Extend adds all the declarations (scanner, layers, symbols and word operators)
+  // and all the grammar rules of the base Lang to this spec, as if they were declared
+  // at this point. Rules given to Grammar for an inherited label add alternatives
+  // after the inherited alternatives, unless the label is overridden. Conflicting
+  // declarations are reported by Grammar as usual. The macro instances of the base
+  // are inherited as ordinary labels, but the rules of its macros are not: to
+  // instantiate an inherited macro with new arguments, its rules must be repeated in
+  // the grammar of this spec.
+  Extend(base Lang) Spec
+  // Override discards the inherited grammar rules of the given labels (see Extend),
+  // such that only the rules given to Grammar apply.
+  Override(lbls ...string) Spec
   // Grammar always constitutes the final call in the fluent API that is the Spec
   // interface. It introduces a single string literal (usually specified using go's
   // multiline string syntax: `...`) that contains the grammar rules for the top
@@ -113,7 +191,29 @@ type Spec interface {
   // It is recommended to unit-test all these stages separately in order
   // to build up complexity slowly and get fail-early behaviour which gives you much
   // better feedback when something in your lanugage is not working as it should.
+  // Directive comments customize the errors reported by the tracer: the line
+  // "#! error: msg" directly above a rule (possibly among other comment lines) sets
+  // the error message that is reported when one of the alternatives on the line of
+  // the rule is the closest match of a failing node, "#! error X: msg" sets the error
+  // message of the label X that is reported when none of its alternatives comes
+  // close, and "#! reject: msg" turns the alternatives on the line of the rule into
+  // error productions: instead of being traced, a node that matches one of them is
+  // reported with the given message, for example "= in a condition, use ==".
+  // Errors are located in the Go file that calls Grammar, assuming the grammar is a
+  // single multiline string literal that ends on the line of the call. Use
+  // GrammarAt or GrammarFromSource if that is not the case. The errors in the
+  // grammar rules are returned as a SummaryError of LocatedErrors, see Errors. An
+  // error in the declarations is a LocatedError too, located at the call of the
+  // fluent interface that made the declaration (or at the declaration of the spec
+  // file, see LoadSpec).
   Grammar(grammar string) (Lang, error)
+  // GrammarAt is Grammar for grammar rules that start on the given line (counting
+  // from 1) of the file at the given path, errors are located accordingly.
+  GrammarAt(path string, line int, grammar string) (Lang, error)
+  // GrammarFromSource is Grammar for grammar rules that are read from the given
+  // source, for example a separate grammar file that is embedded into the program.
+  // Errors are located in the source.
+  GrammarFromSource(src *Source) (Lang, error)
 }
 
 // A Lang object represents a fully specified DUSL dialect, it contains the Tokenizer,
@@ -125,18 +225,126 @@ type Lang interface {
   Tokenizer() Tokenizer
   Sparser() Sparser
   Tracer() Tracer
+  // DumpPrecedence writes the final precedence table, from the highest to the
+  // lowest precedence level, grouped per binding pattern.
+  DumpPrecedence(out io.Writer, prfx string)
+  // Encode writes the Lang in a compact binary form, see DecodeLang.
+  Encode(out io.Writer) error
+  // Extend returns a new Spec that inherits all the declarations and grammar rules of
+  // this Lang, it is short for NewSpec().Extend(lang).
+  Extend() Spec
+  // Lint analyzes the grammar and reports: alternatives that are subsumed by an
+  // earlier alternative of the same label, labels without rules, labels that are
+  // unreachable from the given root labels, labels that can never be traced to
+  // completion and categories that are never produced by the scanner. A subsumed
+  // alternative is never applied by the Tracer, which commits to the first
+  // alternative that matches the shape of a node, but it is by a backtracking
+  // tracer (see Tracer.Backtracking) when the sub-traces of the earlier alternative
+  // fail. If no roots are given every label that is not referenced by any rule is
+  // taken to be a root. The issues are reported in a deterministic order, those that
+  // concern a rule or a label with rules are LocatedErrors.
+  Lint(roots ...string) []error
+  // GenerateGo writes the Go source code of a typed abstract syntax tree for the
+  // grammar, in the given package and importing this package by the given import path.
+  // Every label with rules becomes an interface, every rule alternative becomes a
+  // struct that implements it, with a field per placeholder and a constructor that
+  // takes the Ambit of the node, and every label gets a function that builds its node
+  // from a trace: ParseX(trace *dusl.Trace) (X, error), which returns the errors of
+  // a trace with errors instead (see Trace.ErrorN). Structs and fields are named after
+  // alternative and placeholder names where they are given (see Trace.Alt and
+  // Trace.Sub), list and optional placeholders become slices and nil-able fields.
+  // Names that would make the generated code invalid are reported rather than
+  // disambiguated: structs or functions that get the same name, such as an unnamed
+  // second alternative and an alternative named "alt1", and fields or constructor
+  // parameters that conflict with each other or with the Ambit method and parameter.
+  GenerateGo(out io.Writer, pkg string, duslImport string) error
+  // DocMarkdown writes the documentation of the language in Markdown: the lexical
+  // categories with their descriptions, the precedence table of the operators and
+  // brackets by binding pattern and, for each label, its description, the comment
+  // lines directly above its rules in the grammar and its rules. If the SVG prefix is
+  // not empty, the railroad diagram of each label is referenced as the image at the
+  // prefix followed by DocFileName(lbl) and ".svg", see DocSVG.
+  DocMarkdown(out io.Writer, title string, svgPrefix string) error
+  // DocHTML writes the documentation of the language as a standalone HTML page, like
+  // DocMarkdown but with the railroad diagrams inlined.
+  DocHTML(out io.Writer, title string) error
+  // DocSVG writes the railroad diagram of the given label as a standalone SVG image.
+  // Multi-sentence rules are drawn with their indented bodies below the head.
+  DocSVG(out io.Writer, lbl string) error
+  // Categories returns the declared lexical categories, in order of declaration.
+  Categories() []*CategoryInfo
+  // Layers returns the declared layers, from the highest to the lowest precedence
+  // level, in order of declaration within a level.
+  Layers() []*LayerInfo
+  // Brackets returns the declared bracket pairs, from the highest to the lowest
+  // precedence level.
+  Brackets() []*BracketsInfo
+  // Literals returns the declared literals, including the literals of juxtaposition
+  // and glue layers, in order of declaration.
+  Literals() []*LiteralInfo
+  // Labels returns the declared labels in order of declaration, followed by the
+  // instances of macros in lexical order. The hidden labels of list and optional
+  // placeholders are left out, they are available by means of Label.
+  Labels() []*LabelInfo
+  // Label returns the given label with its rule alternatives, or nil if there is no
+  // such label. The returned information is a copy: changing it does not affect this
+  // Lang.
+  Label(lbl string) *LabelInfo
 }
 
 type spec struct {
   scanner Scanner
   layers []*specLayer
   symbols []*specSymbol
+  words []string
+  wordLocs []specLoc // the locations of the words, see specLoc
+  overrideLocs []specLoc // the locations of the overrides
+  inherited map[string][]*templateT
+  inheritedDescs map[string]string
+  inheritedDocs map[string]string
+  inheritedMessages map[string]string
+  inheritedMacros map[string]bool
+  overrides []string
+  err error
+  at specLoc // the location of the declaration that is being made, see specLoc
 }
 
 type lang struct {
   tokenizer Tokenizer
   sparser Sparser
   tracer Tracer
+  scanner Scanner
+  prfx *prfxTree
+  words map[string]bool
+  precedence *precedenceLevels
+  templates map[string][]*templateT
+  descriptions map[string]string
+  docs map[string]string // comments directly above the rules of labels, see DocMarkdown
+  messages map[string]string // error messages of labels, see Grammar
+  levels []*precedenceLevelT
+  labels []string // declared labels, in order of declaration
+  categories []string // declared categories, in order of declaration
+  decls *spec // declarations this Lang was compiled from, see Extend
+}
+
+// newLang assembles the stages of a Lang from its compiled parts: the user scanner
+// (possibly nil), the prefix tree of operators and brackets, the word operators,
+// the precedence tables, the tracer templates and label descriptions and the
+// resolved precedence levels.
+func newLang(scanner Scanner, prfx *prfxTree, words map[string]bool, precedence *precedenceLevels,
+             templates map[string][]*templateT, descriptions map[string]string,
+             messages map[string]string, levels []*precedenceLevelT) *lang {
+  var fullScanner Scanner = prfx
+  if scanner != nil {
+    fullScanner = &seqScanner{ master: prfx, slave: scanner }
+  }
+  tokenizer := newTokenizer(fullScanner, words)
+  spanner := newSpanner(tokenizer, precedence.precedenceB)
+  sparser := newSparser(spanner, precedence)
+  tracer := newTracer(sparser, templates, descriptions, messages)
+  return &lang{ tokenizer: tokenizer, sparser: sparser, tracer: tracer,
+                scanner: scanner, prfx: prfx, words: words, precedence: precedence,
+                templates: templates, descriptions: descriptions, messages: messages, levels: levels }
 }
 
 func (this *lang) Tokenizer() Tokenizer {
@@ -151,9 +359,61 @@ func (this *lang) Tracer() Tracer {
   return this.tracer
 }
 
+func (this *lang) Extend() Spec {
+  return NewSpec().Extend(this)
+}
+
 type specLayer struct {
   pattern string
   args []string
+  name string
+  rel string
+  ref string
+  at specLoc
+  nameAt specLoc
+  relAt specLoc
+}
+
+// A specLoc locates a declaration by the ambit of the declaration in a spec file (see
+// LoadSpec), or otherwise by the location of the call of the fluent Spec interface
+// that made it (see here).
+type specLoc struct {
+  ambit *Ambit
+  loc string // the location if there is no ambit
+}
+
+// errorf returns an error with the given message, located at the declaration.
+func (this specLoc) errorf(format string, args ...interface{}) error {
+  return &ambitError{ ambit: this.ambit, loc: this.loc, msg: fmt.Sprintf(format, args...) }
+}
+
+// location returns the location of the declaration, see Ambit.Location.
+func (this specLoc) location() string {
+  if this.ambit == nil {
+    return this.loc
+  }
+  return this.ambit.Location()
+}
+
+// specPackage is the prefix of the names of the functions of this package.
+var specPackage = reflect.TypeOf(spec{}).PkgPath() + "."
+
+// here returns the location of the declaration that is being made: the declaration
+// of the spec file that is being loaded, or otherwise the call of the fluent Spec
+// interface, that is, the innermost caller outside of this package or in one of its
+// tests.
+func (this *spec) here() specLoc {
+  if this.at.ambit != nil {
+    return this.at
+  }
+  pcs := make([]uintptr, 32)
+  frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+  for {
+    frame, more := frames.Next()
+    if !strings.HasPrefix(frame.Function, specPackage) || strings.HasSuffix(frame.File, "_test.go") || !more {
+      return specLoc{ loc: fmt.Sprintf("%s:%d", frame.File, frame.Line) }
+    }
+  }
 }
 
 const (
@@ -163,6 +423,7 @@ const (
   spec_Literal
   spec_Category
   spec_ShorthandOperator
+  spec_Macro
 )
 
 type specSymbol struct {
@@ -173,6 +434,8 @@ type specSymbol struct {
   lit string
   desc string
   ops []string
+  params []string
+  at specLoc
 }
 
 func (this *specSymbol) typName() string {
@@ -187,10 +450,16 @@ func (this *specSymbol) typName() string {
     return "literal"
   case spec_Category:
     return "category"
+  case spec_Macro:
+    return "macro"
   }
   return "<<<missing typName>>>"
 }
 
+// operatorPatterns lists the binding patterns of the operator layers, mixfix
+// operators are kept apart.
+var operatorPatterns = []string{ "AFE", "EFA", "AFB", "BFA", "BFB", "EFE" }
+
 type precedenceLevels struct {
   precedenceB map[string]int
   precedenceEFE map[string]int
@@ -198,10 +467,18 @@ type precedenceLevels struct {
   precedenceAFE map[string]int
   precedenceAFB map[string]int
   precedenceBFA map[string]int
+  precedenceBFB map[string]int
   precedenceLWA map[string]int
   precedenceAWL map[string]int
   precedenceLA map[string]int
   precedenceAL map[string]int
+  precedenceMIX map[string]int
+  precedenceAPP map[string]int
+  mixfix map[string][]string
+  // shared holds per binding pattern the precedences, in ascending order, of the
+  // literals that are declared at more than one precedence level. The tables above
+  // hold the lowest of these.
+  shared map[string]map[string][]int
 }
 
 func NewSpec() Spec {
@@ -218,7 +495,16 @@ func (this *spec) Lexical(scanner Scanner) Spec {
 }
 
 func (this *spec) ShorthandOperator(op string, ops ...string) Spec {
-  this.symbols = append(this.symbols, &specSymbol{ typ: spec_ShorthandOperator, symb: op, ops: ops })
+  this.symbols = append(this.symbols, &specSymbol{ typ: spec_ShorthandOperator, symb: op, ops: ops, at: this.here() })
+  return this
+}
+
+func (this *spec) Words(ops ...string) Spec {
+  at := this.here()
+  for _, op := range ops {
+    this.words = append(this.words, op)
+    this.wordLocs = append(this.wordLocs, at)
+  }
   return this
 }
 
@@ -230,6 +516,10 @@ func (this *spec) OperatorBFA(ops ...string) Spec {
   return this.layer("BFA", ops)
 }
 
+func (this *spec) OperatorBFB(ops ...string) Spec {
+  return this.layer("BFB", ops)
+}
+
 func (this *spec) OperatorEFA(ops ...string) Spec {
   return this.layer("EFA", ops)
 }
@@ -242,6 +532,10 @@ func (this *spec) OperatorEFE(ops ...string) Spec {
   return this.layer("EFE", ops)
 }
 
+func (this *spec) OperatorMixfix(ops ...string) Spec {
+  return this.layer("MIX", ops)
+}
+
 func (this *spec) JuxtapositionLWA(ids ...string) Spec {
   for _, id := range ids {
     if !strings.ContainsAny(id, " ") { // otherwise it's a bracket
@@ -282,8 +576,128 @@ func (this *spec) Brackets(ops ...string) Spec {
   return this.layer("B", ops)
 }
 
+func (this *spec) Application(pairs ...string) Spec {
+  return this.layer("APP", pairs)
+}
+
 func (this *spec) layer(pattern string, args []string) Spec {
-  this.layers = append(this.layers, &specLayer{ pattern: pattern, args: args })
+  this.layers = append(this.layers, &specLayer{ pattern: pattern, args: args, at: this.here() })
+  return this
+}
+
+func (this *spec) Level(name string) Spec {
+  at := this.here()
+  if len(this.layers) == 0 {
+    return this.fail(at.errorf("level name without preceding layer: '%s'", name))
+  }
+  layer := this.layers[len(this.layers)-1]
+  layer.name, layer.nameAt = name, at
+  return this
+}
+
+func (this *spec) Above(ref string) Spec {
+  return this.position("above", ref)
+}
+
+func (this *spec) Below(ref string) Spec {
+  return this.position("below", ref)
+}
+
+func (this *spec) SameAs(ref string) Spec {
+  return this.position("same as", ref)
+}
+
+func (this *spec) position(rel string, ref string) Spec {
+  at := this.here()
+  if len(this.layers) == 0 {
+    return this.fail(at.errorf("%s '%s' without preceding layer", rel, ref))
+  }
+  layer := this.layers[len(this.layers)-1]
+  if layer.rel != "" {
+    return this.fail(at.errorf("layer positioned twice: %s '%s' and %s '%s'", layer.rel, layer.ref, rel, ref))
+  }
+  layer.rel, layer.ref, layer.relAt = rel, ref, at
+  return this
+}
+
+func (this *spec) Extend(base Lang) Spec {
+  lang := base.(*lang)
+  decls := lang.decls
+  if decls.scanner != nil {
+    this.Lexical(decls.scanner)
+  }
+  for _, layer := range decls.layers {
+    copied := *layer
+    this.layers = append(this.layers, &copied)
+  }
+  this.symbols = append(this.symbols, decls.symbols...)
+  this.words = append(this.words, decls.words...)
+  this.wordLocs = append(this.wordLocs, decls.wordLocs...)
+  for _, symbol := range decls.symbols {
+    if symbol.typ == spec_Macro {
+      if this.inheritedMacros == nil {
+        this.inheritedMacros = make(map[string]bool)
+      }
+      this.inheritedMacros[symbol.symb] = true
+    }
+  }
+  if this.inherited == nil {
+    this.inherited = make(map[string][]*templateT, len(lang.templates))
+  }
+  for lbl, templates := range lang.templates {
+    this.inherited[lbl] = append(this.inherited[lbl], templates...)
+  }
+  if this.inheritedDescs == nil {
+    this.inheritedDescs = make(map[string]string, len(lang.descriptions))
+  }
+  for lbl, desc := range lang.descriptions {
+    this.inheritedDescs[lbl] = desc
+  }
+  if this.inheritedDocs == nil {
+    this.inheritedDocs = make(map[string]string, len(lang.docs))
+  }
+  for lbl, doc := range lang.docs {
+    this.inheritedDocs[lbl] = doc
+  }
+  if this.inheritedMessages == nil {
+    this.inheritedMessages = make(map[string]string, len(lang.messages))
+  }
+  for lbl, msg := range lang.messages {
+    this.inheritedMessages[lbl] = msg
+  }
+  return this
+}
+
+func (this *spec) Override(lbls ...string) Spec {
+  at := this.here()
+  for _, lbl := range lbls {
+    this.overrides = append(this.overrides, lbl)
+    this.overrideLocs = append(this.overrideLocs, at)
+  }
+  return this
+}
+
+// declarations returns a copy of the declarations of this spec, without the
+// inherited rules.
+func (this *spec) declarations() *spec {
+  decls := &spec{ scanner: this.scanner,
+                  layers: make([]*specLayer, len(this.layers)),
+                  symbols: append([]*specSymbol(nil), this.symbols...),
+                  words: append([]string(nil), this.words...),
+                  wordLocs: append([]specLoc(nil), this.wordLocs...) }
+  for index, layer := range this.layers {
+    copied := *layer
+    decls.layers[index] = &copied
+  }
+  return decls
+}
+
+// fail records the first error encountered in the fluent API, the error is
+// reported by Grammar.
+func (this *spec) fail(err error) Spec {
+  if this.err == nil {
+    this.err = err
+  }
   return this
 }
 
@@ -310,8 +724,14 @@ func (this *spec) Label(lbl string, desc string) Spec {
   return this.symbol(spec_Label, lbl, lbl, "", "", desc)
 }
 
+func (this *spec) Macro(lbl string, desc string, params ...string) Spec {
+  this.symbol(spec_Macro, lbl, lbl, "", "", desc)
+  this.symbols[len(this.symbols)-1].params = params
+  return this
+}
+
 func (this *spec) symbol(typ int, symb, lbl string, cat string, lit string, desc string) Spec {
-  this.symbols = append(this.symbols, &specSymbol{ typ: typ, symb: symb, lbl: lbl, cat: cat, lit: lit, desc: desc })
+  this.symbols = append(this.symbols, &specSymbol{ typ: typ, symb: symb, lbl: lbl, cat: cat, lit: lit, desc: desc, at: this.here() })
   return this
 }
 
@@ -331,23 +751,68 @@ func (this *spec) scan(lit string) string {
 }
 
 func (this *spec) Grammar(grammar string) (Lang, error) {
+  path, line := grammarCaller(grammar)
+  return this.GrammarAt(path, line, grammar)
+}
+
+// grammarCaller returns the location of the given grammar rules in the Go file that
+// calls Grammar on the caller of grammarCaller, assuming the grammar is a single
+// multiline string literal that ends on the line of the call.
+func grammarCaller(grammar string) (string, int) {
+  _, path, lastLine, _ := runtime.Caller(2)
+  return path, lastLine-strings.Count(grammar, "\n")
+}
+
+func (this *spec) GrammarAt(path string, line int, grammar string) (Lang, error) {
+  return this.grammarFromSource(&Source{ Path: path, LineOffset: line-1, Text: []byte(grammar) })
+}
+
+func (this *spec) GrammarFromSource(src *Source) (Lang, error) {
+  return this.grammarFromSource(src)
+}
+
+// grammarFromSource is Grammar for grammar rules that are read from the given source.
+func (this *spec) grammarFromSource(grammarSource *Source) (Lang, error) {
   
+  if this.err != nil {
+    return nil, this.err
+  }
+
+  levels, err := resolvePrecedenceLevels(this.layers)
+  if err != nil {
+    return nil, err
+  }
+
   precMap := make(map[string]map[string]int, 16)
-  layers := this.layers
-  l := len(layers)-1
-  for i := 0; i <= l; i++ {
-    layer := layers[i]
-    precedence := 10 + (l-i)
-    pattMap := precMap[layer.pattern]
-    if pattMap == nil {
-      pattMap = make(map[string]int, 8*len(layer.args))
-      precMap[layer.pattern] = pattMap
-    }
-    for _, arg := range layer.args {
-      if pattMap[arg] != 0 {
-        return nil, fmt.Errorf("double declaration of %s identifier/operator/bracket: '%s'", layer.pattern, arg)
+  shared := make(map[string]map[string][]int, 2)
+  origins := make(map[string]map[string]specLoc, 16) // the locations of the layers that declare the literals, by pattern
+  for _, level := range levels {
+    for _, layer := range level.layers {
+      pattMap := precMap[layer.pattern]
+      if pattMap == nil {
+        pattMap = make(map[string]int, 8*len(layer.args))
+        precMap[layer.pattern] = pattMap
+        origins[layer.pattern] = make(map[string]specLoc, 8*len(layer.args))
+      }
+      for _, arg := range layer.args {
+        prec := pattMap[arg]
+        if prec == level.precedence {
+          return nil, layer.at.errorf("double declaration of %s identifier/operator/bracket: '%s'", layer.pattern, arg)
+        }
+        if prec != 0 {
+          // levels are ordered from the highest to the lowest precedence
+          if shared[layer.pattern] == nil {
+            shared[layer.pattern] = make(map[string][]int, 2)
+          }
+          precs := shared[layer.pattern][arg]
+          if precs == nil {
+            precs = []int{ prec }
+          }
+          shared[layer.pattern][arg] = append([]int{ level.precedence }, precs...)
+        }
+        pattMap[arg] = level.precedence
+        origins[layer.pattern][arg] = layer.at
       }
-      pattMap[arg] = precedence
     }
   }
 
@@ -369,41 +834,91 @@ func (this *spec) Grammar(grammar string) (Lang, error) {
                                slave: &seqScanner{ master: metaSymbolScanner, slave: this.scanner } }
   }
   
-  for _, patt := range []string{ "AFE", "EFA", "AFB", "BFA", "EFE" } {
+  words := make(map[string]bool, len(this.words))
+  for index, word := range this.words {
+    if words[word] {
+      return nil, this.wordLocs[index].errorf("double declaration of word operator: '%s'", word)
+    }
+    words[word] = true
+  }
+
+  for _, patt := range operatorPatterns {
     for op, _ := range precMap[patt] {
+      if words[op] {
+        continue
+      }
       prfxScanner.add("OP", op)
       prfxMetaScanner.add("OP", op)
     }
   }
 
+  precedenceMIX := make(map[string]int, 2*len(precMap["MIX"]))
+  mixfix := make(map[string][]string, len(precMap["MIX"]))
+  sharedMIX := shared["MIX"]
+  delete(shared, "MIX")
+  for ops, prec := range precMap["MIX"] {
+    at := origins["MIX"][ops]
+    functors := strings.Split(ops, " ")
+    if len(functors) < 2 {
+      return nil, at.errorf("expected two or more functors separated by blank space: '%s'", ops)
+    }
+    for _, functor := range functors {
+      if functor == "" {
+        return nil, at.errorf("expected functors separated by single blank space: '%s'", ops)
+      }
+      if precedenceMIX[functor] != 0 {
+        return nil, at.errorf("double declaration of MIX functor: '%s'", functor)
+      }
+      for _, patt := range operatorPatterns {
+        if precMap[patt][functor] != 0 {
+          return nil, at.errorf("MIX functor conflicts with %s operator: '%s'", patt, functor)
+        }
+      }
+      precedenceMIX[functor] = prec
+      if precs := sharedMIX[ops]; precs != nil {
+        if shared["MIX"] == nil {
+          shared["MIX"] = make(map[string][]int, 2*len(sharedMIX))
+        }
+        shared["MIX"][functor] = precs
+      }
+      if words[functor] {
+        continue
+      }
+      prfxScanner.add("OP", functor)
+      prfxMetaScanner.add("OP", functor)
+    }
+    mixfix[functors[0]] = functors
+  }
+
   for brs, _ := range precMap["B"] {
+    at := origins["B"][brs]
     parts := strings.Split(brs, " ")
     if len(parts) < 2 {
-      return nil, fmt.Errorf("expected pair of brackets separated by blank space: '%s'", brs)
+      return nil, at.errorf("expected pair of brackets separated by blank space: '%s'", brs)
     }
     if len(parts) > 2 {
-      return nil, fmt.Errorf("expected pair of brackets separated by single blank space: '%s'", brs)
+      return nil, at.errorf("expected pair of brackets separated by single blank space: '%s'", brs)
     }
     ob, cb := parts[0], parts[1]
     obExisting := prfxScanner.lookup(ob)
     if obExisting == "OP" {
-      return nil, fmt.Errorf("declared open bracket conflicts with declared operator: '%s'", ob)
+      return nil, at.errorf("declared open bracket conflicts with declared operator: '%s'", ob)
     }
     if obExisting == "CB" {
-      return nil, fmt.Errorf("declared open bracket conflicts with declared close bracket: '%s'", ob)
+      return nil, at.errorf("declared open bracket conflicts with declared close bracket: '%s'", ob)
     }
     if obExisting != "" {
-      return nil, fmt.Errorf("double declaration of open bracket: '%s'", ob)
+      return nil, at.errorf("double declaration of open bracket: '%s'", ob)
     }
     cbExisting := prfxScanner.lookup(cb)
     if cbExisting == "OP" {
-      return nil, fmt.Errorf("declared close bracket conflicts with declared operator: '%s'", cb)
+      return nil, at.errorf("declared close bracket conflicts with declared operator: '%s'", cb)
     }
     if cbExisting == "CB" {
-      return nil, fmt.Errorf("declared close bracket conflicts with declared open bracket: '%s'", cb)
+      return nil, at.errorf("declared close bracket conflicts with declared open bracket: '%s'", cb)
     }
     if cbExisting != "" {
-      return nil, fmt.Errorf("double declaration of close bracket: '%s'", cb)
+      return nil, at.errorf("double declaration of close bracket: '%s'", cb)
     }
     prfxScanner.add("OB", ob)
     prfxScanner.add("CB", cb)
@@ -411,28 +926,43 @@ func (this *spec) Grammar(grammar string) (Lang, error) {
     prfxMetaScanner.add("CB", cb)
   }
 
-  if prfxScanner.lookup("is>") != "" {
-    return nil, fmt.Errorf("conflicting declaration of meta operator: 'is>'")
+  for index, word := range this.words {
+    at := this.wordLocs[index]
+    declared := precedenceMIX[word] != 0
+    for _, patt := range operatorPatterns {
+      declared = declared || precMap[patt][word] != 0
+    }
+    if !declared {
+      return nil, at.errorf("undeclared word operator: '%s'", word)
+    }
+    if tokens := newTokenizer(scanner, nil).Tokenize(AmbitFromString(word)); len(tokens) != 1 || tokens[0].Cat == "ERR" {
+      return nil, at.errorf("word operator is not scanned as a single token: '%s'", word)
+    }
+    if prfxScanner.lookup(word) != "" {
+      return nil, at.errorf("word operator conflicts with operator/bracket: '%s'", word)
+    }
   }
-  if prfxScanner.lookup("or>") != "" {
-    return nil, fmt.Errorf("conflicting declaration of meta operator: 'or>'")
+
+  for brs, _ := range precMap["APP"] {
+    if precMap["B"][brs] == 0 {
+      return nil, origins["APP"][brs].errorf("undeclared brackets in application layer: '%s'", brs)
+    }
   }
-  if prfxScanner.lookup("<empty") != "" {
-    return nil, fmt.Errorf("conflicting declaration of meta operator: '<empty'")
+
+  for _, meta := range []string{ "is>", "or>", "<empty" } {
+    if prfxScanner.lookup(meta) != "" {
+      return nil, declaredAt(origins, meta).errorf("conflicting declaration of meta operator: '%s'", meta)
+    }
   }
 
   prfxMetaScanner.add("OP", "is>")
   prfxMetaScanner.add("OP", "or>")
   prfxMetaScanner.add("OP", "<empty")
 
-  if precMap["AFB"] == nil {
-    precMap["AFB"] = make(map[string]int, 2)
-  }
-  if precMap["AFE"] == nil {
-    precMap["AFE"] = make(map[string]int, 2)
-  }
-  if precMap["EFE"] == nil {
-    precMap["EFE"] = make(map[string]int, 2)
+  for _, patt := range operatorPatterns {
+    if precMap[patt] == nil {
+      precMap[patt] = make(map[string]int, 2)
+    }
   }
   
   precMap["AFB"]["is>"] = 1
@@ -448,10 +978,15 @@ func (this *spec) Grammar(grammar string) (Lang, error) {
     precedenceAFE: precMap["AFE"],
     precedenceAFB: precMap["AFB"],
     precedenceBFA: precMap["BFA"],
+    precedenceBFB: precMap["BFB"],
     precedenceLWA: precMap["LWA"],
     precedenceAWL: precMap["AWL"],
     precedenceLA: precMap["LA"],
     precedenceAL: precMap["AL"],
+    precedenceMIX: precedenceMIX,
+    precedenceAPP: precMap["APP"],
+    mixfix: mixfix,
+    shared: shared,
   }
 
   symbolTable := make(map[string]*specSymbol, len(this.symbols))
@@ -466,10 +1001,10 @@ func (this *spec) Grammar(grammar string) (Lang, error) {
     symb := symbol.symb
     trimmedSymb := strings.TrimSpace(symb)
     if trimmedSymb == "" {
-      return nil, fmt.Errorf("cannot declare empty string as %s: '%s'", symbol.typName(), symb)
+      return nil, symbol.at.errorf("cannot declare empty string as %s: '%s'", symbol.typName(), symb)
     }
     if trimmedSymb != symb {
-      return nil, fmt.Errorf("leading/trailing whitespace in %s: '%s'", symbol.typName(), symb)
+      return nil, symbol.at.errorf("leading/trailing whitespace in %s: '%s'", symbol.typName(), symb)
     }
     if symbol.typ != spec_ShorthandOperator {
       for i, c := range symb {
@@ -479,24 +1014,27 @@ func (this *spec) Grammar(grammar string) (Lang, error) {
         if i > 0 && (c >= '0' && c <= '9') {
           continue
         }
-        return nil, fmt.Errorf("unexpected symbol in %s: '%s***HERE***%s'",
+        return nil, symbol.at.errorf("unexpected symbol in %s: '%s***HERE***%s'",
                                symbol.typName(), symb[:i], symb[i:])
       }
     }
     for reservedSymb, reservedSymbName := range reserved {
       if symb == reservedSymb {
-        return nil, fmt.Errorf("%s conflicts with reserved %s: '%s'", symbol.typName(), reservedSymbName, symb)
+        return nil, symbol.at.errorf("%s conflicts with reserved %s: '%s'", symbol.typName(), reservedSymbName, symb)
       }
     }
     if prfxScanner.lookup(symb) != "" {
-      return nil, fmt.Errorf("%s conflicts with operator/bracket: '%s'", symbol.typName(), symb)
+      return nil, symbol.at.errorf("%s conflicts with operator/bracket: '%s'", symbol.typName(), symb)
+    }
+    if words[symb] {
+      return nil, symbol.at.errorf("%s conflicts with word operator: '%s'", symbol.typName(), symb)
     }
     existingSymbol := symbolTable[symb]
     if existingSymbol != nil {
       if existingSymbol.typName() == symbol.typName() {
-        return nil, fmt.Errorf("double declaration of %s: '%s'", symbol.typName(), symb)
+        return nil, symbol.at.errorf("double declaration of %s: '%s'", symbol.typName(), symb)
       } else {
-        return nil, fmt.Errorf("%s conflicts with %s: '%s'", symbol.typName(), existingSymbol.typName(), symb)
+        return nil, symbol.at.errorf("%s conflicts with %s: '%s'", symbol.typName(), existingSymbol.typName(), symb)
       }
     }
     switch symbol.typ {
@@ -508,8 +1046,10 @@ func (this *spec) Grammar(grammar string) (Lang, error) {
       // NOOP
     case spec_Category:
       // NOOP
+    case spec_Macro:
+      // parameters are checked below
     case spec_ShorthandOperator:
-      var pEFE, pEFA, pAFE, pBFA, pAFB int
+      var pEFE, pEFA, pAFE, pBFA, pBFB, pAFB int
       ops := make([]string, 0, len(symbol.ops))
       for _, op := range symbol.ops {
         existingSymbol := symbolTable[op]
@@ -520,19 +1060,21 @@ func (this *spec) Grammar(grammar string) (Lang, error) {
         }
       }
       for _, op := range ops {
-        if prfxScanner.lookup(op) != "OP" {
-          return nil, fmt.Errorf("undeclared symbol: %s: in definition of shorthand operator: %s", op, symbol.symb)
+        if prfxScanner.lookup(op) != "OP" && !words[op] {
+          return nil, symbol.at.errorf("undeclared symbol: %s: in definition of shorthand operator: %s", op, symbol.symb)
         }
         if p := precedence.precedenceEFE[op]; pEFE == 0 || p < pEFE { pEFE = p }
         if p := precedence.precedenceEFA[op]; pEFA == 0 || p < pEFA { pEFA = p }
         if p := precedence.precedenceAFE[op]; pAFE == 0 || p < pAFE { pAFE = p }
         if p := precedence.precedenceBFA[op]; pBFA == 0 || p < pBFA { pBFA = p }
+        if p := precedence.precedenceBFB[op]; pBFB == 0 || p < pBFB { pBFB = p }
         if p := precedence.precedenceAFB[op]; pAFB == 0 || p < pAFB { pAFB = p }
       }
       precedence.precedenceEFE[symbol.symb] = pEFE
       precedence.precedenceEFA[symbol.symb] = pEFA
       precedence.precedenceAFE[symbol.symb] = pAFE
       precedence.precedenceBFA[symbol.symb] = pBFA
+      precedence.precedenceBFB[symbol.symb] = pBFB
       precedence.precedenceAFB[symbol.symb] = pAFB
       prfxMetaScanner.add("OP", symbol.symb)
     case spec_Literal:
@@ -540,66 +1082,197 @@ func (this *spec) Grammar(grammar string) (Lang, error) {
     }
     symbolTable[symb] = symbol
   }
+  for _, symbol := range this.symbols {
+    if symbol.typ == spec_Macro {
+      if err := checkMacroParams(symbol, symbolTable); err != nil {
+        return nil, err
+      }
+    }
+  }
   
-  metaTokenizer := newTokenizer(metaScanner)
+  metaTokenizer := newMetaTokenizer(metaScanner, words, symbolTable, prfxScanner, precedence)
   metaSpanner := newSpanner(metaTokenizer, precedence.precedenceB)
   metaSparser := newSparser(metaSpanner, precedence)
   
-  _, path, lastLineOffset, _ := runtime.Caller(1)
-  grammarSource := &Source{ Path: path, LineOffset: lastLineOffset-strings.Count(grammar, "\n")-1, Text: []byte(grammar) }
-
-  grammarTree := metaSparser.SparseUndent(grammarSource)
+  templateParser := &tpT{ symbolTable: symbolTable, templates: make(map[string][]*templateT),
+                          hiddenDescs: make(map[string]string), docs: make(map[string]string),
+                          messages: make(map[string]string),
+                          metaTokenizer: metaTokenizer, metaSparser: metaSparser }
 
-  //grammarTree.Dump(os.Stdout, "grammar> ")
-  
-  templateParser := &tpT{ symbolTable: symbolTable, templates: make(map[string][]*templateT) }
-  
-  for _, errNode := range grammarTree.FirstN("ERR", "", 20) {
-    templateParser.err(errNode, errNode.Err)
+  macros := newMacroExpander(grammarSource, symbolTable, this.inherited, this.inheritedMacros, whitespaceIndex(grammarSource, metaTokenizer.plain))
+  mainSource, instances := macros.main()
+  if len(macros.errs) == 0 {
+    templateParser.parse(mainSource, instances)
+  }
+  for len(macros.errs) == 0 {
+    instance, instanceSource, instances := macros.next()
+    if instance == nil {
+      break
+    }
+    templateParser.instance = instance.lbl
+    templateParser.parse(instanceSource, instances)
   }
+  templateParser.errs = append(templateParser.errs, macros.errs...)
 
-  templateParser.topSequence(grammarTree)
+  if len(templateParser.errs) == 0 {
+    templateParser.errs = checkAltNames(templateParser.templates, nil)
+  }
 
   if len(templateParser.errs) > 0 {
-    buf := new(bytes.Buffer)
-    for index, err := range templateParser.errs {
-      if index < 20 {
-        fmt.Fprintf(buf, "%s\n", err.Error())
-      } else if index == 20 {
-        fmt.Fprintf(buf, "and %d more error(s)\n", len(templateParser.errs)-20)
-      } else {
-        break
-      }
+    return nil, SummaryError(templateParser.errs, 20)
+  }
+
+  overridden := make(map[string]bool, len(this.overrides))
+  for index, lbl := range this.overrides {
+    if overridden[lbl] {
+      return nil, this.overrideLocs[index].errorf("double override of label: '%s'", lbl)
+    }
+    if len(this.inherited[lbl]) == 0 {
+      return nil, this.overrideLocs[index].errorf("override of label without inherited rules: '%s'", lbl)
+    }
+    overridden[lbl] = true
+  }
+
+  templates := make(map[string][]*templateT, len(this.inherited)+len(templateParser.templates))
+  for lbl, inherited := range this.inherited {
+    if !overridden[lbl] {
+      templates[lbl] = append(templates[lbl], inherited...)
     }
-    return nil, errors.New(buf.String())
+  }
+  for lbl, own := range templateParser.templates {
+    templates[lbl] = append(templates[lbl], own...)
+  }
+  if errs := checkAltNames(templates, nil); len(errs) > 0 {
+    return nil, SummaryError(errs, 20)
   }
 
   descriptions := make(map[string]string, len(symbolTable))
 
+  for lbl, desc := range this.inheritedDescs {
+    descriptions[lbl] = desc
+  }
   for symb, symbol := range symbolTable {
     descriptions[symb] = symbol.desc
   }
+  for lbl, desc := range templateParser.hiddenDescs {
+    descriptions[lbl] = desc
+  }
+  for lbl, desc := range macros.descs {
+    descriptions[lbl] = desc
+  }
   
-  tokenizer := newTokenizer(scanner)
-  spanner := newSpanner(tokenizer, precedence.precedenceB)
-  sparser := newSparser(spanner, precedence)
-  tracer := newTracer(sparser, templateParser.templates, descriptions)
+  docs := make(map[string]string, len(this.inheritedDocs)+len(templateParser.docs))
+  for lbl, doc := range this.inheritedDocs {
+    docs[lbl] = doc
+  }
+  for lbl, doc := range templateParser.docs {
+    docs[lbl] = doc
+  }
+
+  messages := make(map[string]string, len(this.inheritedMessages)+len(templateParser.messages))
+  for lbl, msg := range this.inheritedMessages {
+    messages[lbl] = msg
+  }
+  for lbl, msg := range templateParser.messages {
+    messages[lbl] = msg
+  }
+
+  lang := newLang(this.scanner, prfxScanner, words, precedence, templates, descriptions, messages, levels)
+  lang.docs = docs
+  lang.decls = this.declarations()
+  for _, symbol := range this.symbols {
+    switch symbol.typ {
+    case spec_Label, spec_SentenceLabel, spec_SequenceLabel:
+      lang.labels = append(lang.labels, symbol.symb)
+    case spec_Category:
+      lang.categories = append(lang.categories, symbol.symb)
+    }
+  }
+  return lang, nil
+}
 
-  return &lang{ tokenizer: tokenizer, sparser: sparser, tracer: tracer }, nil
+// declaredAt returns the location of a layer that declares the given operator,
+// functor or bracket.
+func declaredAt(origins map[string]map[string]specLoc, lit string) specLoc {
+  for _, patt := range precedencePatterns {
+    for arg, at := range origins[patt] {
+      if arg == lit || (patt == "MIX" || patt == "B") && isFunctor(arg, lit) {
+        return at
+      }
+    }
+  }
+  return specLoc{} // <-- defensive
 }
 
 type tpT struct {
   symbolTable map[string]*specSymbol
   templates map[string][]*templateT
+  hiddenDescs map[string]string
+  docs map[string]string
+  messages map[string]string // error messages of labels, see parseDirectives
+  metaTokenizer *metaTokenizerT // records the alternative and placeholder names and the list and optional suffixes, see metaTokenizerT
+  metaSparser Sparser
+  // the annotations of the grammar source that is being parsed, by byte offset:
+  instances map[int]string
+  directives map[int][]*directiveT
+  instance string // the macro instance that is being expanded, if any
+  source *Source
+  inWS func(int) bool
   errs []error
 }
 
+// parse parses the rules of the given grammar source into templates, the given macro
+// instances are indexed by the byte offset of their macro name (see macroExpanderT).
+func (this *tpT) parse(grammarSource *Source, instances map[int]string) {
+  inWS := whitespaceIndex(grammarSource, this.metaTokenizer.plain)
+  var directives []*directiveT
+  this.directives = nil
+  if this.instance == "" { // <-- the comments above macro rules are not part of their instances
+    var errs []error
+    this.directives, directives, errs = parseDirectives(grammarSource, this.metaTokenizer.plain, this.symbolTable, this.messages)
+    this.errs = append(this.errs, errs...)
+  }
+  this.instances = instances
+  this.source, this.inWS = grammarSource, inWS
+  this.metaTokenizer.reset()
+  grammarTree := this.metaSparser.SparseUndent(grammarSource)
+
+  //grammarTree.Dump(os.Stdout, "grammar> ")
+
+  errCount := len(this.errs)
+  for _, errNode := range grammarTree.FirstN("ERR", "", -1) {
+    this.err(errNode, errNode.Err)
+  }
+
+  this.topSequence(grammarTree)
+
+  if len(this.errs) == errCount {
+    this.errs = append(this.errs, checkAltNames(nil, this.metaTokenizer.alts)...)
+    this.errs = append(this.errs, checkPlaceholderNames(this.metaTokenizer.names)...)
+    this.errs = append(this.errs, checkSugar(this.metaTokenizer.sugars)...)
+    this.errs = append(this.errs, checkDirectives(directives)...)
+  }
+}
+
 func (this *tpT) err(node *Syntax, format string, args ...interface{}) {
   ambit := node.Ambit
   if node.OpAmbit != nil {
     ambit = node.OpAmbit
   }
-  this.errs = append(this.errs, fmt.Errorf(ambit.Location() + ": " + format, args...))
+  if this.instance != "" {
+    format += " (in macro instance: %s)"
+    args = append(args, this.instance)
+  }
+  this.errs = append(this.errs, AmbitError(ambit, fmt.Sprintf(format, args...)))
+}
+
+// instanceLabel returns the label of the macro instance of the given macro node.
+func (this *tpT) instanceLabel(node *Syntax) string {
+  lbl := this.instances[node.Ambit.Start]
+  if lbl == "" {
+    this.err(node, "macro without arguments: '%s'", node.Lit)
+  }
+  return lbl
 }
 
 func (this *tpT) topSequence(node *Syntax) {
@@ -621,20 +1294,23 @@ func (this *tpT) topSequence(node *Syntax) {
 
 func (this *tpT) multiSentenceRule(node *Syntax) {
   sn, lbl := this.multiSentenceRuleHead(node.Left.Left)
-  this.multiSentenceRuleBody(node.Left.Right, sn, lbl)
+  this.document(lbl, node.Left.Left)
+  this.multiSentenceRuleBody(node.Left.Right, node.Left.Left.OpAmbit, sn, lbl)
   this.multiSentenceRuleContinuation(node.Right, sn, lbl)
 }
 
 func (this *tpT) multiSentenceRuleContinuation(node *Syntax, sn bool, lbl string) {
   if node.Cat == "SQ" && node.Left.Cat == "SN" && node.Left.Left.IsZeroaryOp("or>") {
-    this.multiSentenceRuleBody(node.Left.Right, sn, lbl)
+    this.multiSentenceRuleBody(node.Left.Right, node.Left.Left.OpAmbit, sn, lbl)
     this.multiSentenceRuleContinuation(node.Right, sn, lbl)
   } else {
     this.topSequence(node)
   }
 }
 
-func (this *tpT) multiSentenceRuleBody(node *Syntax, sn bool, lbl string) {
+// multiSentenceRuleBody adds the template of the given body to the rules of the given
+// label, the body is introduced by the meta operator with the given ambit.
+func (this *tpT) multiSentenceRuleBody(node *Syntax, op *Ambit, sn bool, lbl string) {
   template := this.multiSentenceTemplate(node)
   if template != nil && lbl != "" {
     if sn {
@@ -644,6 +1320,11 @@ func (this *tpT) multiSentenceRuleBody(node *Syntax, sn bool, lbl string) {
       }
       template = template.left
     }
+    ambit := node.Ambit.original()
+    template.loc, template.ambit = ambit.Location(), ambit
+    template.alt = this.altName(op)
+    this.direct(op, template)
+    this.indexNames(template)
     this.templates[lbl] = append(this.templates[lbl], template)
   }
 }
@@ -677,6 +1358,8 @@ func (this *tpT) multiSentenceRuleHead(node *Syntax) (bool, string) {
     this.err(left, "expected sequence label instead of literal: '%s'", left.Lit)
   case spec_Category:
     this.err(left, "expected sequence label instead of category: '%s'", left.Lit)
+  case spec_Macro:
+    this.err(left, "expected sequence label instead of macro: '%s'", left.Lit)
   default:
     this.err(left, "expected sequence label instead of: '%s'", left.Lit)
   }
@@ -709,6 +1392,10 @@ func (this *tpT) singleSentenceRule(node *Syntax) {
     fallthrough
   case spec_Label:
     lbl = left.Lit
+  case spec_Macro:
+    if lbl = this.instanceLabel(left); lbl == "" {
+      return
+    }
   case spec_Literal:
     this.err(left, "expected label instead of literal: '%s'", left, left.Lit)
     return
@@ -719,14 +1406,18 @@ func (this *tpT) singleSentenceRule(node *Syntax) {
     this.err(left, "expected label instead of: '%s'", left.Lit)
     return
   }
-  this.singleSentenceRuleBody(node.Right, sn, lbl)
+  this.document(lbl, left)
+  this.singleSentenceRuleBody(node.Right, node.OpAmbit, sn, lbl)
 }
 
-func (this *tpT) singleSentenceRuleBody(node *Syntax, sn bool, lbl string) {
+// singleSentenceRuleBody adds the templates of the alternatives of the given body to
+// the rules of the given label, the body is introduced by the meta operator with the
+// given ambit.
+func (this *tpT) singleSentenceRuleBody(node *Syntax, op *Ambit, sn bool, lbl string) {
   if node.Cat == "OP" && node.Lit == "or>" {
     // order of invocation matters here:
-    this.singleSentenceRuleBody(node.Left, sn, lbl) 
-    this.singleSentenceRuleBody(node.Right, sn, lbl)
+    this.singleSentenceRuleBody(node.Left, op, sn, lbl) 
+    this.singleSentenceRuleBody(node.Right, node.OpAmbit, sn, lbl)
     return
   }
   template := this.intraSentenceTemplate(node)
@@ -738,6 +1429,11 @@ func (this *tpT) singleSentenceRuleBody(node *Syntax, sn bool, lbl string) {
                              left: template,
                              right: &templateT{ matchCat: true, cat: ""} }
     }
+    ambit := this.ruleAmbit(node.Ambit).original()
+    template.loc, template.ambit = ambit.Location(), ambit
+    template.alt = this.altName(op)
+    this.direct(op, template)
+    this.indexNames(template)
     this.templates[lbl] = append(this.templates[lbl], template)
   }
 }
@@ -766,11 +1462,15 @@ func (this *tpT) possiblyEmptyIntraSentenceTemplate(node *Syntax) *templateT {
     case spec_SentenceLabel:
       this.err(node, "nested expression cannot be labeled with sentence label: '%s'", node.Lit)
     case spec_Label:
-      return &templateT{ lbl: node.Lit, subCount: 1 }
+      return this.sugared(node.Ambit.Start, this.named(node.Ambit.Start, &templateT{ lbl: node.Lit, subCount: 1 }))
     case spec_Literal:
-      return &templateT{ matchCat: true, cat: symbol.cat, matchLit: true, lit: symbol.lit, catCount: 0 }
+      return this.named(node.Ambit.Start, &templateT{ matchCat: true, cat: symbol.cat, matchLit: true, lit: symbol.lit, catCount: 0 })
     case spec_Category:
-      return &templateT{ matchCat: true, cat: symbol.cat, catCount: 1 }
+      return this.named(node.Ambit.Start, &templateT{ matchCat: true, cat: symbol.cat, catCount: 1 })
+    case spec_Macro:
+      if lbl := this.instanceLabel(node); lbl != "" {
+        return this.named(node.Ambit.Start, &templateT{ lbl: lbl, subCount: 1 })
+      }
     default:
       this.err(node, "unknown symbol: '%s'", node.Lit) // <-- defensive
     }
@@ -795,13 +1495,22 @@ func (this *tpT) possiblyEmptyIntraSentenceTemplate(node *Syntax) *templateT {
                             right: this.possiblyEmptyIntraSentenceTemplate(node.Right) }
     template.subCount = template.left.subCountOrZero() + template.right.subCountOrZero()
     template.catCount = template.left.catCountOrZero() + template.right.catCountOrZero() + 1
-    return template         
+    return this.named(node.OpAmbit.Start, template)
   }
   template := &templateT{ matchCat: true, cat: node.Cat, matchLit: true, lit: node.Lit,
                           left: this.possiblyEmptyIntraSentenceTemplate(node.Left),
                           right: this.possiblyEmptyIntraSentenceTemplate(node.Right) }
   template.subCount = template.left.subCountOrZero() + template.right.subCountOrZero()
   template.catCount = template.left.catCountOrZero() + template.right.catCountOrZero()
+  if node.Mid != nil {
+    template.mid = make([]*templateT, len(node.Mid))
+    for index, sub := range node.Mid {
+      mid := this.possiblyEmptyIntraSentenceTemplate(sub)
+      template.mid[index] = mid
+      template.subCount += mid.subCountOrZero()
+      template.catCount += mid.catCountOrZero()
+    }
+  }
   return template
 }
 
@@ -823,7 +1532,7 @@ func (this *tpT) possiblyEmptyMultiSentenceTemplate(node *Syntax) *templateT {
         lbl := node.Left.Left.Lit
         symbol := this.symbolTable[lbl]
         if symbol != nil && symbol.typ == spec_SequenceLabel {
-          return &templateT{ lbl: lbl, subCount: 1 }
+          return this.named(node.Left.Left.Ambit.Start, &templateT{ lbl: lbl, subCount: 1 })
         }
       } else if node.Left.Left.IsZeroaryOp("<empty") {
         return &templateT{ matchCat: true, cat: "" }
@@ -841,7 +1550,7 @@ func (this *tpT) possiblyEmptyMultiSentenceTemplate(node *Syntax) *templateT {
       lbl := node.Left.Lit
       symbol := this.symbolTable[lbl]
       if symbol != nil && symbol.typ == spec_SentenceLabel {
-        return &templateT{ lbl: lbl, subCount: 1 }
+        return this.named(node.Left.Ambit.Start, &templateT{ lbl: lbl, subCount: 1 })
       }
     }
     template := &templateT{ matchCat: true, cat: "SN",
@@ -852,4 +1561,43 @@ func (this *tpT) possiblyEmptyMultiSentenceTemplate(node *Syntax) *templateT {
     return template
   }
   return this.possiblyEmptyIntraSentenceTemplate(node)
-}
\ No newline at end of file
+}
+// document records the comment lines directly above the line of the given rule head as
+// documentation of the given label. The comment markers (such as "#" or "//") are
+// stripped, the comments of multiple rules of the same label are joined.
+func (this *tpT) document(lbl string, head *Syntax) {
+  if lbl == "" || this.instance != "" {
+    return
+  }
+  text := this.source.Text
+  end := head.Ambit.Start
+  for end > 0 && text[end-1] != '\n' {
+    end--
+  }
+  var lines []string
+  for end > 0 {
+    start := end-1
+    for start > 0 && text[start-1] != '\n' {
+      start--
+    }
+    line := strings.TrimSpace(string(text[start:end]))
+    if line == "" || !this.inWS(start + strings.Index(string(text[start:end]), line)) {
+      break
+    }
+    if strings.HasPrefix(line, "#!") {
+      end = start
+      continue // <-- directives are not documentation, see parseDirectives
+    }
+    line = strings.TrimLeft(line, "#/;-*%!")
+    lines = append([]string{ strings.TrimPrefix(line, " ") }, lines...)
+    end = start
+  }
+  if len(lines) == 0 {
+    return
+  }
+  doc := strings.Join(lines, "\n")
+  if existing := this.docs[lbl]; existing != "" {
+    doc = existing + "\n" + doc
+  }
+  this.docs[lbl] = doc
+}
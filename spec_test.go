@@ -1,6 +1,9 @@
 package dusl
 
 import (
+  "bytes"
+  "fmt"
+  "strings"
   "testing"
 )
 
@@ -35,4 +38,337 @@ func TestSpec(t *testing.T) {
   }
 
   t.Log(lang)
-}
\ No newline at end of file
+}
+
+// locatedMessage returns the message of the given error, which is expected to be a
+// LocatedError that is located in a test.
+func locatedMessage(err error) string {
+  located, ok := err.(LocatedError)
+  if !ok || !strings.Contains(located.Location(), "_test.go:") {
+    return fmt.Sprintf("not located in a test: %v", err)
+  }
+  return located.Message()
+}
+
+func TestSpecWords(t *testing.T) {
+  lang, err := NewSpec().
+    Lexical(DefaultScanner).
+    Category("ID", "identifier").
+    OperatorEFA("not").
+    OperatorBFA("and").
+    OperatorBFA("or").
+    Words("and", "or", "not").
+    Label("X", "expression").
+    Grammar(`
+      X is> ID or> not X or> X and X or> X or X`)
+
+  if err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+
+  res := lang.Tracer().Trace(AmbitFromString("not order and android or notes"), "X").DumpToString(true)
+  tgt := `X:3:or
+  X:2:and
+    X:1:not
+      X:0:order
+    X:0:android
+  X:0:notes
+`
+  if res != tgt {
+    t.Log(res)
+    t.Fail()
+  }
+
+  for _, tst := range []struct{ spec Spec; err string }{
+    { NewSpec().Lexical(DefaultScanner).OperatorBFA("and").Words("and").Literal("and"),
+      "literal conflicts with word operator: 'and'" },
+    { NewSpec().Lexical(DefaultScanner).OperatorBFA("and").Words("and", "xor"),
+      "undeclared word operator: 'xor'" },
+    { NewSpec().Lexical(DefaultScanner).OperatorBFA("and").Words("and", "and"),
+      "double declaration of word operator: 'and'" },
+    { NewSpec().Lexical(DefaultScanner).OperatorBFA("<and>").Words("<and>"),
+      "word operator is not scanned as a single token: '<and>'" },
+  } {
+    _, err := tst.spec.Grammar("")
+    if err == nil || locatedMessage(err) != tst.err {
+      t.Log(err)
+      t.Fail()
+    }
+  }
+}
+
+func TestSpecExtend(t *testing.T) {
+  base, err := NewSpec().
+    Lexical(DefaultScanner).
+    Category("ID", "identifier").
+    Category("NUM", "number").
+    OperatorBFA("+", "-").
+    Level("additive").
+    Brackets("( )").
+    Label("X", "expression").
+    Grammar(`
+      X is> (X) or> NUM or> ID or> X + X or> X - X`)
+
+  if err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+
+  buf := new(bytes.Buffer)
+  base.Encode(buf)
+  decoded, err := DecodeLang(buf)
+  if err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+
+  for _, base := range []Lang{ base, decoded } {
+    derived, err := base.Extend().
+      OperatorBFA("*").
+      Above("additive").
+      Grammar(`
+        X is> X * X`)
+
+    if err != nil {
+      t.Log(err)
+      t.Fail()
+      return
+    }
+
+    res := derived.Tracer().Trace(AmbitFromString("a + 2 * (b - c)"), "X").DumpToString(true)
+    tgt := `X:3:+
+  X:2:a
+  X:5:*
+    X:1:2
+    X:0:( )
+      X:4:-
+        X:2:b
+        X:2:c
+`
+    if res != tgt {
+      t.Log(res)
+      t.Fail()
+    }
+
+    overriding, err := NewSpec().
+      Extend(base).
+      Override("X").
+      Grammar(`
+        X is> ID or> X + X`)
+
+    if err != nil {
+      t.Log(err)
+      t.Fail()
+      return
+    }
+
+    res = overriding.Tracer().Trace(AmbitFromString("a + 2"), "X").DumpToString(true)
+    tgt = `X:1:+
+  X:0:a
+  ERR:0:expected: expression
+`
+    if res != tgt {
+      t.Log(res)
+      t.Fail()
+    }
+  }
+
+  for _, tst := range []struct{ spec Spec; err string }{
+    { base.Extend().Label("X", "expression"), "double declaration of label: 'X'" },
+    { base.Extend().OperatorBFA("+").SameAs("+"), "double declaration of BFA identifier/operator/bracket: '+'" },
+    { base.Extend().Label("Y", "other expression").Override("Y"), "override of label without inherited rules: 'Y'" },
+    { base.Extend().Override("X", "X"), "double override of label: 'X'" },
+  } {
+    _, err := tst.spec.Grammar("")
+    if err == nil || locatedMessage(err) != tst.err {
+      t.Log(err)
+      t.Fail()
+    }
+  }
+
+  named, err := NewSpec().
+    Lexical(DefaultScanner).
+    Category("ID", "identifier").
+    Category("NUM", "number").
+    Label("X", "expression").
+    Label("Y", "other expression").
+    Grammar(`
+      X is> [name] ID
+      Y is> [name] ID`)
+  if err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+  _, err = named.Extend().Grammar(`
+      X is> [name] NUM
+      Y is> [name] NUM`)
+  if errs := Errors(err); len(errs) != 2 {
+    t.Logf("expected two errors, got: %v", err)
+    t.Fail()
+  }
+}
+
+func TestSpecMacro(t *testing.T) {
+  lang, err := NewSpec().
+    Lexical(DefaultScanner).
+    Category("ID", "identifier").
+    Category("NUM", "number").
+    OperatorAFB(";").
+    OperatorBFA(",").
+    Application("( )").
+    Brackets("( )", "[ ]").
+    Label("X", "expression").
+    Label("Arg", "argument").
+    Macro("List", "list of {x} separated by {sep}", "x", "sep").
+    Macro("Opt", "optional {x}", "x").
+    Grammar(`
+      X is> ID(List(Arg, ",")) or> [List(Opt(X), ",")] or> ID or> NUM
+      Arg is> NUM
+      List(x, sep) is> List(x, sep) sep x or> x  # the separator is substituted as an operator
+      Opt(x) is> x or> <empty`)
+
+  if err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+
+  for _, tst := range []struct{ src string; lbl string }{
+    { "f(1, 2, 3)", `List(Arg, ",")` },
+    { "[a, 1]", `List(Opt(X), ",")` },
+  } {
+    trace := lang.Tracer().Trace(AmbitFromString(tst.src), "X")
+    if err := trace.ErrorN(20); err != nil {
+      t.Log(err)
+      t.Fail()
+      continue
+    }
+    if trace.Subs[0].Lbl != tst.lbl {
+      t.Log(trace.DumpToString(true))
+      t.Fail()
+    }
+  }
+
+  if desc := lang.Tracer().(*tracer).descriptions[`List(Opt(X), ",")`]; desc != `list of optional expression separated by ","` {
+    t.Log(desc)
+    t.Fail()
+  }
+
+  for _, tst := range []struct{ grammar string; err string }{
+    { "X is> {List(Arg, \",\")}", "" },
+    { "X is> {List(NUM, \";\")}", "inherited macro without rules, repeat its rules to instantiate it with new arguments: 'List'" },
+    { "X is> {List(NUM, \";\")}\nList(x, sep) is> List(x, sep) sep x or> x", "" },
+  } {
+    _, err := lang.Extend().Brackets("{ }").Grammar(tst.grammar)
+    if (err == nil && tst.err != "") || (err != nil && (tst.err == "" || !strings.Contains(err.Error(), tst.err))) {
+      t.Log(tst.grammar, err)
+      t.Fail()
+    }
+  }
+
+  for _, tst := range []struct{ grammar string; err string }{
+    { "X is> List(X)", "expected 2 argument(s) for macro: 'List'" },
+    { "X is> List(Y, \",\")", "undeclared symbol in macro argument: 'Y'" },
+    { "X is> List", "macro without arguments: 'List'" },
+    { "X is> List(X, \";\")", "macro without rules: 'List'" },
+    { "X is> List(X, \";\")\nList(y, sep) is> y", "expected the declared parameters in the head of the macro rule: 'List(x, sep)'" },
+    { "X is> List(X, \";\")\nList(x, sep) is> x sep Y", "undeclared symbol: 'Y' (in macro instance: List(X, \";\"))" },
+    { "X is> Nest(X)\nNest(x) is> x or> (Nest(Nest(x)))", "the expansion of the macro does not terminate: 'Nest'" },
+  } {
+    _, err := NewSpec().
+      Lexical(DefaultScanner).
+      OperatorAFB(";").
+      Brackets("( )", "[ ]").
+      Label("X", "expression").
+      Macro("List", "list of {x}", "x", "sep").
+      Macro("Nest", "nested {x}", "x").
+      Grammar(tst.grammar)
+    if err == nil || !strings.Contains(err.Error(), tst.err) {
+      t.Log(tst.grammar, err)
+      t.Fail()
+    }
+  }
+
+  _, err = LoadSpec(SourceFromString(`lexical default
+category ID "identifier"
+operator BFA ,
+brackets ( )
+label X "expression"
+macro List "list of {x}" x
+grammar
+X is> ID or> (List(ID))
+List(x) is> x , List(x) or> x
+`))
+  if err != nil {
+    t.Log(err)
+    t.Fail()
+  }
+
+  _, err = LoadSpec(SourceFromString(`lexical default
+category ID "identifier"
+operator BFA ,
+brackets ( )
+label X "expression"
+macro List "list of {x}" x sep
+grammar
+X is> ID or> (List(X, ","))
+List(x, sep) is> x sep List(x, sep) sep Y or> x
+`))
+  if errs := Errors(err); len(errs) != 1 || errs[0].Error() != "str:9:40:41: undeclared symbol: 'Y' (in macro instance: List(X, \",\"))" ||
+      errs[0].(LocatedError).Ambit().ToString() != "Y" {
+    t.Log(err)
+    t.Fail()
+  }
+}
+
+func TestSpecGrammarAt(t *testing.T) {
+  spec := func() Spec {
+    return NewSpec().
+      Lexical(DefaultScanner).
+      Category("ID", "identifier").
+      OperatorBFA("+").
+      Label("X", "expression")
+  }
+
+  _, err := spec().GrammarAt("calc.dusl", 10, "\nX is> ID or> X + Y\n")
+  errs := Errors(err)
+  if len(errs) != 1 {
+    t.Logf("expected one error, got: %v", err)
+    t.Fail()
+    return
+  }
+  located, ok := errs[0].(LocatedError)
+  if !ok || located.Location() != "calc.dusl:11:17:18" || located.Message() != "undeclared symbol: 'Y'" ||
+      located.Ambit().ToString() != "Y" {
+    t.Logf("unexpected error: %v", errs[0])
+    t.Fail()
+  }
+
+  var rules []string
+  for index := 0; index < 25; index++ {
+    rules = append(rules, "X is> Y")
+  }
+  _, err = spec().GrammarFromSource(&Source{ Path: "calc.dusl", Text: []byte(strings.Join(rules, "\n")) })
+  if errs := Errors(err); len(errs) != 25 || !strings.HasSuffix(err.Error(), "and 5 more error(s)\n") {
+    t.Logf("expected 25 errors, got: %v", err)
+    t.Fail()
+  }
+  if errs := Errors(err); len(errs) > 0 && errs[24].Error() != "calc.dusl:25:6:7: undeclared symbol: 'Y'" {
+    t.Logf("unexpected error: %v", errs[24])
+    t.Fail()
+  }
+
+  for index := range rules {
+    rules[index] = "X is> ID )"
+  }
+  _, err = spec().GrammarFromSource(&Source{ Path: "calc.dusl", Text: []byte(strings.Join(rules, "\n")) })
+  if errs := Errors(err); len(errs) != 25 || errs[24].Error() != "calc.dusl:25:9:10: unexpected character(s): ')'" {
+    t.Logf("expected 25 syntax errors, got: %v", err)
+    t.Fail()
+  }
+}
@@ -0,0 +1,297 @@
+package dusl
+
+import (
+  "bytes"
+  "fmt"
+  "strconv"
+  "strings"
+)
+
+// LoadSpec loads a complete dialect specification from the given source and returns
+// the resulting Lang. A spec file consists of a declarations section followed by
+// a line that reads "grammar" (without indentation) followed by the grammar rules, written
+// exactly as they would be passed to Spec.Grammar. Every declaration takes a single
+// line and corresponds to a call in the fluent Spec interface:
+//
+//   lexical default                 Lexical (registered scanners, see RegisterScanner)
+//   category ID "identifier"        Category
+//   operator BFA + -                OperatorBFA (likewise AFB, BFB, EFA, AFE, EFE)
+//   mixfix ? :                      OperatorMixfix
+//   brackets ( ) [ ]                Brackets
+//   application ( )                 Application
+//   juxtaposition LWA if else       JuxtapositionLWA (likewise AWL)
+//   glue LA $                       GlueLA (likewise AL)
+//   words and or                    Words
+//   shorthand ~infix~ + -           ShorthandOperator
+//   literal end noop                Literal
+//   label X "expression"            Label
+//   sentence S "statement"          SentenceLabel
+//   sequence Q "statements"         SequenceLabel
+//   macro List "list of {x}" x sep  Macro
+//   level additive                  Level (likewise above, below and same as)
+//
+// Symbols are separated by whitespace, descriptions are string literals and comments
+// start with a hash sign. A symbol that starts with a hash sign or a quote is written as
+// a string literal, for example: operator EFA "#". The keywords of the declarations can
+// be used as symbols as they are. Errors are located in the given source.
+func LoadSpec(src *Source) (Lang, error) {
+  declSource, ruleSource := splitSpecFile(src)
+  trace := specFileLang.Tracer().TraceUndent(declSource, "Decls")
+  if err := trace.ErrorN(20); err != nil {
+    return nil, err
+  }
+  spec := &spec{}
+  for ; trace.Alt == "more"; trace = trace.Subs[1] {
+    if err := spec.declare(trace.Subs[0]); err != nil {
+      return nil, err
+    }
+  }
+  return spec.grammarFromSource(ruleSource)
+}
+
+// splitSpecFile splits the given source into the declarations section and the
+// grammar rules section.
+func splitSpecFile(src *Source) (*Source, *Source) {
+  text := src.Text
+  offset, line := 0, 0
+  for offset < len(text) {
+    end := len(text)
+    if index := bytes.IndexByte(text[offset:], '\n'); index >= 0 {
+      end = offset+index
+    }
+    if strings.TrimRight(string(text[offset:end]), " \t\r") == "grammar" {
+      return &Source{ Path: src.Path, LineOffset: src.LineOffset, Text: text[:offset] },
+             &Source{ Path: src.Path, LineOffset: src.LineOffset+line+1, Text: text[min(end+1, len(text)):] }
+    }
+    offset, line = end+1, line+1
+  }
+  return src, &Source{ Path: src.Path, LineOffset: src.LineOffset+line }
+}
+
+// declare applies a single declaration of a spec file to this spec, the declared
+// layers and symbols are located at the declaration.
+func (this *spec) declare(decl *Trace) error {
+  cats := decl.Cats
+  var sym string
+  if len(decl.Subs) > 0 && decl.Subs[0].Lbl == "Sym" {
+    sym = specFileSymbol(decl.Subs[0])
+  }
+  this.at = specLoc{ ambit: decl.Syn.Ambit }
+  defer func() { this.at = specLoc{} }()
+  switch decl.Alt {
+  case "Lexical":
+    scanners := make([]Scanner, 0, 4)
+    for _, name := range specFileSymbols(decl.Subs[0]) {
+      lit := specFileSymbol(name)
+      scanner := scannerRegistry[lit]
+      if scanner == nil {
+        return AmbitError(name.Syn.Ambit, fmt.Sprintf("unregistered scanner: '%s'", lit))
+      }
+      scanners = append(scanners, scanner)
+    }
+    this.Lexical(composeScanners(scanners...))
+  case "Category":
+    this.Category(sym, specFileString(cats[0]))
+  case "Operator":
+    ops := specFileLits(decl.Subs[1])
+    switch sym {
+    case "AFB": this.OperatorAFB(ops...)
+    case "BFA": this.OperatorBFA(ops...)
+    case "BFB": this.OperatorBFB(ops...)
+    case "EFA": this.OperatorEFA(ops...)
+    case "AFE": this.OperatorAFE(ops...)
+    case "EFE": this.OperatorEFE(ops...)
+    default:
+      return AmbitError(decl.Subs[0].Syn.Ambit, fmt.Sprintf("expected operator pattern (AFB, BFA, BFB, EFA, AFE or EFE) instead of: '%s'", sym))
+    }
+  case "Mixfix":
+    this.OperatorMixfix(strings.Join(specFileLits(decl.Subs[0]), " "))
+  case "Brackets", "Application":
+    brs := specFileLits(decl.Subs[0])
+    if len(brs) % 2 != 0 {
+      return AmbitError(decl.Syn.Ambit, "expected pairs of open and close brackets")
+    }
+    pairs := make([]string, 0, len(brs)/2)
+    for index := 0; index < len(brs); index += 2 {
+      pairs = append(pairs, brs[index] + " " + brs[index+1])
+    }
+    if decl.Alt == "Brackets" {
+      this.Brackets(pairs...)
+    } else {
+      this.Application(pairs...)
+    }
+  case "Juxtaposition":
+    ids := specFileLits(decl.Subs[1])
+    switch sym {
+    case "LWA": this.JuxtapositionLWA(ids...)
+    case "AWL": this.JuxtapositionAWL(ids...)
+    default:
+      return AmbitError(decl.Subs[0].Syn.Ambit, fmt.Sprintf("expected juxtaposition pattern (LWA or AWL) instead of: '%s'", sym))
+    }
+  case "Glue":
+    ids := specFileLits(decl.Subs[1])
+    switch sym {
+    case "LA": this.GlueLA(ids...)
+    case "AL": this.GlueAL(ids...)
+    default:
+      return AmbitError(decl.Subs[0].Syn.Ambit, fmt.Sprintf("expected glue pattern (LA or AL) instead of: '%s'", sym))
+    }
+  case "Words":
+    this.Words(specFileLits(decl.Subs[0])...)
+  case "Shorthand":
+    this.ShorthandOperator(sym, specFileLits(decl.Subs[1])...)
+  case "Literal":
+    this.Literal(specFileLits(decl.Subs[0])...)
+  case "Label":
+    this.Label(sym, specFileString(cats[0]))
+  case "Sentence":
+    this.SentenceLabel(sym, specFileString(cats[0]))
+  case "Sequence":
+    this.SequenceLabel(sym, specFileString(cats[0]))
+  case "Level":
+    this.Level(sym)
+  case "Above":
+    this.Above(sym)
+  case "Below":
+    this.Below(sym)
+  case "SameAs":
+    this.SameAs(sym)
+  case "Macro":
+    this.Macro(sym, specFileString(cats[0]), specFileLits(decl.Subs[1])...)
+  }
+  return this.err
+}
+
+// specFileSymbol returns the literal of the given symbol, a symbol written as a
+// string literal is unquoted.
+func specFileSymbol(sym *Trace) string {
+  if sym.Alt == "quoted" {
+    return specFileString(sym.Cats[0])
+  }
+  return sym.Cats[0].Lit
+}
+
+func specFileSymbols(syms *Trace) []*Trace {
+  var list []*Trace
+  for ; syms.Alt == "more"; syms = syms.Subs[1] {
+    list = append(list, syms.Subs[0])
+  }
+  return append(list, syms.Subs[0])
+}
+
+func specFileLits(syms *Trace) []string {
+  list := specFileSymbols(syms)
+  lits := make([]string, len(list))
+  for index, sym := range list {
+    lits[index] = specFileSymbol(sym)
+  }
+  return lits
+}
+
+func specFileString(str *Syntax) string {
+  lit := str.Lit
+  if strings.HasPrefix(lit, "`") {
+    return strings.TrimRight(lit[1:], "\r\n")
+  }
+  unquoted, _ := strconv.Unquote(lit) // the string scanner only scans valid string literals
+  return unquoted
+}
+
+// The specSymbolScanner scans runs of non-whitespace characters that do not start
+// with a hash sign or a quote, it reports the lexical category "SYM".
+type specSymbolScanner struct {}
+
+func (this *specSymbolScanner) Scan() Scan {
+  return &specSymbolScan{}
+}
+
+func (this *specSymbolScanner) Report(categories map[string]bool) {
+  categories["SYM"] = true
+}
+
+type specSymbolScan struct {
+  state int
+}
+
+func (this *specSymbolScan) Consume(r rune) (string, bool) {
+  const (
+    INIT = iota // convention requires: INIT == 0
+    REST
+    NOMORE
+  )
+  space := r == ' ' || r == '\t' || r == '\r' || r == '\n'
+  switch this.state {
+  case INIT:
+    if space || r == '#' || r == '"' || r == '`' {
+      this.state = NOMORE
+      return "", false
+    }
+    this.state = REST
+    return "SYM", true
+  case REST:
+    if space {
+      this.state = NOMORE
+      return "", false
+    }
+    return "SYM", true
+  }
+  return "", false
+}
+
+func (this *specSymbolScan) Reset() {
+  this.state = 0
+}
+
+// specFileLang is the language of the declarations section of spec files.
+var specFileLang Lang
+
+func init() {
+  var err error
+  specFileLang, err = NewSpec().
+    Lexical(sequenceScanners(&simpleBaseScanner{}, &simpleStringScanner{}, &specSymbolScanner{})).
+    Category("SYM", "symbol").
+    Category("STR", "string").
+    Literal("lexical", "category", "operator", "mixfix", "brackets", "application", "juxtaposition",
+            "glue", "words", "shorthand", "literal", "label", "sentence", "sequence",
+            "level", "above", "below", "same", "as", "macro").
+    SequenceLabel("Decls", "declarations").
+    SentenceLabel("Decl", "declaration").
+    Label("Sym", "symbol").
+    Label("Syms", "symbols").
+    Grammar(`
+
+      Decls is> [none]
+        <empty
+      or> [more]
+        Decl
+        Decls
+
+      Decl is> [Lexical] lexical Syms
+           or> [Category] category Sym STR
+           or> [Operator] operator Sym Syms
+           or> [Mixfix] mixfix Syms
+           or> [Brackets] brackets Syms
+           or> [Application] application Syms
+           or> [Juxtaposition] juxtaposition Sym Syms
+           or> [Glue] glue Sym Syms
+           or> [Words] words Syms
+           or> [Shorthand] shorthand Sym Syms
+           or> [Literal] literal Syms
+           or> [Label] label Sym STR
+           or> [Sentence] sentence Sym STR
+           or> [Sequence] sequence Sym STR
+           or> [Level] level Sym
+           or> [Above] above Sym
+           or> [Below] below Sym
+           or> [SameAs] same as Sym
+           or> [Macro] macro Sym STR Syms
+
+      Sym is> [plain] SYM or> [quoted] STR
+
+      Syms is> [more] Sym Syms or> [last] Sym
+
+    `)
+  if err != nil {
+    panic(err.Error())
+  }
+}
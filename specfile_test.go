@@ -0,0 +1,116 @@
+package dusl
+
+import (
+  "testing"
+)
+
+func TestLoadSpec(t *testing.T) {
+  lang, err := LoadSpec(SourceFromString(`
+# a small expression dialect
+lexical default
+category ID "identifier"
+category NUM "number"
+operator EFA + -
+operator BFA * /
+level multiplicative
+operator BFA + -
+below multiplicative
+brackets ( )
+sequence XSQ "expression sequence"
+sentence XSE "expression sentence"
+label X "expression"
+grammar
+
+XSQ is>
+  XSE
+  XSQ
+or>
+  <empty
+
+XSE is> X
+
+X is> (X) or> NUM or> ID or> +X or> -X or> X * X or> X / X or> X + X or> X - X
+`))
+
+  if err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+
+  res := lang.Tracer().Trace(AmbitFromString("a + 2 * b"), "X").DumpToString(true)
+  tgt := `X:7:+
+  X:2:a
+  X:5:*
+    X:1:2
+    X:2:b
+`
+  if res != tgt {
+    t.Log(res)
+    t.Fail()
+  }
+
+  for _, tst := range []struct{ src string; err string }{
+    { "lexical nonsense\n", "str:1:8:16: unregistered scanner: 'nonsense'" },
+    { "lexical default\noperator XYZ +\n", "str:2:9:12: expected operator pattern (AFB, BFA, BFB, EFA, AFE or EFE) instead of: 'XYZ'" },
+    { "lexical default\nbrackets ( ) [\n", "str:2:0:3:0: expected pairs of open and close brackets" },
+    { "lexical default\nlabel X \"expression\"\ngrammar\n\nY is> X\n", "str:5:0:1: undeclared symbol: 'Y'\n" },
+    { "lexical default\noperator BFA +\nbrackets + -\n", "str:3:0:4:0: declared open bracket conflicts with declared operator: '+'" },
+  } {
+    _, err := LoadSpec(SourceFromString(tst.src))
+    if err == nil || err.Error() != tst.err {
+      t.Log(err)
+      t.Fail()
+    }
+  }
+}
+
+func TestLoadSpecSymbols(t *testing.T) {
+  lang, err := LoadSpec(SourceFromString(
+    "lexical identifier decimal base\n" +
+    "category ID \"identifier\"\n" +
+    "category NUM \"number\"\n" +
+    "operator EFA \"`!\"\n" +
+    "operator AFE \"\\\"\"\n" +
+    "operator BFA as\n" +
+    "level \"as\"\n" +
+    "operator BFA label\n" +
+    "below as\n" +
+    "label sentence \"expression\"\n" +
+    "grammar\n" +
+    "sentence is> ID or> NUM or> `!sentence or> sentence as sentence or> sentence label sentence\n"))
+
+  if err != nil {
+    t.Log(err)
+    t.Fail()
+    return
+  }
+
+  res := lang.Tracer().Trace(AmbitFromString("`!a label b as 1"), "sentence").DumpToString(true)
+  tgt := "sentence:4:label\n" +
+         "  sentence:2:`!\n" +
+         "    sentence:0:a\n" +
+         "  sentence:3:as\n" +
+         "    sentence:0:b\n" +
+         "    sentence:1:1\n"
+  if res != tgt {
+    t.Log(res)
+    t.Fail()
+  }
+
+  res = lang.Sparser().Sparse(AmbitFromString("a\"")).DumpToString(true)
+  tgt = `OP:"
+  ID:a
+  :
+`
+  if res != tgt {
+    t.Log(res)
+    t.Fail()
+  }
+
+  _, err = LoadSpec(SourceFromString("lexical \"default\" \"nonsense\"\n"))
+  if err == nil || err.Error() != "str:1:18:28: unregistered scanner: 'nonsense'" {
+    t.Log(err)
+    t.Fail()
+  }
+}
@@ -0,0 +1,239 @@
+package dusl
+
+import (
+  "fmt"
+  "sort"
+  "strings"
+  "unicode/utf8"
+)
+
+// meta_Sugar is the category of the tokens of the sugarScanner.
+const meta_Sugar = "$SUGAR"
+
+// The sugarScanner scans a list or optional suffix: a separator followed by '*' or
+// '+', as in "expr,*" (zero or more expressions separated by commas) and "expr,+"
+// (one or more), or a single '?', as in "expr?" (optional expression). Whether the
+// suffix is attached to a label is decided by the metaTokenizerT.
+type sugarScanner struct {}
+
+func (this *sugarScanner) Scan() Scan {
+  return &sugarScan{}
+}
+
+type sugarScan struct {
+  done bool
+}
+
+func (this *sugarScan) Consume(r rune) (string, bool) {
+  if this.done {
+    return "", false
+  }
+  switch {
+  case r == '*' || r == '+' || r == '?':
+    this.done = true
+    return meta_Sugar, false
+  case r <= ' ' || isIdentifierRune(r) || strings.ContainsRune("()[]{}", r):
+    this.done = true
+    return "", false
+  }
+  return "", true
+}
+
+func (this *sugarScan) Reset() {
+  this.done = false
+}
+
+// The sugar kinds of the templates of the hidden labels that list and optional
+// placeholders are desugared into, see Trace.List and Trace.Opt.
+const (
+  sugar_None = iota
+  sugar_Empty // no elements
+  sugar_Elem // a single element: Subs[0]
+  sugar_Wrap // the elements of the non-empty list: Subs[0]
+  sugar_Left // the elements of Subs[0] followed by Subs[1]
+  sugar_Right // Subs[0] followed by the elements of Subs[1]
+)
+
+// sugarT is a list or optional suffix of the grammar source.
+type sugarT struct {
+  kind byte // '*', '+' or '?'
+  sep string
+  left bool // whether the separator is left associative
+  ambit *Ambit
+  used bool
+}
+
+// sugar records the given suffix token if it is attached to a label placeholder (the
+// given previous token), and reports whether it is. The suffix of a list must consist
+// of a BFA or AFB operator (the separator) followed by '*' or '+'. Suffixes that are
+// declared operators themselves are left alone, such that "i++" remains an ordinary
+// template in dialects with a "++" operator. For the same reason "expr?" is not
+// available in dialects that declare "?" as an operator. The suffix is recorded by
+// the offset of the label.
+func (this *metaTokenizerT) sugar(prev *Token, token *Token, next *Token) bool {
+  if prev == nil || prev.Ambit.End != token.Ambit.Start {
+    return false
+  }
+  if symbol := this.symbolTable[prev.Lit]; symbol == nil || symbol.typ != spec_Label {
+    return false
+  }
+  if next != nil && next.Ambit.Start == token.Ambit.End {
+    if r, _ := utf8.DecodeRuneInString(next.Lit); isIdentifierRune(r) {
+      return false
+    }
+  }
+  if this.prfx.lookup(token.Lit) != "" {
+    return false
+  }
+  sep, kind := token.Lit[:len(token.Lit)-1], token.Lit[len(token.Lit)-1]
+  sugar := &sugarT{ kind: kind, sep: sep, ambit: prev.Ambit.Merge(token.Ambit) }
+  if sep == "" {
+    if kind != '?' {
+      return false
+    }
+  } else {
+    if kind == '?' {
+      return false
+    }
+    if this.precedence.precedenceBFA[sep] != 0 {
+      sugar.left = true
+    } else if this.precedence.precedenceAFB[sep] == 0 {
+      return false
+    }
+  }
+  this.sugars[prev.Ambit.Start] = sugar
+  return true
+}
+
+// sugared returns the placeholder for the hidden label that the given label
+// placeholder with a list or optional suffix at the given position desugars into, or
+// the given placeholder itself if it has no such suffix.
+func (this *tpT) sugared(pos int, template *templateT) *templateT {
+  sugar := this.metaTokenizer.sugars[pos]
+  if sugar == nil {
+    return template
+  }
+  sugar.used = true
+  lbl := template.lbl
+  loc := sugar.ambit.Location()
+  desc := this.symbolTable[lbl].desc
+  elem := func() *templateT {
+    return &templateT{ lbl: lbl, subCount: 1 }
+  }
+  empty := &templateT{ matchCat: true, cat: "", loc: loc, sugar: sugar_Empty }
+  if sugar.kind == '?' {
+    hidden := lbl + "?"
+    this.hidden(hidden, "optional " + desc, empty, &templateT{ lbl: lbl, subCount: 1, loc: loc, sugar: sugar_Elem })
+    return &templateT{ lbl: hidden, subCount: 1, name: template.name }
+  }
+  plus := lbl + sugar.sep + "+"
+  var cons *templateT
+  if sugar.left {
+    cons = &templateT{ matchCat: true, cat: "OP", matchLit: true, lit: sugar.sep, subCount: 2,
+                       left: &templateT{ lbl: plus, subCount: 1 }, right: elem(), loc: loc, sugar: sugar_Left }
+  } else {
+    cons = &templateT{ matchCat: true, cat: "OP", matchLit: true, lit: sugar.sep, subCount: 2,
+                       left: elem(), right: &templateT{ lbl: plus, subCount: 1 }, loc: loc, sugar: sugar_Right }
+  }
+  this.hidden(plus, "list of " + desc, cons, &templateT{ lbl: lbl, subCount: 1, loc: loc, sugar: sugar_Elem })
+  if sugar.kind == '+' {
+    return &templateT{ lbl: plus, subCount: 1, name: template.name }
+  }
+  star := lbl + sugar.sep + "*"
+  this.hidden(star, "possibly empty list of " + desc, empty, &templateT{ lbl: plus, subCount: 1, loc: loc, sugar: sugar_Wrap })
+  return &templateT{ lbl: star, subCount: 1, name: template.name }
+}
+
+// hidden defines a hidden label with the given templates, unless it is defined already.
+func (this *tpT) hidden(lbl string, desc string, templates ...*templateT) {
+  if _, present := this.templates[lbl]; present {
+    return
+  }
+  this.templates[lbl] = templates
+  this.hiddenDescs[lbl] = desc
+}
+
+// checkSugar reports list and optional suffixes that are not attached to a label
+// placeholder.
+func checkSugar(sugars map[int]*sugarT) []error {
+  positions := make([]int, 0, len(sugars))
+  for pos, _ := range sugars {
+    positions = append(positions, pos)
+  }
+  sort.Ints(positions)
+  var errs []error
+  for _, pos := range positions {
+    if sugar := sugars[pos]; !sugar.used {
+      errs = append(errs, AmbitError(sugar.ambit, "list or optional suffix not attached to a label placeholder"))
+    }
+  }
+  return errs
+}
+
+// sugarOf returns the kind ('?', '+' or '*') of the hidden label of an optional or list
+// placeholder, together with the element label and the separator of the list. It
+// returns zero for other labels.
+func (this *lang) sugarOf(lbl string) (byte, string, string) {
+  for _, template := range this.templates[lbl] {
+    switch template.sugar {
+    case sugar_Wrap:
+      _, elem, sep := this.sugarOf(template.lbl)
+      return '*', elem, sep
+    case sugar_Elem:
+      if strings.HasSuffix(lbl, "?") {
+        return '?', template.lbl, ""
+      }
+      return '+', template.lbl, strings.TrimSuffix(strings.TrimPrefix(lbl, template.lbl), "+")
+    }
+  }
+  return 0, "", ""
+}
+
+// List returns the element sub-traces of a list placeholder such as "expr,*" or
+// "expr,+" as a flat slice, in order of occurrence, some of which may be ERR traces.
+// It returns nil if this is an ERR trace, or if the list is cut short by one. It
+// panics if this is not the trace of a list placeholder.
+func (this *Trace) List() []*Trace {
+  elems, ok := this.list(nil)
+  if !ok {
+    return nil
+  }
+  return elems
+}
+
+func (this *Trace) list(elems []*Trace) ([]*Trace, bool) {
+  if this.Lbl == "ERR" {
+    return nil, false
+  }
+  if this.tmpl == nil || this.tmpl.sugar == sugar_None {
+    panic(fmt.Sprintf("not a list placeholder: '%s'", this.Lbl))
+  }
+  switch this.tmpl.sugar {
+  case sugar_Elem:
+    return append(elems, this.Subs[0]), true
+  case sugar_Wrap:
+    return this.Subs[0].list(elems)
+  case sugar_Left:
+    elems, ok := this.Subs[0].list(elems)
+    return append(elems, this.Subs[1]), ok
+  case sugar_Right:
+    return this.Subs[1].list(append(elems, this.Subs[0]))
+  }
+  return elems, true // <-- sugar_Empty
+}
+
+// Opt returns the sub-trace of an optional placeholder such as "expr?", or nil if
+// it is absent or if this is an ERR trace. It panics if this is not the trace of an
+// optional placeholder.
+func (this *Trace) Opt() *Trace {
+  if this.Lbl == "ERR" {
+    return nil
+  }
+  if this.tmpl == nil || this.tmpl.sugar == sugar_None {
+    panic(fmt.Sprintf("not an optional placeholder: '%s'", this.Lbl))
+  }
+  if this.tmpl.sugar == sugar_Elem {
+    return this.Subs[0]
+  }
+  return nil
+}
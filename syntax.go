@@ -13,6 +13,9 @@ type Syntax struct {
   OpAmbit *Ambit
   Left *Syntax
   Right *Syntax
+  // Mid contains the arguments in between the functors of a mixfix operator,
+  // for all other nodes it is nil.
+  Mid []*Syntax
 }
 
 func (this *Syntax) mapUnparsedAmbits(f func(ambit *Ambit)string) *Syntax {
@@ -22,9 +25,17 @@ func (this *Syntax) mapUnparsedAmbits(f func(ambit *Ambit)string) *Syntax {
   if this.Cat == "UN" {
     return &Syntax{ Cat: "UN", Lit: f(this.Ambit), Err: this.Err, Ambit: this.Ambit, OpAmbit: this.OpAmbit }
   }
+  var mid []*Syntax
+  if this.Mid != nil {
+    mid = make([]*Syntax, len(this.Mid))
+    for index, sub := range this.Mid {
+      mid[index] = sub.mapUnparsedAmbits(f)
+    }
+  }
   return &Syntax{ Cat: this.Cat, Lit: this.Lit, Err: this.Err, Ambit: this.Ambit, OpAmbit: this.OpAmbit,
                        Left: this.Left.mapUnparsedAmbits(f),
-                       Right: this.Right.mapUnparsedAmbits(f) }
+                       Right: this.Right.mapUnparsedAmbits(f),
+                       Mid: mid }
 }
 
 func (this *Syntax) DumpToString(pretty bool) string {
@@ -72,6 +83,9 @@ func (this *Syntax) dumpPretty(out io.Writer, prfx string) {
   }
   fmt.Fprintf(out, "%s%s:%s\n", prfx, cat, lit)
   this.Left.dumpPretty(out, prfx + "  ")
+  for _, sub := range this.Mid {
+    sub.dumpPretty(out, prfx + "  ")
+  }
   this.Right.dumpPretty(out, prfx + "  ")
 }
 
@@ -82,6 +96,7 @@ func (this *Syntax) dumpRaw(out io.Writer, prfx string) {
   }
   fmt.Fprintf(out, "%s%s:%s:%s:%s\n", prfx, this.Cat, this.Lit, this.Err, this.Ambit.String())
   if this.Left != nil { this.Left.dumpRaw(out, prfx + "  ") }
+  for _, sub := range this.Mid { sub.dumpRaw(out, prfx + "  ") }
   if this.Right != nil { this.Right.dumpRaw(out, prfx + "  ") }
 }
 
@@ -103,6 +118,9 @@ func (this *Syntax) gatherErrors(errs []error) []error {
     return append(errs, AmbitError( this.Ambit, this.Err))
   }
   errs = this.Left.gatherErrors(errs)
+  for _, sub := range this.Mid {
+    errs = sub.gatherErrors(errs)
+  }
   errs = this.Right.gatherErrors(errs)
   return errs
 }
@@ -149,6 +167,12 @@ func (this *Syntax) First(cat, lit string) *Syntax {
   if node != nil {
     return node
   }
+  for _, sub := range this.Mid {
+    node = sub.First(cat, lit)
+    if node != nil {
+      return node
+    }
+  }
   return this.Right.First(cat, lit)
 }
 
@@ -168,5 +192,8 @@ func (this *Syntax) listFirstN(list []*Syntax, cat, lit string, n int) []*Syntax
     list = append(list, this)
   }
   list = this.Left.listFirstN(list, cat, lit, n)
+  for _, sub := range this.Mid {
+    list = sub.listFirstN(list, cat, lit, n)
+  }
   return this.Right.listFirstN(list, cat, lit, n)
 }
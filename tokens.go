@@ -29,10 +29,13 @@ type Tokenizer interface {
 
 type tokenizer struct {
   scan Scan
+  words map[string]bool
 }
 
-func newTokenizer(scanner Scanner) Tokenizer {
- return &tokenizer{ scan: scanner.Scan() }
+// newTokenizer returns a tokenizer for the given scanner, tokens whose literal is
+// one of the given words are reinterpreted as operators.
+func newTokenizer(scanner Scanner, words map[string]bool) Tokenizer {
+ return &tokenizer{ scan: scanner.Scan(), words: words }
 }
 
 func (this *Token) String() string {
@@ -118,6 +121,9 @@ func (this *tokenizer) Tokenize(ambit *Ambit) []*Token {
       token = &Token{ Cat: tokenCat, Lit: tokenAmbit.ToString(), Err: fmt.Sprintf("unexpected character(s): '%s'", tokenAmbit.ToString()), Ambit: tokenAmbit }
     } else {
       token = &Token{ Cat: tokenCat, Lit: tokenAmbit.ToString(), Ambit: tokenAmbit }
+      if this.words[token.Lit] {
+        token.Cat = "OP"
+      }
     }
     tokens = append(tokens, token)
     ambit = restAmbit
@@ -13,7 +13,7 @@ func TestTokens(t *testing.T) {
     &seqScanner{PrefixScanner("OP +=", "OP ===", "OB ( [ { <%", "CB } ] ) %>"),
                 DefaultScanner}
 
-  tokenizer := newTokenizer(scanner)
+  tokenizer := newTokenizer(scanner, nil)
   tokens := tokenizer.Tokenize(ambit)
   res := fmt.Sprintf("%s", tokens)
   tgt := `[ID:a WS OP:+= WS NUM:1 WS STR:"hello(), \n" OB:( CB:) OB:[ ID:world CB:] ERR:unexpected character(s): ',' WS OB:<% WS ERR:unexpected character(s): '***' CB:%> WS ERR:unexpected character(s): '"' OP:===]`
@@ -11,29 +11,64 @@ type Tracer interface {
   Trace(ambit *Ambit, lbl string) *Trace
   TraceUndent(source *Source, lbl string) *Trace
   Dump(out io.Writer, prfx string)
+  // Alts returns the names of the alternatives of the given label by index, such
+  // that Alts(trace.Lbl)[trace.Idx] == trace.Alt. Unnamed alternatives have the
+  // empty string as their name.
+  Alts(lbl string) []string
+  // Actions returns a new, empty set of semantic actions for the grammar, see Actions.
+  Actions() Actions
+  // Backtracking returns a tracer for the same grammar that does not commit to the
+  // first alternative of a label that matches the shape of a syntax node: if tracing
+  // the sub-traces of that alternative fails, the later alternatives are tried in
+  // order. If all alternatives fail, the trace of the alternative with the deepest
+  // failure is returned. A label that (indirectly) traces a node with itself fails on
+  // the inner occurrence. The outcome of tracing a node with a label is memoized,
+  // unless it depends on such a failure: tracing takes polynomial time for grammars
+  // without these cycles, but may take exponential time for grammars with them.
+  Backtracking() Tracer
+  // Ambiguities is a diagnostic variant of Trace that evaluates all the alternatives
+  // of the labels rather than applying the first one that matches, and reports every
+  // node that is accepted by more than one alternative of a label, see Ambiguity. Only
+  // the nodes that take part in some accepting trace of the whole ambit are reported,
+  // ordered by position (enclosing nodes first). No ambiguities are reported if the
+  // ambit is not accepted at all.
+  Ambiguities(ambit *Ambit, lbl string) []*Ambiguity
+  // AmbiguitiesUndent is Ambiguities for TraceUndent.
+  AmbiguitiesUndent(source *Source, lbl string) []*Ambiguity
 }
 
 type tracer struct {
   sparser Sparser
   templates map[string][]*templateT
   descriptions map[string]string
+  messages map[string]string // error messages of labels, see Spec.Grammar
+  index map[string]*templateIndexT // the alternatives of labels by category and literal
+  backtracking bool // see Backtracking
 }
 
 // A node in an acceptance trace of a top down deterministic finite tree automaton.
 // The Lbl field containsthe label for the current tree automaton state,
 // the Idx field contains the index of the transition rule that was applied,
+// the Alt field contains the name of that rule (if it was given one in the grammar,
+// for example: "or> [call] f(args)", a name may not be a declared symbol),
 // the Syn field refers to the current node of the syntax tree over which the tree
 // automaton was run, the Subs field contains the subtraces in order of a
-// left-to-right traversal of the transition rule template.
+// left-to-right traversal of the transition rule template. Named placeholders can be
+// looked up with the Sub and Cat methods instead.
 // If there was no rule that could be applied the Lbl will be "ERR" and the Err field
-// will contain a descriptive error message.
+// will contain a descriptive error message. If some rules partially match the node,
+// the message tells what the closest of them expected, and the error (see ErrorN) is
+// located at the child node where they fail to match rather than at the whole node.
 type Trace struct {
   Lbl string
   Idx int
+  Alt string
   Syn *Syntax
   Err string
   Subs []*Trace
   Cats []*Syntax
+  tmpl *templateT // the rule alternative that was applied, see Sub and Cat
+  ambit *Ambit // the ambit of the error, if it is narrowed down from Syn
 }
 
 type templateT struct {
@@ -47,6 +82,16 @@ type templateT struct {
   litSet map[string]bool
   left *templateT
   right *templateT
+  mid []*templateT
+  loc string // location of the rule alternative, only set on top level templates
+  ambit *Ambit // region of the rule alternative, only set on top level templates that are not decoded
+  alt string // name of the rule alternative, only set on top level templates
+  name string // name of the placeholder, see Trace.Sub and Trace.Cat
+  subNames map[string]int // indices of named sub-traces, only set on top level templates
+  catNames map[string]int // indices of named categories, only set on top level templates
+  sugar int // see Trace.List and Trace.Opt, only set on templates of hidden labels
+  msg string // custom error message of the rule alternative, only set on top level templates
+  reject string // error message of an error production, only set on top level templates
 }
 
 type waitingItemT struct {
@@ -119,6 +164,9 @@ func (this *Trace) gatherErrors(errs []error) []error {
     return errs
   }
   if this.Lbl == "ERR" {
+    if this.ambit != nil {
+      return append(errs, AmbitError(this.ambit, this.Err))
+    }
     return append(errs, AmbitError(this.Syn.Ambit, this.Err))
   }
   for _, sub := range this.Subs {
@@ -147,11 +195,19 @@ func (this *templateT) dump(out io.Writer, prfx string) {
   }
   fmt.Fprintf(out, "%s%s:%s:%s:%d\n", prfx, this.lbl, this.cat, this.lit, this.subCount)
   this.left.dump(out, prfx+"  ")
+  for _, mid := range this.mid {
+    mid.dump(out, prfx+"  ")
+  }
   this.right.dump(out, prfx+"  ")
 }
 
-func newTracer(sparser Sparser, templates map[string][]*templateT, descriptions map[string]string) Tracer {
-  return &tracer{ sparser: sparser, templates: templates, descriptions: descriptions }
+func newTracer(sparser Sparser, templates map[string][]*templateT, descriptions map[string]string,
+               messages map[string]string) Tracer {
+  index := make(map[string]*templateIndexT, len(templates))
+  for lbl, alts := range templates {
+    index[lbl] = newTemplateIndex(alts)
+  }
+  return &tracer{ sparser: sparser, templates: templates, descriptions: descriptions, messages: messages, index: index }
 }
 
 func (this *tracer) Trace(ambit *Ambit, lbl string) *Trace {
@@ -165,6 +221,9 @@ func (this *tracer) TraceUndent(source *Source, lbl string) *Trace {
 }
 
 func (this *tracer) label(root *Syntax, lbl string) *Trace {
+  if this.backtracking {
+    return this.backtrack(root, lbl)
+  }
   start := &Trace{ Lbl: lbl, Syn: root }
   waiting := &waitingT{ list: []waitingItemT{ waitingItemT{ node: root, trace: start } } }
   
@@ -180,9 +239,16 @@ func (this *tracer) label(root *Syntax, lbl string) *Trace {
     
     templates := this.templates[trace.Lbl]
     matched := false
-    for idx, template := range templates {
-      if template.checkMatch(node) {
+    for _, idx := range this.candidates(node, trace.Lbl) {
+      if template := templates[idx]; template.checkMatch(node) {
         trace.Idx = idx
+        trace.Alt = template.alt
+        matched = true
+        if template.reject != "" {
+          trace.Lbl, trace.Err = "ERR", template.reject
+          break
+        }
+        trace.tmpl = template
         if template.subCount > 0 {
           trace.Subs = make([]*Trace, template.subCount)
         }
@@ -190,13 +256,11 @@ func (this *tracer) label(root *Syntax, lbl string) *Trace {
           trace.Cats = make([]*Syntax, template.catCount)
         }
         template.performMatch(node, waiting, 0, trace.Subs, 0, trace.Cats)
-        matched = true
         break
       }
     }
     if !matched {
-      trace.Err = fmt.Sprintf("expected: %s", this.descriptions[trace.Lbl])
-      trace.Lbl = "ERR"
+      this.fail(trace, node)
     }
   }
 
@@ -211,6 +275,14 @@ func (this *templateT) checkMatch(node *Syntax) bool {
     return false
   }
   if this.left != nil { // implies this.right != nil
+    if len(this.mid) != len(node.Mid) {
+      return false
+    }
+    for index, mid := range this.mid {
+      if !mid.checkMatch(node.Mid[index]) {
+        return false
+      }
+    }
     return this.left.checkMatch(node.Left) && this.right.checkMatch(node.Right)
   }
   return true
@@ -231,6 +303,9 @@ func (this *templateT) performMatch(node *Syntax, waiting *waitingT, subi int, s
       cati++      
     }
     subi, cati = this.left.performMatch(node.Left, waiting, subi, subs, cati, cats)
+    for index, mid := range this.mid {
+      subi, cati = mid.performMatch(node.Mid[index], waiting, subi, subs, cati, cats)
+    }
     subi, cati = this.right.performMatch(node.Right, waiting, subi, subs, cati, cats)
     return subi, cati
   }
@@ -243,7 +318,8 @@ func (this *templateT) performMatch(node *Syntax, waiting *waitingT, subi int, s
 }
 
 func (this *tracer) Dump(out io.Writer, prfx string) {
-  for lbl, templates := range this.templates {
+  for _, lbl := range sortedKeys(this.templates) {
+    templates := this.templates[lbl]
     prfx2 := fmt.Sprintf("%s%s> ", prfx, lbl)
     for index, template := range templates {
       prfx3 := fmt.Sprintf("%s%d> ", prfx2, index)
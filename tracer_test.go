@@ -2,6 +2,9 @@ package dusl
 
 import (
 	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
 	"testing"
 )
 
@@ -111,3 +114,763 @@ func TestTracer(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestTracerMixfix(t *testing.T) {
+	lang, err := NewSpec().
+		Lexical(DefaultScanner).
+		Category("ID", "identifier").
+		OperatorBFA("==").
+		OperatorMixfix("? :").
+		Label("X", "expression").
+		Grammar(`
+      X is> ID or> X == X or> X ? X : X`)
+
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	trace := lang.Tracer().Trace(AmbitFromString(`a == b ? c : d ? e : f`), "X")
+
+	buf := new(bytes.Buffer)
+	trace.Dump(buf, "> ", false)
+	res := buf.String()
+	tgt := `> X:2:OP:? ::str[0:22]
+>   X:1:OP:==:str[0:6]
+>     X:0:ID:a:str[0:1]
+>     X:0:ID:b:str[5:6]
+>   X:0:ID:c:str[9:10]
+>   X:2:OP:? ::str[13:22]
+>     X:0:ID:d:str[13:14]
+>     X:0:ID:e:str[17:18]
+>     X:0:ID:f:str[21:22]
+`
+	if res != tgt {
+		t.Log(res)
+		t.Fail()
+	}
+}
+
+func TestTracerApplication(t *testing.T) {
+	lang, err := NewSpec().
+		Lexical(DefaultScanner).
+		Category("ID", "identifier").
+		Application("( )").
+		OperatorEFA("-").
+		Brackets("( )").
+		Label("X", "expression").
+		Grammar(`
+      X is> ID or> -X or> X() or> X(X)`)
+
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	trace := lang.Tracer().Trace(AmbitFromString(`-f(x)()`), "X")
+
+	buf := new(bytes.Buffer)
+	trace.Dump(buf, "> ", false)
+	res := buf.String()
+	tgt := `> X:1:OP:-:str[0:7]
+>   X:2:APP:( ):str[1:7]
+>     X:3:APP:( ):str[1:5]
+>       X:0:ID:f:str[1:2]
+>       X:0:ID:x:str[3:4]
+`
+	if res != tgt {
+		t.Log(res)
+		t.Fail()
+	}
+}
+
+func TestTracerAlts(t *testing.T) {
+	lang, err := LoadSpec(SourceFromString(`lexical default
+category ID "identifier"
+category NUM "number"
+application ( )
+operator BFA +
+brackets ( ) [ ] { }
+sequence XSQ "expression sequence"
+label X "expression"
+label A "arguments"
+grammar
+XSQ is> [more]
+  X
+  XSQ
+or> [done]
+  <empty
+X is> [group] (X) or> [list] [X] or> NUM or> [var] ID or> [call] X(A) or> [add] X + X
+A is> X or> <empty
+`))
+
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	res := lang.Tracer().TraceUndent(SourceFromString("f(1) + [x]"), "XSQ")
+	alts := []string{}
+	for _, trace := range []*Trace{ res, res.Subs[0], res.Subs[0].Subs[0], res.Subs[0].Subs[1], res.Subs[1] } {
+		alts = append(alts, trace.Alt)
+	}
+	if strings.Join(alts, " ") != "more add call list done" {
+		t.Log(alts)
+		t.Fail()
+	}
+
+	if alts := lang.Tracer().Alts("X"); strings.Join(alts, ",") != "group,list,,var,call,add" {
+		t.Log(alts)
+		t.Fail()
+	}
+
+	for _, tst := range []struct{ grammar string; err string }{
+		{ "X is> [var] ID or> [var] NUM\nA is> X\n",
+			"str:10:25:28: double declaration of alternative name for label 'X': 'var' (first declared at: str:10:12:14)\n" },
+		{ "X is> [var] ID # or> [commented]\nA is> [[y]]\n",
+			"str:11:8:9: undeclared symbol: 'y'\n" },
+		{ "X is> [Y] or> ID\nA is> X\n",
+			"str:10:6:9: alternative name conflicts with declared symbol, write '[ Y ]' to match it in brackets: 'Y'\n" },
+		{ "X is> [ Y ] or> ID\nA is> [list] [Y]\n",
+			"" },
+	} {
+		_, err := LoadSpec(SourceFromString(`lexical default
+category ID "identifier"
+category NUM "number"
+brackets [ ]
+label X "expression"
+label A "arguments"
+label Y "other"
+grammar
+Y is> ID
+` + tst.grammar))
+		if (err == nil && tst.err != "") || (err != nil && err.Error() != tst.err) {
+			t.Log(err)
+			t.Fail()
+		}
+	}
+}
+
+func TestTracerNames(t *testing.T) {
+	lang, err := LoadSpec(SourceFromString(`lexical default
+category ID "identifier"
+category NUM "number"
+operator BFA = + -
+juxtaposition LWA if
+shorthand ~infix~ + -
+sequence S "statement"
+label X "expression"
+grammar
+S is> [branch]
+  if cond:X
+    body:S
+  rest:S
+or> [assign]
+  name:ID = value:X
+  rest:S
+or>
+  <empty
+X is> n:NUM or> v:ID or> lhs:X op:~infix~ rhs:X
+`))
+
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	trace := lang.Tracer().TraceUndent(SourceFromString("if a - 1\n  b = 2\n"), "S")
+	if err := trace.ErrorN(20); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	cond := trace.Sub("cond")
+	assign := trace.Sub("body")
+	res := []string{ cond.Cat("op").Lit, cond.Sub("lhs").Cat("v").Lit, cond.Sub("rhs").Cat("n").Lit,
+	                 assign.Cat("name").Lit, assign.Sub("value").Cat("n").Lit, trace.Sub("rest").Syn.Cat }
+	if strings.Join(res, " ") != "- a 1 b 2 " {
+		t.Log(res)
+		t.Fail()
+	}
+
+	func() {
+		defer func() {
+			if msg := recover(); msg != "no sub-trace named 'cond' in alternative 1 [assign] of 'S' at: str:15:2:17:0" {
+				t.Log(msg)
+				t.Fail()
+			}
+		}()
+		assign.Sub("cond")
+	}()
+
+	for _, tst := range []struct{ grammar string; err string }{
+		{ "X is> a:ID + a:X\n",
+			"str:8:6:16: double declaration of placeholder name: 'a'\n" },
+		{ "X is> a:noop or> end X\n",
+			"str:8:6:7: placeholder name not attached to a label, category or shorthand operator: 'a'\n" },
+		{ "X is> X + X\nlhs:X is> ID\n",
+			"str:9:0:3: placeholder name not attached to a label, category or shorthand operator: 'lhs'\n" },
+		{ "X is> ID # unused a:X\nX is> a:noop\n",
+			"str:9:6:7: placeholder name not attached to a label, category or shorthand operator: 'a'\n" },
+	} {
+		_, err := LoadSpec(SourceFromString(`lexical default
+category ID "identifier"
+operator BFA +
+juxtaposition LWA end
+literal noop
+label X "expression"
+grammar
+` + tst.grammar))
+		if err == nil || err.Error() != tst.err {
+			t.Log(err)
+			t.Fail()
+		}
+	}
+}
+
+func TestTracerSugar(t *testing.T) {
+	lang, err := LoadSpec(SourceFromString(`lexical default
+category ID "identifier"
+category NUM "number"
+application ( )
+operator AFE ++
+operator BFA + -
+operator BFA ,
+operator AFB ;
+brackets ( ) [ ] { }
+label X "expression"
+label S "statement"
+grammar
+X is> [call] f:ID(args:X,*) or> [list] [elems:X;+] or> [opt] {X?} or> [inc] ID++ or> ID or> NUM or> X + X
+S is> X # comment with a sugar look-alike: X,*
+`))
+
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	for _, tst := range []struct{ src string; res string }{
+		{ "f()", "" },
+		{ "f(1)", "1" },
+		{ "f(1, a, 2 + 3)", "1 a +" },
+		{ "[1; a; 2]", "1 a 2" },
+	} {
+		trace := lang.Tracer().Trace(AmbitFromString(tst.src), "X")
+		if err := trace.ErrorN(20); err != nil {
+			t.Log(err)
+			t.Fail()
+			continue
+		}
+		var sub *Trace
+		if trace.Alt == "call" {
+			sub = trace.Sub("args")
+		} else {
+			sub = trace.Sub("elems")
+		}
+		lits := []string{}
+		for _, elem := range sub.List() {
+			lits = append(lits, elem.Syn.Lit)
+		}
+		if strings.Join(lits, " ") != tst.res {
+			t.Log(tst.src, lits)
+			t.Fail()
+		}
+	}
+
+	if trace := lang.Tracer().Trace(AmbitFromString("{1}"), "X"); trace.Alt != "opt" || trace.Subs[0].Opt().Syn.Lit != "1" {
+		t.Log(trace.DumpToString(true))
+		t.Fail()
+	}
+	if trace := lang.Tracer().Trace(AmbitFromString("{}"), "X"); trace.Alt != "opt" || trace.Subs[0].Opt() != nil {
+		t.Log(trace.DumpToString(true))
+		t.Fail()
+	}
+
+	if elem := lang.Tracer().Trace(AmbitFromString("{;}"), "X").Subs[0].Opt(); elem == nil || elem.Lbl != "ERR" ||
+			elem.Opt() != nil || elem.List() != nil {
+		t.Log(elem)
+		t.Fail()
+	}
+
+	if trace := lang.Tracer().Trace(AmbitFromString("i++"), "X"); trace.Alt != "inc" {
+		t.Log(trace.DumpToString(true))
+		t.Fail()
+	}
+
+	if errs := lang.Lint("S"); len(errs) != 0 {
+		t.Log(errs)
+		t.Fail()
+	}
+
+	buf := new(bytes.Buffer)
+	lang.Encode(buf)
+	decoded, _ := DecodeLang(buf)
+	if elems := decoded.Tracer().Trace(AmbitFromString("f(1, 2)"), "X").Sub("args").List(); len(elems) != 2 {
+		t.Log(elems)
+		t.Fail()
+	}
+
+	_, err = LoadSpec(SourceFromString(`lexical default
+category ID "identifier"
+operator BFA ,
+label X "expression"
+grammar
+X is> ID or> X,* # X?
+X? is> ID
+`))
+	if err == nil || err.Error() != "str:7:0:2: list or optional suffix not attached to a label placeholder\n" {
+		t.Log(err)
+		t.Fail()
+	}
+}
+
+func TestTracerBacktracking(t *testing.T) {
+	lang, err := NewSpec().
+		Lexical(DefaultScanner).
+		Category("ID", "identifier").
+		Category("NUM", "number").
+		OperatorBFA("+").
+		OperatorAFB("=").
+		Brackets("( )").
+		Label("S", "statement").
+		Label("V", "variable").
+		Label("X", "expression").
+		Grammar(`
+      S is> [assign] V = X or> [expr] X
+      V is> ID
+      X is> X = X or> X + X or> NUM or> ID`)
+
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	if err := lang.Tracer().Trace(AmbitFromString("1 = 2"), "S").ErrorN(20); err == nil {
+		t.Log("expected the committing tracer to fail")
+		t.Fail()
+	}
+
+	backtracking := lang.Tracer().Backtracking()
+	for _, tst := range []struct{ src string; alt string }{
+		{ "x = 1 + 2", "assign" },
+		{ "1 = 2", "expr" },
+		{ "x + 1 = 2", "expr" },
+	} {
+		trace := backtracking.Trace(AmbitFromString(tst.src), "S")
+		if err := trace.ErrorN(20); err != nil || trace.Alt != tst.alt {
+			t.Logf("%s: expected alternative %s, got: %s %v", tst.src, tst.alt, trace.Alt, err)
+			t.Fail()
+		}
+	}
+
+	// the memoized traces are copied when they are handed out again:
+	backtracker := &backtrackerT{ tracer: backtracking.(*tracer), memo: make(map[backtrackKeyT]*backtrackResultT),
+		inProgress: make(map[backtrackKeyT]int) }
+	root := lang.Sparser().Sparse(AmbitFromString("x + 1 = 2"))
+	first, _ := backtracker.label(root, "S")
+	second, _ := backtracker.label(root, "S")
+	if first.trace.DumpToString(true) != second.trace.DumpToString(true) || sharesTraces(first.trace, second.trace) {
+		t.Log(second.trace.DumpToString(true))
+		t.Fail()
+	}
+
+	trace := backtracking.Trace(AmbitFromString("1 = (2)"), "S")
+	if err := trace.ErrorN(20); err == nil || err.Error() != "str:1:4:7: expected: expression\n" || trace.Alt != "expr" {
+		t.Logf("expected the deepest failure, got: %s %v", trace.Alt, err)
+		t.Fail()
+	}
+
+	// the failure of B that is provisional while A is in progress is not memoized:
+	cyclic, err := NewSpec().
+		Lexical(DefaultScanner).
+		Category("ID", "identifier").
+		Category("NUM", "number").
+		OperatorBFA("+").
+		Label("P", "program").
+		Label("A", "a").
+		Label("B", "b").
+		Label("Q", "q").
+		Grammar(`
+      P is> A + Q or> B + NUM
+      A is> B or> ID
+      B is> A or> NUM
+      Q is> ID`)
+
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	trace = cyclic.Tracer().Backtracking().Trace(AmbitFromString("x + 1"), "P")
+	if err := trace.ErrorN(20); err != nil || trace.Idx != 1 || trace.Subs[0].Lbl != "B" {
+		t.Logf("expected the second alternative, got: %d %v", trace.Idx, err)
+		t.Fail()
+	}
+}
+
+// sharesTraces reports whether the given traces have a sub-trace in common.
+func sharesTraces(this *Trace, that *Trace) bool {
+	seen := make(map[*Trace]bool)
+	var collect func(trace *Trace)
+	collect = func(trace *Trace) {
+		seen[trace] = true
+		for _, sub := range trace.Subs {
+			collect(sub)
+		}
+	}
+	collect(this)
+	var shared func(trace *Trace) bool
+	shared = func(trace *Trace) bool {
+		if seen[trace] {
+			return true
+		}
+		for _, sub := range trace.Subs {
+			if shared(sub) {
+				return true
+			}
+		}
+		return false
+	}
+	return shared(that)
+}
+
+func TestTracerAmbiguities(t *testing.T) {
+	lang, err := NewSpec().
+		Lexical(DefaultScanner).
+		Category("ID", "identifier").
+		Category("NUM", "number").
+		OperatorBFA("+").
+		OperatorAFB("=").
+		Label("S", "statement").
+		Label("V", "variable").
+		Label("X", "expression").
+		Grammar(`
+      S is> V = X or> X
+      V is> ID
+      X is> X = X or> X + X or> NUM or> ID or> V`)
+
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	tracer := lang.Tracer()
+	var res []string
+	for _, ambiguity := range tracer.Ambiguities(AmbitFromString("x = 1 + y"), "S") {
+		res = append(res, ambiguity.String())
+	}
+	tgt := []string{
+		"str:1:0:9: ambiguous 'S', accepted by alternatives: 0, 1",
+		"str:1:0:1: ambiguous 'X', accepted by alternatives: 3, 4",
+		"str:1:8:9: ambiguous 'X', accepted by alternatives: 3, 4",
+	}
+	if strings.Join(res, "\n") != strings.Join(tgt, "\n") {
+		t.Logf("unexpected ambiguities:\n%s", strings.Join(res, "\n"))
+		t.Fail()
+	}
+
+	if ambiguities := tracer.Ambiguities(AmbitFromString("1 + 2"), "S"); len(ambiguities) != 0 {
+		t.Logf("unexpected ambiguities: %v", ambiguities)
+		t.Fail()
+	}
+
+	// the outcome of B that is provisional while A is in progress is not memoized:
+	cyclic, err := NewSpec().
+		Lexical(DefaultScanner).
+		Category("ID", "identifier").
+		Category("NUM", "number").
+		OperatorBFA("+").
+		Label("P", "program").
+		Label("A", "a").
+		Label("B", "b").
+		Label("Q", "q").
+		Grammar(`
+      P is> A + Q or> B + NUM
+      A is> B or> ID
+      B is> A or> ID
+      Q is> ID`)
+
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	res = nil
+	for _, ambiguity := range cyclic.Tracer().Ambiguities(AmbitFromString("x + 1"), "P") {
+		res = append(res, ambiguity.String())
+	}
+	tgt = []string{
+		"str:1:0:1: ambiguous 'B', accepted by alternatives: 0, 1",
+		"str:1:0:1: ambiguous 'A', accepted by alternatives: 0, 1",
+	}
+	if strings.Join(res, "\n") != strings.Join(tgt, "\n") {
+		t.Logf("unexpected ambiguities:\n%s", strings.Join(res, "\n"))
+		t.Fail()
+	}
+}
+
+func TestTracerErrors(t *testing.T) {
+	lang, err := NewSpec().
+		Lexical(DefaultScanner).
+		Category("ID", "identifier").
+		Category("NUM", "number").
+		OperatorBFA("+").
+		OperatorBFA(",").
+		OperatorMixfix("? :").
+		Brackets("( )", "[ ]").
+		Application("( )", "[ ]").
+		JuxtapositionLWA("if", "return", "while").
+		SentenceLabel("S", "statement").
+		SequenceLabel("Q", "statements").
+		Label("X", "expression").
+		Grammar(`
+      Q is>
+        S
+        Q
+      or>
+        <empty
+
+      S is>
+        if X
+          Q
+      or>
+        return X
+      or>
+        X
+
+      X is> f:ID(args:X,*) or> a:ID[i:X] or> X + X or> NUM or> ID or> X ? X : X`)
+
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	for _, tst := range []struct{ src string; err string }{
+		{ "f(1, [2])", "str:1:5:8: expected: expression\n" },
+		{ "g(1)(2)", "str:1:0:4: expected: identifier before '('\n" },
+		{ "g(1)[2]", "str:1:0:4: expected: identifier before '['\n" },
+		{ "while x\n  1", "str:1:0:5: expected: 'if' or 'return'\n" },
+		{ "x\n  1", "str:2:2:3: unexpected: indented body\n" },
+		{ "if x\n  1\n  [3]", "str:3:2:5: expected: expression\n" },
+	} {
+		err := lang.Tracer().TraceUndent(SourceFromString(tst.src), "Q").ErrorN(20)
+		if err == nil || err.Error() != tst.err {
+			t.Logf("%q: expected %q, got: %v", tst.src, tst.err, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestTracerDirectives(t *testing.T) {
+	spec := func() Spec {
+		return NewSpec().
+			Lexical(DefaultScanner).
+			Category("ID", "identifier").
+			Category("NUM", "number").
+			OperatorBFA("+").
+			OperatorBFB("==").
+			OperatorAFB("=").
+			Brackets("( )").
+			JuxtapositionLWA("if", "while").
+			SentenceLabel("S", "statement").
+			SequenceLabel("Q", "statements").
+			Label("C", "condition").
+			Label("X", "expression")
+	}
+
+	lang, err := spec().Grammar(`
+      Q is>
+        S
+        Q
+      or>
+        <empty
+
+      # A statement.
+      #! error: expected 'if <condition>' with an indented body
+      S is>
+        if C
+          Q
+      or>
+        X
+
+      #! error X: expected a number, a name or a sum
+      #! reject: '=' in a condition, use '=='
+      C is> X = X
+      C is> X == X or> X
+      X is> X + X or> NUM or> ID`)
+
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	for _, tst := range []struct{ src string; err string }{
+		{ "if x == 1\n  y", "" },
+		{ "if x = 1\n  y", "str:1:3:8: '=' in a condition, use '=='\n" },
+		{ "while x\n  y", "str:1:0:5: expected 'if <condition>' with an indented body\n" },
+		{ "if (1)\n  y", "str:1:3:6: expected a number, a name or a sum\n" },
+	} {
+		for _, tracer := range []Tracer{ lang.Tracer(), lang.Tracer().Backtracking() } {
+			err := tracer.TraceUndent(SourceFromString(tst.src), "Q").ErrorN(20)
+			if (err == nil && tst.err != "") || (err != nil && err.Error() != tst.err) {
+				t.Logf("%q: expected %q, got: %v", tst.src, tst.err, err)
+				t.Fail()
+			}
+		}
+	}
+
+	if ambiguities := lang.Tracer().AmbiguitiesUndent(SourceFromString("if x = 1\n  y"), "Q"); len(ambiguities) != 0 {
+		t.Logf("unexpected ambiguities: %v", ambiguities)
+		t.Fail()
+	}
+
+	if info := lang.Label("S"); info.Doc != "A statement." || info.Alts[0].Error == "" || info.Alts[1].Error != "" {
+		t.Logf("unexpected label info: %v", info)
+		t.Fail()
+	}
+
+	for _, tst := range []struct{ grammar string; err string }{
+		{ "#! error: x\n\nX is> ID", "str:1:0:11: error directive not followed by a rule\n" },
+		{ "#! warn: x\nX is> ID", "str:1:0:10: expected directive: '#! error: ...', '#! error <label>: ...' or '#! reject: ...'\n" },
+		{ "#! error Y: x\nX is> ID", "str:1:0:13: error directive for undeclared label: 'Y'\n" },
+		{ "X is> ID #! error: x\n  #! reject: y\n\nX is> NUM", "str:2:2:14: reject directive not followed by a rule\n" },
+	} {
+		_, err := spec().GrammarFromSource(SourceFromString(tst.grammar))
+		if err == nil || err.Error() != tst.err {
+			t.Logf("%q: expected %q, got: %v", tst.grammar, tst.err, err)
+			t.Fail()
+		}
+	}
+}
+
+// randomTracerGrammar returns random grammar rules for randomTracerSpec. The labels
+// are numbered, and only refer to a label with a higher number by a unit alternative,
+// such that tracing terminates.
+func randomTracerGrammar(rnd *rand.Rand) string {
+	forms := []string{"L + L", "L - L", "L = L", "-L", "!L", "L!", "L ? L : L", "(L)", "[ L ]", "[L,*]", "L(L)",
+		"f L", "L ~arith~ L", "NUM", "ID", "not"}
+	lbls := []string{"S", "X", "Y", "Z"}
+	buf := new(bytes.Buffer)
+	for index, lbl := range lbls {
+		fmt.Fprintf(buf, "%s is>", lbl)
+		for alt := 1+rnd.Intn(6); alt > 0; alt-- {
+			if index+1 < len(lbls) && rnd.Intn(6) == 0 {
+				fmt.Fprintf(buf, " %s", lbls[index+1+rnd.Intn(len(lbls)-index-1)])
+			} else {
+				form := forms[rnd.Intn(len(forms))]
+				for strings.Contains(form, "L") {
+					form = strings.Replace(form, "L", lbls[rnd.Intn(len(lbls))], 1)
+				}
+				fmt.Fprintf(buf, " %s", form)
+			}
+			if alt > 1 {
+				buf.WriteString(" or>")
+			}
+		}
+		if rnd.Intn(2) == 0 {
+			buf.WriteString(" or> ID or> NUM")
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+func randomTracerSpec() Spec {
+	return NewSpec().
+		Lexical(DefaultScanner).
+		Category("ID", "identifier").
+		Category("NUM", "number").
+		Literal("not").
+		OperatorBFA("+", "-").
+		OperatorBFA(",").
+		OperatorEFA("-", "!").
+		OperatorAFE("!").
+		OperatorAFB("=").
+		OperatorMixfix("? :").
+		JuxtapositionLWA("f").
+		Brackets("( )", "[ ]").
+		Application("( )").
+		ShorthandOperator("~arith~", "+", "-").
+		Label("S", "statement").
+		Label("X", "expression").
+		Label("Y", "other expression").
+		Label("Z", "last expression")
+}
+
+// randomTracerText writes a random text of operands separated by binary operators,
+// which often (but not always) traces with the grammars of randomTracerGrammar.
+func randomTracerText(rnd *rand.Rand, buf *bytes.Buffer, depth int) {
+	operands := []string{"a", "b", "1", "not"}
+	binary := []string{"+", "-", "=", ",", "?", ":", ""}
+	for i := rnd.Intn(4); i >= 0; i-- {
+		switch rnd.Intn(6) {
+		case 0:
+			buf.WriteString([]string{"-", "!", "f "}[rnd.Intn(3)])
+		}
+		if depth < 3 && rnd.Intn(5) == 0 {
+			brackets := [][2]string{{"(", ")"}, {"[", "]"}}[rnd.Intn(2)]
+			buf.WriteString(brackets[0])
+			randomTracerText(rnd, buf, depth+1)
+			buf.WriteString(brackets[1])
+		} else {
+			buf.WriteString(operands[rnd.Intn(len(operands))])
+		}
+		if rnd.Intn(6) == 0 {
+			buf.WriteString("!")
+		}
+		if i > 0 {
+			fmt.Fprintf(buf, " %s ", binary[rnd.Intn(len(binary))])
+		}
+	}
+}
+
+func TestTracerIndex(t *testing.T) {
+
+	rnd := rand.New(rand.NewSource(1))
+
+	for grammarIndex := 0; grammarIndex < 100; grammarIndex++ {
+		grammar := randomTracerGrammar(rnd)
+		lang, err := randomTracerSpec().GrammarFromSource(SourceFromString(grammar))
+		if err != nil {
+			t.Logf("%s%v", grammar, err)
+			t.Fail()
+			return
+		}
+		indexed := lang.Tracer().(*tracer)
+		linear := &tracer{ sparser: indexed.sparser, templates: indexed.templates, descriptions: indexed.descriptions,
+			messages: indexed.messages, index: make(map[string]*templateIndexT) }
+		for lbl, templates := range linear.templates {
+			index := &templateIndexT{}
+			for idx := range templates {
+				index.any = append(index.any, idx)
+			}
+			linear.index[lbl] = index
+		}
+		for textIndex := 0; textIndex < 50; textIndex++ {
+			buf := new(bytes.Buffer)
+			randomTracerText(rnd, buf, 0)
+			for _, tracers := range [][2]Tracer{ { linear, indexed }, { linear.Backtracking(), indexed.Backtracking() } } {
+				expected := tracers[0].Trace(AmbitFromString(buf.String()), "S")
+				got := tracers[1].Trace(AmbitFromString(buf.String()), "S")
+				if got.DumpToString(false) != expected.DumpToString(false) || fmt.Sprint(got.ErrorN(20)) != fmt.Sprint(expected.ErrorN(20)) {
+					t.Logf("%s%q: expected:\n%s%v\ngot:\n%s%v", grammar, buf.String(), expected.DumpToString(false), expected.ErrorN(20),
+						got.DumpToString(false), got.ErrorN(20))
+					t.Fail()
+					return
+				}
+			}
+		}
+	}
+}